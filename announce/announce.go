@@ -0,0 +1,286 @@
+package announce
+
+import (
+	"container/heap"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/zones"
+)
+
+type Announcement struct {
+	ID       int    `json:"id"`
+	Clip     string `json:"clip"`
+	Priority int    `json:"priority"`
+	// Played in every zone too, they load it from aether at announce.url
+	Everywhere bool      `json:"everywhere"`
+	QueuedAt   time.Time `json:"queuedAt"`
+	path       string
+	temporary  bool
+	seq        int
+}
+
+var player *spotify.SpotifyPlayer
+
+var mu sync.Mutex
+var queue announcementQueue
+var wake = make(chan struct{}, 1)
+var lastID int
+var current *Announcement
+
+/*
+Start the announcement worker, state is used to find out how loud Spotify is playing so it can be restored afterwards.
+*/
+func Init(state *spotify.SpotifyPlayer) {
+	Log("Starting announcement worker")
+	player = state
+
+	go worker()
+}
+
+/*
+Queue a clip from the announcement directory by its file name, with everywhere it plays in every zone.
+*/
+func EnqueueClip(name string, priority int, everywhere bool) (*Announcement, error) {
+	path, err := ClipPath(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return enqueue(path, priority, everywhere, false), nil
+}
+
+/*
+Queue any audio file on disk, the file is left alone after playing.
+*/
+func EnqueuePath(path string, priority int, everywhere bool) *Announcement {
+	return enqueue(path, priority, everywhere, false)
+}
+
+/*
+Queue an uploaded file, the file is removed once it has been played.
+*/
+func EnqueueFile(path string, priority int, everywhere bool) *Announcement {
+	return enqueue(path, priority, everywhere, true)
+}
+
+/*
+Returns the full path of a clip in the announcement directory.
+*/
+func ClipPath(name string) (string, error) {
+	path := filepath.Join(utils.Cfg().Announce.Dir, filepath.Base(name))
+
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("clip %s not found", name)
+	}
+
+	if !audio.Supported(path) {
+		return "", fmt.Errorf("clip %s is not a supported audio file", name)
+	}
+
+	return path, nil
+}
+
+/*
+Returns the announcement that is playing right now (if any) followed by the waiting ones, in the order they will be played.
+*/
+func Queue() []Announcement {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var list []Announcement
+
+	if current != nil {
+		list = append(list, *current)
+	}
+
+	waiting := make(announcementQueue, len(queue))
+	copy(waiting, queue)
+
+	for waiting.Len() > 0 {
+		list = append(list, *heap.Pop(&waiting).(*Announcement))
+	}
+
+	return list
+}
+
+/*
+Returns the file of the announcement with id while it is waiting or playing, the zones fetch it from there.
+*/
+func File(id int) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current != nil && current.ID == id {
+		return current.path, true
+	}
+
+	for _, a := range queue {
+		if a.ID == id {
+			return a.path, true
+		}
+	}
+
+	return "", false
+}
+
+func enqueue(path string, priority int, everywhere bool, temporary bool) *Announcement {
+	mu.Lock()
+
+	lastID++
+	a := &Announcement{
+		ID:         lastID,
+		Clip:       filepath.Base(path),
+		Priority:   priority,
+		Everywhere: everywhere,
+		QueuedAt:   time.Now(),
+		path:       path,
+		temporary:  temporary,
+		seq:        lastID,
+	}
+	heap.Push(&queue, a)
+
+	mu.Unlock()
+
+	Log(fmt.Sprintf("Queued %s with priority %d", a.Clip, priority))
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+
+	return a
+}
+
+func worker() {
+	for range wake {
+		if !audio.Ready() {
+			fail("Local audio output is not available, dropping announcements")
+			drop()
+			continue
+		}
+
+		restore := duck()
+
+		for {
+			next := pop()
+
+			if next == nil {
+				break
+			}
+
+			restoreZones := func() {}
+
+			if next.Everywhere {
+				restoreZones = everywhere(next)
+			}
+
+			if err := audio.PlayAndWait(next.path); err != nil {
+				logger.Err("Could not play announcement "+next.Clip, err)
+			}
+
+			restoreZones()
+
+			if next.temporary {
+				os.Remove(next.path)
+			}
+
+			mu.Lock()
+			current = nil
+			mu.Unlock()
+		}
+
+		restore()
+	}
+}
+
+/*
+Play a in the other zones as well, they fetch it from aether. Returns a function that puts back their music.
+*/
+func everywhere(a *Announcement) func() {
+	base := utils.Cfg().Announce.URL
+
+	if base == "" {
+		fail("announce.url is not set, so " + a.Clip + " only plays here")
+		return func() {}
+	}
+
+	return zones.Announce(fmt.Sprintf("%s/announce/%d/audio", strings.TrimSuffix(base, "/"), a.ID))
+}
+
+func pop() *Announcement {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if queue.Len() == 0 {
+		return nil
+	}
+
+	next := heap.Pop(&queue).(*Announcement)
+	current = next
+
+	return next
+}
+
+func drop() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, a := range queue {
+		if a.temporary {
+			os.Remove(a.path)
+		}
+	}
+
+	queue = nil
+}
+
+/*
+Lower the Spotify volume (or pause it) so the announcement can be heard, returns a function that undoes this.
+*/
+func duck() func() {
+	wasPaused := player.Paused()
+
+	if wasPaused {
+		return func() {}
+	}
+
+	volume, ok := player.Volume()
+
+	if utils.Cfg().Announce.Mode == "pause" || !ok {
+		Log("Pausing Spotify for announcement")
+		spotify.Pause()
+
+		return func() {
+			Log("Resuming Spotify after announcement")
+			spotify.Resume()
+		}
+	}
+
+	ducked := int(float64(volume) * utils.Cfg().Announce.Duck)
+
+	Log(fmt.Sprintf("Ducking Spotify from %d to %d", volume, ducked))
+	spotify.FadeVolume(volume, ducked, utils.Cfg().Announce.Fade.Duration)
+
+	return func() {
+		Log(fmt.Sprintf("Restoring Spotify volume to %d", volume))
+		spotify.FadeVolume(ducked, volume, utils.Cfg().Announce.Fade.Duration)
+	}
+}
+
+func Log(str string) {
+	logger.Verbose("[Announce] " + str)
+}
+
+func fail(str string) {
+	logger.Warn("[Announce] " + str)
+}
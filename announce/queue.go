@@ -0,0 +1,30 @@
+package announce
+
+// announcementQueue is a heap of announcements, highest priority first and
+// first come first served within the same priority.
+type announcementQueue []*Announcement
+
+func (q announcementQueue) Len() int { return len(q) }
+
+func (q announcementQueue) Less(i, j int) bool {
+	if q[i].Priority != q[j].Priority {
+		return q[i].Priority > q[j].Priority
+	}
+
+	return q[i].seq < q[j].seq
+}
+
+func (q announcementQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *announcementQueue) Push(x any) {
+	*q = append(*q, x.(*Announcement))
+}
+
+func (q *announcementQueue) Pop() any {
+	old := *q
+	n := len(old)
+	a := old[n-1]
+	*q = old[:n-1]
+
+	return a
+}
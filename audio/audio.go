@@ -0,0 +1,166 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/flac"
+	"github.com/faiface/beep/mp3"
+	"github.com/faiface/beep/speaker"
+	"github.com/faiface/beep/wav"
+)
+
+// Returned when there is no local output to play on
+var ErrUnavailable = errors.New("local audio output is not available")
+
+var sampleRate beep.SampleRate
+var mixer *beep.Mixer
+var ready bool
+
+/*
+Initialize the local speaker. Everything played through this package is mixed together on one output,
+so a clip can play on top of another one.
+*/
+func Init() {
+	Log("Initializing local audio output")
+	sampleRate = beep.SampleRate(utils.Cfg().Audio.SampleRate)
+	mixer = &beep.Mixer{}
+
+	err := speaker.Init(sampleRate, sampleRate.N(utils.Cfg().Audio.Buffer.Duration))
+
+	if err != nil {
+		logger.Err("Could not open local audio output", err)
+		return
+	}
+
+	speaker.Play(mixer)
+	ready = true
+}
+
+/*
+Returns whether the local audio output is usable.
+*/
+func Ready() bool {
+	return ready
+}
+
+/*
+Returns the sample rate of the local output.
+*/
+func SampleRate() beep.SampleRate {
+	return sampleRate
+}
+
+/*
+Add a streamer to the local output, it keeps playing until it is drained.
+*/
+func Add(s beep.Streamer) error {
+	if !ready {
+		return ErrUnavailable
+	}
+
+	speaker.Lock()
+	mixer.Add(s)
+	speaker.Unlock()
+
+	return nil
+}
+
+/*
+Decode an audio file from disk, the decoder is picked based on the file extension (mp3, flac or wav).
+*/
+func Open(path string) (beep.StreamSeekCloser, beep.Format, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, beep.Format{}, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		return mp3.Decode(f)
+	case ".flac":
+		return flac.Decode(f)
+	case ".wav":
+		return wav.Decode(f)
+	}
+
+	f.Close()
+	return nil, beep.Format{}, fmt.Errorf("unsupported audio file %s", path)
+}
+
+/*
+Returns whether the file at path can be decoded by Open.
+*/
+func Supported(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3", ".flac", ".wav":
+		return true
+	}
+
+	return false
+}
+
+/*
+Play an audio file on the local output. The returned channel is closed once the file finished playing.
+*/
+func Play(path string) (<-chan struct{}, error) {
+	if !ready {
+		return nil, ErrUnavailable
+	}
+
+	streamer, format, err := Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+
+	Log("Playing " + path)
+
+	speaker.Lock()
+	mixer.Add(beep.Seq(Resample(streamer, format), beep.Callback(func() {
+		streamer.Close()
+		close(done)
+	})))
+	speaker.Unlock()
+
+	return done, nil
+}
+
+/*
+Play an audio file on the local output and block until it is done.
+*/
+func PlayAndWait(path string) error {
+	done, err := Play(path)
+
+	if err != nil {
+		return err
+	}
+
+	<-done
+
+	return nil
+}
+
+/*
+Convert a decoded stream to the sample rate of the local output.
+*/
+func Resample(s beep.Streamer, format beep.Format) beep.Streamer {
+	if format.SampleRate == sampleRate {
+		return s
+	}
+
+	return beep.Resample(4, format.SampleRate, sampleRate, s)
+}
+
+func Log(str string) {
+	logger.Verbose("[Audio] " + str)
+}
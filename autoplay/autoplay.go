@@ -0,0 +1,422 @@
+package autoplay
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/history"
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/local"
+	"github.com/ODDInvictus/aether/policy"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+What autoplay chose to play next and why.
+*/
+type Pick struct {
+	// spotify for recommendations, library for similar local tracks or fallback for the fallback playlist
+	Source string         `json:"source"`
+	Tracks []policy.Track `json:"tracks,omitempty"`
+	// The playlist that is loaded when the source is fallback
+	Context string `json:"context,omitempty"`
+	// The recently played tracks the pick is based on
+	Seeds []string `json:"seeds"`
+}
+
+// Picks never repeat one of the last this many played tracks
+const avoid = 50
+
+// Local tracks are played as a set, the local player has no events to pick the next one on
+const setSize = 10
+
+var mu sync.Mutex
+
+// The Spotify track autoplay queued to follow the last request
+var ahead string
+
+// What started playing last, the history only has it once its metadata is in
+var playing string
+
+/*
+Keep the music going once the requests run out. After the last request starts a track like the recent ones is
+queued behind it, it makes way again when another request comes in. When playback ends with nothing queued
+autoplay starts something itself.
+*/
+func Init() {
+	Log("Following the request queue")
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		for event := range events {
+			if !utils.Cfg().Autoplay.Enabled {
+				continue
+			}
+
+			data, _ := event.Data.(map[string]interface{})
+
+			switch event.Type {
+			case "requestPlaying":
+				if len(queue.List()) == 0 {
+					extend()
+				}
+			case "requestQueued":
+				// librespot plays its queue in order, the request should not wait behind the pick
+				withdraw()
+			case "trackChanged":
+				mu.Lock()
+				playing = fmt.Sprint(data["uri"])
+				ours := ahead != "" && ahead == playing
+
+				if ours {
+					ahead = ""
+				}
+				mu.Unlock()
+
+				if ours && len(queue.List()) == 0 {
+					extend()
+				}
+			case "contextChanged":
+				// Loading something new clears the librespot queue
+				mu.Lock()
+				ahead = ""
+				mu.Unlock()
+			case "playbackEnded":
+				if len(queue.List()) == 0 {
+					start()
+				}
+			}
+		}
+	}()
+}
+
+/*
+Returns the track autoplay queued to follow the requests, empty when there is none.
+*/
+func Ahead() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return ahead
+}
+
+/*
+Choose what to play next. Local tracks are only picked when spotifyOnly is not set, the fallback playlist is
+returned when nothing else is found. Returns nil when there is nothing at all.
+*/
+func Next(spotifyOnly bool) *Pick {
+	cfg := utils.Cfg().Autoplay
+	recent := history.List(avoid)
+	seeds := seedsOf(recent, cfg.Seeds)
+	skip := avoided(recent)
+
+	var names []string
+
+	for _, seed := range seeds {
+		names = append(names, seed.Name)
+	}
+
+	if cfg.Source != "library" {
+		if track, err := fromSpotify(seeds, skip); err != nil {
+			logger.Err("[Autoplay] Could not get recommendations", err)
+		} else if track != nil {
+			return &Pick{Source: "spotify", Tracks: []policy.Track{*track}, Seeds: names}
+		}
+	}
+
+	if cfg.Source != "spotify" && !spotifyOnly {
+		if tracks := fromLibrary(seeds, skip); len(tracks) > 0 {
+			return &Pick{Source: "library", Tracks: tracks, Seeds: names}
+		}
+	}
+
+	if playlist := utils.Cfg().Fallback.Playlist; playlist != "" && !spotifyOnly {
+		return &Pick{Source: "fallback", Context: playlist, Seeds: names}
+	}
+
+	return nil
+}
+
+/*
+Queue a Spotify pick behind what is playing, so it follows the last request instead of the context.
+*/
+func extend() {
+	if Ahead() != "" {
+		return
+	}
+
+	pick := Next(true)
+
+	if pick == nil {
+		Log("Nothing to queue after the requests, the context plays on")
+		return
+	}
+
+	uri := pick.Tracks[0].URI
+
+	if ok, err := spotify.AddToQueue(uri); !ok {
+		logger.Err("[Autoplay] Could not queue "+uri, err)
+		return
+	}
+
+	mu.Lock()
+	ahead = uri
+	mu.Unlock()
+
+	Log("Queued " + pick.Tracks[0].Name)
+	live.Publish("autoplay", pick)
+}
+
+/*
+Take the queued pick out of the librespot queue again. It is queued anew once the last request starts.
+*/
+func withdraw() {
+	mu.Lock()
+	uri := ahead
+	ahead = ""
+	mu.Unlock()
+
+	if uri == "" {
+		return
+	}
+
+	if ok, err := spotify.RemoveFromQueue(uri); !ok {
+		logger.Err("[Autoplay] Could not take "+uri+" out of the queue", err)
+		return
+	}
+
+	Log("Took " + uri + " out of the queue for a request")
+}
+
+/*
+Start playing a pick after playback ended.
+*/
+func start() {
+	pick := Next(false)
+
+	if pick == nil {
+		Log("Nothing to play, staying quiet")
+		return
+	}
+
+	var err error
+
+	switch pick.Source {
+	case "spotify":
+		_, err = spotify.Load(pick.Tracks[0].URI, true, false)
+	case "library":
+		var tracks []library.Track
+
+		for _, t := range pick.Tracks {
+			if track, ok := library.Lookup(t.URI); ok {
+				tracks = append(tracks, *track)
+			}
+		}
+
+		err = local.Play(tracks)
+	case "fallback":
+		_, err = spotify.Load(pick.Context, true, true)
+	}
+
+	if err != nil {
+		logger.Err("[Autoplay] Could not start the "+pick.Source+" pick", err)
+		return
+	}
+
+	Log(fmt.Sprintf("Playing a %s pick", pick.Source))
+	live.Publish("autoplay", pick)
+}
+
+/*
+Returns at most n of the recently played tracks, the ones that were requested first.
+*/
+func seedsOf(recent []history.Entry, n int) []history.Entry {
+	var requested, others []history.Entry
+
+	for _, entry := range recent {
+		if entry.Requester != "" {
+			requested = append(requested, entry)
+		} else {
+			others = append(others, entry)
+		}
+	}
+
+	seeds := append(requested, others...)
+
+	return seeds[:min(n, len(seeds))]
+}
+
+/*
+Returns the uris and ISRCs of what was played recently or is about to play.
+*/
+func avoided(recent []history.Entry) map[string]bool {
+	skip := map[string]bool{}
+
+	for _, entry := range recent {
+		skip[entry.URI] = true
+
+		if entry.ISRC != "" {
+			skip[entry.ISRC] = true
+		}
+	}
+
+	for _, request := range queue.List() {
+		skip[request.URI] = true
+
+		if request.ISRC != "" {
+			skip[request.ISRC] = true
+		}
+	}
+
+	mu.Lock()
+	skip[ahead] = true
+	skip[playing] = true
+	mu.Unlock()
+
+	return skip
+}
+
+/*
+Returns a recommendation for the Spotify seeds that may be played, nil when there is none.
+*/
+func fromSpotify(seeds []history.Entry, skip map[string]bool) (*policy.Track, error) {
+	var uris []string
+
+	for _, seed := range seeds {
+		if strings.HasPrefix(seed.URI, "spotify:track:") {
+			uris = append(uris, seed.URI)
+		}
+	}
+
+	if len(uris) == 0 {
+		return nil, nil
+	}
+
+	recommended, err := spotify.Recommendations(uris, 20)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []policy.Track
+
+	for _, rec := range recommended {
+		if skip[rec.URI] || (rec.ExternalIDs.ISRC != "" && skip[rec.ExternalIDs.ISRC]) {
+			continue
+		}
+
+		track := policy.Track{URI: rec.URI, Name: rec.Name, Album: rec.Album.Name, Duration: rec.Duration, Explicit: rec.Explicit}
+
+		for _, artist := range rec.Artists {
+			track.Artists = append(track.Artists, artist.Name)
+		}
+
+		if policy.Check(track) == nil {
+			allowed = append(allowed, track)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+
+	// The best few are all good, a little chance keeps autoplay from always taking the same turn
+	pick := allowed[rand.Intn(min(len(allowed), 5))]
+
+	return &pick, nil
+}
+
+/*
+Returns a set of local tracks like the seeds: by the same artists, of the same genre or with about the same BPM.
+The genres and tempo of a seed are known when its artist is in the library.
+*/
+func fromLibrary(seeds []history.Entry, skip map[string]bool) []policy.Track {
+	all := library.Tracks("", "")
+	artists := map[string]bool{}
+	genres := map[string]bool{}
+	var bpms []int
+
+	for _, seed := range seeds {
+		for _, artist := range seed.Artists {
+			artists[strings.ToLower(artist)] = true
+		}
+	}
+
+	for _, t := range all {
+		if artists[strings.ToLower(t.Artist)] || artists[strings.ToLower(t.AlbumArtist)] {
+			if t.Genre != "" {
+				genres[strings.ToLower(t.Genre)] = true
+			}
+
+			if t.BPM > 0 {
+				bpms = append(bpms, t.BPM)
+			}
+		}
+	}
+
+	scored := map[int][]policy.Track{}
+	best := 0
+
+	for _, t := range all {
+		if skip[t.URI] || (t.ISRC != "" && skip[t.ISRC]) {
+			continue
+		}
+
+		score := 0
+
+		if artists[strings.ToLower(t.Artist)] || artists[strings.ToLower(t.AlbumArtist)] {
+			score += 3
+		}
+
+		if t.Genre != "" && genres[strings.ToLower(t.Genre)] {
+			score += 2
+		}
+
+		for _, bpm := range bpms {
+			// Within 8%, about what a DJ can pitch a record
+			if t.BPM > 0 && abs(t.BPM-bpm)*100 <= bpm*8 {
+				score++
+				break
+			}
+		}
+
+		track := policy.FromLibrary(t)
+
+		if score == 0 || policy.Check(track) != nil {
+			continue
+		}
+
+		scored[score] = append(scored[score], track)
+		best = max(best, score)
+	}
+
+	// The closest matches first, shuffled so the set is not in library order
+	var set []policy.Track
+
+	for score := best; score > 0 && len(set) < setSize; score-- {
+		tracks := scored[score]
+		rand.Shuffle(len(tracks), func(i, j int) { tracks[i], tracks[j] = tracks[j], tracks[i] })
+		set = append(set, tracks[:min(len(tracks), setSize-len(set))]...)
+	}
+
+	return set
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+func Log(str string) {
+	logger.Verbose("[Autoplay] " + str)
+}
@@ -0,0 +1,120 @@
+package autoplay_test
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/autoplay"
+	"github.com/ODDInvictus/aether/history"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+const fallback = "spotify:playlist:37i9dQZF1DXcBWIGoYBM5M"
+
+var state spotify.SpotifyPlayer
+
+func TestMain(m *testing.M) {
+	os.Setenv("AETHER_FALLBACK_PLAYLIST", fallback)
+	os.Setenv("AETHER_QUEUE_RECENT", "0")
+	os.Setenv("AETHER_AUTOPLAY_SOURCE", "spotify")
+	utils.LoadConfig()
+
+	queue.Init()
+	history.Init()
+	autoplay.Init()
+
+	os.Exit(m.Run())
+}
+
+func fake(t *testing.T) *spotifytest.Server {
+	s := spotifytest.Start(t)
+
+	acdc := []spotify.Artist{{Name: "AC/DC"}}
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Artist: acdc, Duration: 208000})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Artist: acdc, Duration: 292000})
+	s.AddTrack("spotify:track:explicit", spotify.Track{Name: "Big Balls", Artist: acdc, Duration: 159000, Explicit: true})
+	s.AddContext("spotify:playlist:test", "spotify:track:two")
+	s.AddContext(fallback, "spotify:track:two")
+
+	s.Listen(t, &state)
+
+	return s
+}
+
+func TestAfterRequests(t *testing.T) {
+	s := fake(t)
+
+	// Autoplay does not pick what was played before, so every run gets a new track
+	similar := "spotify:track:" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	s.AddTrack(similar, spotify.Track{Name: "T.N.T.", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 214000})
+	s.SetRecommendations("spotify:track:explicit", similar)
+
+	// Only T.N.T. may be played
+	viper.Set("policy.profiles", map[string]any{"clean": map[string]any{"blockexplicit": true}})
+	viper.Set("policy.profile", "clean")
+	utils.LoadConfig()
+
+	t.Cleanup(func() {
+		viper.Set("policy.profile", "")
+		utils.LoadConfig()
+	})
+
+	spotify.Load("spotify:playlist:test", true, false)
+	spotifytest.Eventually(t, "the playlist to load", func() bool { return state.Snapshot().URI == "spotify:track:two" })
+
+	if _, err := queue.Add("spotify:track:one", "Jan"); err != nil {
+		t.Fatal(err)
+	}
+
+	spotify.Next()
+
+	spotifytest.Eventually(t, "a track to be queued after the request", func() bool {
+		return autoplay.Ahead() == similar && slices.Equal(s.State().Queue, []string{similar})
+	})
+
+	// A new request goes before the pick, which is queued again once the request plays
+	another := similar + "x"
+	s.AddTrack(another, spotify.Track{Name: "Back in Black", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 255000})
+
+	if _, err := queue.Add(another, "Piet"); err != nil {
+		t.Fatal(err)
+	}
+
+	spotifytest.Eventually(t, "the pick to make way", func() bool {
+		return autoplay.Ahead() == "" && slices.Equal(s.State().Queue, []string{another})
+	})
+
+	spotify.Next()
+
+	spotifytest.Eventually(t, "the pick to be queued again", func() bool {
+		return autoplay.Ahead() == similar && slices.Equal(s.State().Queue, []string{similar})
+	})
+
+	// The pick plays once the request is over, and there is nothing left to queue after it
+	spotify.Next()
+
+	spotifytest.Eventually(t, "the pick to play", func() bool { return state.Snapshot().URI == similar })
+
+	if len(s.State().Queue) != 0 {
+		t.Errorf("queued %v after playing T.N.T.", s.State().Queue)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	s := fake(t)
+
+	spotify.Load("spotify:track:one", true, false)
+	spotifytest.Eventually(t, "the track to load", func() bool { return state.Snapshot().URI == "spotify:track:one" })
+
+	// No recommendations and no library, so the fallback playlist starts when the track ends
+	spotify.Next()
+
+	spotifytest.Eventually(t, "the fallback playlist", func() bool { return s.State().Context == fallback && !s.State().Paused })
+}
@@ -0,0 +1,349 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/search"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+A message somebody sent in a chat.
+*/
+type Message struct {
+	// Ids as the transport knows them
+	ID      string
+	Channel string
+	User    string
+	// The name the user shows up with
+	Name string
+	Text string
+}
+
+/*
+A chat service the bridge talks through. Transports only move text around, the bridge handles the commands.
+*/
+type Transport interface {
+	// Short name like discord, used in requester names and in chat.users
+	Name() string
+	// Receive messages and pass them to handle until ctx is done or the connection breaks. Run is called again
+	// after an error, returning nil means the transport is done for good.
+	Run(ctx context.Context, handle func(Message)) error
+	Reply(to Message, text string) error
+	// Post a message where the transport announces new tracks, if it has such a place
+	Announce(text string) error
+}
+
+var player *spotify.SpotifyPlayer
+
+var mu sync.Mutex
+var transports []Transport
+
+// Who voted to skip the current track, by transport and user id
+var votes = map[string]bool{}
+var announced string
+
+/*
+Start the transports in the chat config and announce every new track in them.
+*/
+func Init(state *spotify.SpotifyPlayer) {
+	player = state
+	cfg := utils.Cfg().Chat
+
+	if cfg.Local {
+		Register(NewLocal(os.Stdin, os.Stdout))
+	}
+
+	if cfg.Discord.Token != "" {
+		Register(NewDiscord(cfg.Discord))
+	}
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		for event := range events {
+			if event.Type != "trackChanged" {
+				continue
+			}
+
+			if data, ok := event.Data.(map[string]interface{}); ok {
+				trackChanged(fmt.Sprint(data["uri"]))
+			}
+		}
+	}()
+}
+
+/*
+Start receiving messages from t. Transports that break are restarted with a growing delay.
+*/
+func Register(t Transport) {
+	mu.Lock()
+	transports = append(transports, t)
+	mu.Unlock()
+
+	Log("Starting " + t.Name())
+
+	go func() {
+		backoff := time.Second
+
+		for {
+			started := time.Now()
+			err := t.Run(context.Background(), func(msg Message) { receive(t, msg) })
+
+			if err == nil {
+				Log("Stopped " + t.Name())
+				return
+			}
+
+			if time.Since(started) > time.Minute {
+				backoff = time.Second
+			}
+
+			logger.Err(fmt.Sprintf("[Chat] %s broke, restarting in %s", t.Name(), backoff), err)
+			time.Sleep(backoff)
+
+			backoff = min(backoff*2, time.Minute)
+		}
+	}()
+}
+
+/*
+Handle a message, anything that is not a command is ignored.
+*/
+func receive(t Transport, msg Message) {
+	prefix := utils.Cfg().Chat.Prefix
+
+	if !strings.HasPrefix(msg.Text, prefix) {
+		return
+	}
+
+	name, args, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(msg.Text, prefix)), " ")
+	requester := Requester(t.Name(), msg)
+
+	Log(fmt.Sprintf("%s: %s", requester, msg.Text))
+
+	var reply string
+
+	switch strings.ToLower(name) {
+	case "play", "request":
+		reply = play(strings.TrimSpace(args), requester)
+	case "queue":
+		reply = list()
+	case "np", "nowplaying":
+		reply = nowPlaying()
+	case "skip":
+		reply = skip(t.Name() + ":" + msg.User)
+	case "help":
+		reply = fmt.Sprintf("%[1]splay <song or spotify link>, %[1]squeue, %[1]snp, %[1]sskip", prefix)
+	default:
+		return
+	}
+
+	if err := t.Reply(msg, reply); err != nil {
+		logger.Err("[Chat] Could not reply in "+t.Name(), err)
+	}
+}
+
+/*
+Returns the name requests of a chat user are made under, the name from chat.users when the user is listed there.
+Names are only shown, users are told apart by their id.
+*/
+func Requester(transport string, msg Message) string {
+	for _, user := range utils.Cfg().Chat.Users {
+		if user.Transport == transport && user.ID == msg.User {
+			return user.Name
+		}
+	}
+
+	return transport + ":" + msg.Name
+}
+
+/*
+Queue the best Spotify match for query, or the track behind a Spotify uri or link.
+*/
+func play(query string, requester string) string {
+	if query == "" {
+		return "What do you want to hear?"
+	}
+
+	uri := spotifyURI(query)
+
+	if uri == "" {
+		for _, result := range search.Search(query, []string{search.TypeTrack}, 0, 10).Results {
+			if result.Source == search.SourceSpotify {
+				uri = result.URI
+				break
+			}
+		}
+	}
+
+	if uri == "" {
+		return "Nothing found for " + query
+	}
+
+	if _, err := queue.Add(uri, requester); err != nil {
+		return "Could not queue that: " + err.Error()
+	}
+
+	return fmt.Sprintf("Queued %s (#%d)", describe(uri), len(queue.List()))
+}
+
+/*
+Returns the uri of a spotify:track: uri or an open.spotify.com track link, empty for anything else.
+*/
+func spotifyURI(text string) string {
+	if strings.HasPrefix(text, "spotify:track:") || strings.HasPrefix(text, "spotify:episode:") {
+		return text
+	}
+
+	for _, kind := range []string{"track", "episode"} {
+		if _, rest, ok := strings.Cut(text, "open.spotify.com/"+kind+"/"); ok {
+			id, _, _ := strings.Cut(rest, "?")
+			return "spotify:" + kind + ":" + id
+		}
+	}
+
+	return ""
+}
+
+func list() string {
+	requests := queue.List()
+
+	if len(requests) == 0 {
+		return "The queue is empty, " + utils.Cfg().Chat.Prefix + "play something!"
+	}
+
+	lines := []string{}
+
+	for i, request := range requests {
+		if i == 5 {
+			lines = append(lines, fmt.Sprintf("and %d more", len(requests)-i))
+			break
+		}
+
+		lines = append(lines, fmt.Sprintf("%d. %s, requested by %s", i+1, describe(request.URI), request.Requester))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func nowPlaying() string {
+	s := player.Snapshot()
+
+	if s.URI == "" {
+		return "Nothing is playing"
+	}
+
+	text := "Now playing " + describe(s.URI)
+
+	if requester := requestedBy(s.URI); requester != "" {
+		text += ", requested by " + requester
+	}
+
+	return text
+}
+
+/*
+Vote to skip the current track, it is skipped once chat.skipvotes people voted.
+*/
+func skip(user string) string {
+	needed := utils.Cfg().Chat.SkipVotes
+
+	mu.Lock()
+	votes[user] = true
+	count := len(votes)
+
+	if count >= needed {
+		votes = map[string]bool{}
+	}
+
+	mu.Unlock()
+
+	if count < needed {
+		return fmt.Sprintf("%d of %d votes to skip", count, needed)
+	}
+
+	if ok, err := spotify.Next(); !ok {
+		return fmt.Sprint("Could not skip: ", err)
+	}
+
+	return "Skipped!"
+}
+
+/*
+A new track started, the skip votes were for the previous one.
+*/
+func trackChanged(uri string) {
+	mu.Lock()
+	votes = map[string]bool{}
+
+	if uri == announced {
+		mu.Unlock()
+		return
+	}
+
+	announced = uri
+	list := append([]Transport{}, transports...)
+	mu.Unlock()
+
+	text := "Now playing " + describe(uri)
+
+	if requester := requestedBy(uri); requester != "" {
+		text += ", requested by " + requester
+	}
+
+	for _, t := range list {
+		if err := t.Announce(text); err != nil {
+			logger.Err("[Chat] Could not announce in "+t.Name(), err)
+		}
+	}
+}
+
+/*
+Returns who requested uri, when it was requested. The queue may not have seen the track change yet.
+*/
+func requestedBy(uri string) string {
+	if playing := queue.Playing(); playing != nil && playing.URI == uri {
+		return playing.Requester
+	}
+
+	for _, request := range queue.List() {
+		if request.URI == uri {
+			return request.Requester
+		}
+	}
+
+	return ""
+}
+
+/*
+Returns "title by artists", or just the uri when the metadata can't be found.
+*/
+func describe(uri string) string {
+	track, err := spotify.TrackMetadata(uri)
+
+	if err != nil {
+		return uri
+	}
+
+	var artists []string
+
+	for _, artist := range track.Artist {
+		artists = append(artists, artist.Name)
+	}
+
+	return track.Name + " by " + strings.Join(artists, ", ")
+}
+
+func Log(str string) {
+	logger.Verbose("[Chat] " + str)
+}
@@ -0,0 +1,203 @@
+package chat_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ODDInvictus/aether/chat"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+/*
+Everything the local chat wrote.
+*/
+type transcript struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (t *transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.buf.Write(p)
+}
+
+func (t *transcript) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf.Reset()
+}
+
+func (t *transcript) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.buf.String()
+}
+
+var state spotify.SpotifyPlayer
+var out transcript
+var local *chat.Local
+
+func TestMain(m *testing.M) {
+	os.Setenv("AETHER_CHAT_SKIPVOTES", "2")
+	utils.LoadConfig()
+
+	queue.Init()
+	chat.Init(&state)
+
+	local = chat.NewLocal(nil, &out)
+	chat.Register(local)
+
+	os.Exit(m.Run())
+}
+
+/*
+A chat where people can share a name, unlike the local one.
+*/
+type room struct {
+	ready   chan struct{}
+	handle  func(chat.Message)
+	mu      sync.Mutex
+	replies []string
+}
+
+func (r *room) Name() string {
+	return "room"
+}
+
+func (r *room) Run(ctx context.Context, handle func(chat.Message)) error {
+	r.handle = handle
+	close(r.ready)
+	<-ctx.Done()
+
+	return nil
+}
+
+func (r *room) Reply(to chat.Message, text string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.replies = append(r.replies, text)
+
+	return nil
+}
+
+func (r *room) Announce(text string) error {
+	return nil
+}
+
+func (r *room) say(user string, name string, text string) []string {
+	<-r.ready
+
+	r.mu.Lock()
+	r.replies = nil
+	r.mu.Unlock()
+
+	r.handle(chat.Message{Channel: "room", User: user, Name: name, Text: text})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.replies
+}
+
+func TestChat(t *testing.T) {
+	s := spotifytest.Start(t)
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Artist: []spotify.Artist{{Name: "AC/DC"}}})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Artist: []spotify.Artist{{Name: "AC/DC"}}})
+	s.AddContext("spotify:playlist:test", "spotify:track:one")
+
+	out.Reset()
+
+	viper.Set("chat.users", []map[string]any{{"transport": "local", "id": "piet", "name": "Piet"}})
+	utils.LoadConfig()
+
+	s.Listen(t, &state)
+
+	spotify.Load("spotify:playlist:test", true, false)
+	spotifytest.Eventually(t, "the track to be announced", func() bool {
+		return strings.Contains(out.String(), "Now playing Highway to Hell by AC/DC\n")
+	})
+
+	if replies := local.Say("jan", "!np"); !slices.Equal(replies, []string{"Now playing Highway to Hell by AC/DC"}) {
+		t.Errorf("!np: %v", replies)
+	}
+
+	if replies := local.Say("jan", "!play thunderstruck"); !slices.Equal(replies, []string{"Queued Thunderstruck by AC/DC (#1)"}) {
+		t.Fatalf("!play: %v", replies)
+	}
+
+	if !slices.Equal(s.State().Queue, []string{"spotify:track:two"}) {
+		t.Fatalf("the queue is %v", s.State().Queue)
+	}
+
+	if replies := local.Say("jan", "!queue"); len(replies) != 1 || !strings.Contains(replies[0], "Thunderstruck by AC/DC, requested by local:jan") {
+		t.Errorf("!queue: %v", replies)
+	}
+
+	if replies := local.Say("jan", "hello everyone"); len(replies) != 0 {
+		t.Errorf("a normal message got replies: %v", replies)
+	}
+
+	// Voting twice does not count
+	local.Say("jan", "!skip")
+
+	if replies := local.Say("jan", "!skip"); !slices.Equal(replies, []string{"1 of 2 votes to skip"}) {
+		t.Errorf("second !skip: %v", replies)
+	}
+
+	if replies := local.Say("piet", "!skip"); !slices.Equal(replies, []string{"Skipped!"}) {
+		t.Errorf("!skip by Piet: %v", replies)
+	}
+
+	spotifytest.Eventually(t, "the request to be announced", func() bool {
+		return strings.Contains(out.String(), "Now playing Thunderstruck by AC/DC, requested by local:jan\n")
+	})
+
+	if !strings.Contains(out.String(), "@piet Skipped!\n") {
+		t.Errorf("the transcript is %s", out.String())
+	}
+
+	if got := chat.Requester("local", chat.Message{User: "piet", Name: "piet"}); got != "Piet" {
+		t.Errorf("piet requests as %s", got)
+	}
+}
+
+func TestSkipVotes(t *testing.T) {
+	s := spotifytest.Start(t)
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell"})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck"})
+	s.AddContext("spotify:playlist:test", "spotify:track:one", "spotify:track:two")
+	spotify.Load("spotify:playlist:test", true, false)
+
+	r := &room{ready: make(chan struct{})}
+	chat.Register(r)
+
+	// Changing your name does not give you another vote
+	r.say("1", "Jan", "!skip")
+
+	if replies := r.say("1", "Jan de Vries", "!skip"); !slices.Equal(replies, []string{"1 of 2 votes to skip"}) {
+		t.Fatalf("!skip under another name: %v", replies)
+	}
+
+	// Somebody else called Jan does get one
+	if replies := r.say("2", "Jan", "!skip"); !slices.Equal(replies, []string{"Skipped!"}) {
+		t.Fatalf("!skip by the other Jan: %v", replies)
+	}
+
+	if s.State().Track != "spotify:track:two" {
+		t.Errorf("playing %s after the vote", s.State().Track)
+	}
+}
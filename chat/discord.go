@@ -0,0 +1,258 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/gorilla/websocket"
+)
+
+const discordAPI = "https://discord.com/api/v10"
+const discordGateway = "wss://gateway.discord.gg/?v=10&encoding=json"
+
+// GUILD_MESSAGES, DIRECT_MESSAGES and MESSAGE_CONTENT, the bot needs the message content intent enabled
+const discordIntents = 1<<9 | 1<<12 | 1<<15
+
+/*
+A Discord bot, it reads messages through the gateway and answers through the REST api.
+*/
+type Discord struct {
+	cfg    utils.DiscordConfig
+	client http.Client
+}
+
+/*
+A message on the gateway, see https://discord.com/developers/docs/topics/gateway-events
+*/
+type gatewayPayload struct {
+	Op   int             `json:"op"`
+	Data json.RawMessage `json:"d,omitempty"`
+	Seq  *int64          `json:"s,omitempty"`
+	Type string          `json:"t,omitempty"`
+}
+
+type discordMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	Content   string `json:"content"`
+	Author    struct {
+		ID         string `json:"id"`
+		Username   string `json:"username"`
+		GlobalName string `json:"global_name"`
+		Bot        bool   `json:"bot"`
+	} `json:"author"`
+}
+
+func NewDiscord(cfg utils.DiscordConfig) *Discord {
+	return &Discord{cfg: cfg, client: http.Client{Timeout: 10 * time.Second}}
+}
+
+func (d *Discord) Name() string {
+	return "discord"
+}
+
+/*
+Connect to the gateway, identify and keep the heartbeat going while passing on messages. Any trouble ends the
+connection, the bridge connects again with a new session.
+*/
+func (d *Discord) Run(ctx context.Context, handle func(Message)) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, discordGateway, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	var hello struct {
+		HeartbeatInterval int `json:"heartbeat_interval"`
+	}
+
+	var payload gatewayPayload
+
+	if err := conn.ReadJSON(&payload); err != nil {
+		return err
+	}
+
+	if payload.Op != 10 || json.Unmarshal(payload.Data, &hello) != nil || hello.HeartbeatInterval <= 0 {
+		return fmt.Errorf("expected hello from the gateway, got op %d", payload.Op)
+	}
+
+	// The heartbeat and the reader both write
+	var writeMu sync.Mutex
+	var seq *int64
+
+	write := func(op int, data any) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		encoded, err := json.Marshal(data)
+
+		if err != nil {
+			return err
+		}
+
+		return conn.WriteJSON(gatewayPayload{Op: op, Data: encoded})
+	}
+
+	heartbeat := func() error {
+		writeMu.Lock()
+		last := seq
+		writeMu.Unlock()
+
+		return write(1, last)
+	}
+
+	identify := map[string]any{
+		"token":   d.cfg.Token,
+		"intents": discordIntents,
+		"properties": map[string]string{
+			"os":      "linux",
+			"browser": "aether",
+			"device":  "aether",
+		},
+	}
+
+	if err := write(2, identify); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(hello.HeartbeatInterval) * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if heartbeat() != nil {
+					conn.Close()
+					return
+				}
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		var payload gatewayPayload
+
+		if err := conn.ReadJSON(&payload); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		if payload.Seq != nil {
+			writeMu.Lock()
+			seq = payload.Seq
+			writeMu.Unlock()
+		}
+
+		switch payload.Op {
+		case 0:
+			if payload.Type == "READY" {
+				Log("Connected to Discord")
+			}
+
+			if payload.Type == "MESSAGE_CREATE" {
+				d.dispatch(payload.Data, handle)
+			}
+		case 1:
+			if err := heartbeat(); err != nil {
+				return err
+			}
+		case 7:
+			return errors.New("discord asked to reconnect")
+		case 9:
+			return errors.New("discord invalidated the session")
+		}
+	}
+}
+
+func (d *Discord) dispatch(data json.RawMessage, handle func(Message)) {
+	var m discordMessage
+
+	if json.Unmarshal(data, &m) != nil || m.Author.Bot {
+		return
+	}
+
+	if len(d.cfg.Channels) > 0 && !slices.Contains(d.cfg.Channels, m.ChannelID) {
+		return
+	}
+
+	name := m.Author.GlobalName
+
+	if name == "" {
+		name = m.Author.Username
+	}
+
+	handle(Message{ID: m.ID, Channel: m.ChannelID, User: m.Author.ID, Name: name, Text: m.Content})
+}
+
+func (d *Discord) Reply(to Message, text string) error {
+	return d.send(to.Channel, map[string]any{
+		"content":           text,
+		"message_reference": map[string]string{"message_id": to.ID},
+		"allowed_mentions":  map[string]any{"parse": []string{}},
+	})
+}
+
+func (d *Discord) Announce(text string) error {
+	if d.cfg.Announce == "" {
+		return nil
+	}
+
+	return d.send(d.cfg.Announce, map[string]any{
+		"content":          text,
+		"allowed_mentions": map[string]any{"parse": []string{}},
+	})
+}
+
+func (d *Discord) send(channel string, message map[string]any) error {
+	body, err := json.Marshal(message)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, discordAPI+"/channels/"+channel+"/messages", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bot "+d.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DiscordBot (https://github.com/ODDInvictus/aether, 1.0)")
+
+	resp, err := d.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("discord responded with " + resp.Status)
+	}
+
+	return nil
+}
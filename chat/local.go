@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+/*
+A stand-in for a real chat, for trying out the commands and for tests. Messages are read from in as
+"user: text" lines or passed to Say, everything the bridge sends is written to out.
+*/
+type Local struct {
+	in  io.Reader
+	out io.Writer
+
+	mu     sync.Mutex
+	handle func(Message)
+	// Closed once Run is called, Say waits for it
+	ready chan struct{}
+	once  sync.Once
+	// Replies to the Say calls that are running, by user
+	replies map[string][]string
+}
+
+var local *Local
+
+/*
+Returns a local chat reading from in and writing to out, in may be nil to only use Say.
+*/
+func NewLocal(in io.Reader, out io.Writer) *Local {
+	l := &Local{in: in, out: out, replies: map[string][]string{}, ready: make(chan struct{})}
+	local = l
+
+	return l
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+func (l *Local) Run(ctx context.Context, handle func(Message)) error {
+	l.mu.Lock()
+	l.handle = handle
+	l.mu.Unlock()
+
+	l.once.Do(func() { close(l.ready) })
+
+	if l.in == nil {
+		<-ctx.Done()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(l.in)
+
+	for scanner.Scan() {
+		user, text, ok := strings.Cut(scanner.Text(), ":")
+
+		if !ok {
+			fmt.Fprintln(l.out, `expected "user: text"`)
+			continue
+		}
+
+		handle(Message{Channel: "local", User: strings.TrimSpace(user), Name: strings.TrimSpace(user), Text: strings.TrimSpace(text)})
+	}
+
+	return scanner.Err()
+}
+
+func (l *Local) Reply(to Message, text string) error {
+	l.mu.Lock()
+	if replies, ok := l.replies[to.User]; ok {
+		l.replies[to.User] = append(replies, text)
+	}
+	l.mu.Unlock()
+
+	_, err := fmt.Fprintf(l.out, "@%s %s\n", to.Name, text)
+
+	return err
+}
+
+func (l *Local) Announce(text string) error {
+	_, err := fmt.Fprintln(l.out, text)
+	return err
+}
+
+/*
+Send text as user and return the replies of the bridge.
+*/
+func (l *Local) Say(user string, text string) []string {
+	<-l.ready
+
+	l.mu.Lock()
+	handle := l.handle
+	l.replies[user] = []string{}
+	l.mu.Unlock()
+
+	handle(Message{Channel: "local", User: user, Name: user, Text: text})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	replies := l.replies[user]
+	delete(l.replies, user)
+
+	return replies
+}
+
+/*
+Send text as user through the local chat, when chat.local is on.
+*/
+func Say(user string, text string) ([]string, error) {
+	if local == nil {
+		return nil, errors.New("the local chat is not enabled")
+	}
+
+	return local.Say(user, text), nil
+}
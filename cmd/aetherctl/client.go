@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+A small client for the aether HTTP API.
+*/
+type client struct {
+	server string
+	user   string
+	http   *http.Client
+}
+
+type apiError struct {
+	Status  int
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("aether responded with %d", e.Status)
+	}
+
+	return fmt.Sprintf("aether responded with %d: %s", e.Status, e.Message)
+}
+
+func newClient(server string, user string) *client {
+	return &client{
+		server: strings.TrimSuffix(server, "/"),
+		user:   user,
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *client) get(path string, query url.Values, v any) error {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	return c.do(http.MethodGet, path, nil, v)
+}
+
+func (c *client) post(path string, form url.Values, v any) error {
+	return c.do(http.MethodPost, path, form, v)
+}
+
+func (c *client) delete(path string, v any) error {
+	return c.do(http.MethodDelete, path, nil, v)
+}
+
+func (c *client) do(method string, path string, form url.Values, v any) error {
+	var body io.Reader
+
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, c.server+path, body)
+
+	if err != nil {
+		return err
+	}
+
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	if c.user != "" {
+		req.Header.Set("X-Aether-User", c.user)
+	}
+
+	resp, err := c.http.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &apiError{Status: resp.StatusCode}
+		json.Unmarshal(data, apiErr)
+
+		// Some errors still come with a useful body, like /health telling what is down
+		if v != nil {
+			json.Unmarshal(data, v)
+		}
+
+		return apiErr
+	}
+
+	if v == nil || len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+type event struct {
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+/*
+Follow the live event stream, handle is called for every event until it returns false or the stream ends.
+*/
+func (c *client) follow(handle func(event) bool) error {
+	req, err := http.NewRequest(http.MethodGet, c.server+"/live", nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The default client times out, which would end the stream
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &apiError{Status: resp.StatusCode}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var e event
+
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &e); err != nil {
+			continue
+		}
+
+		if !handle(e) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
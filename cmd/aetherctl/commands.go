@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// librespot volumes go from 0 to 65536, on the command line we use percentages
+const maxVolume = 65536
+
+func statusCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show what is playing",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var state struct {
+				State playerState `json:"state"`
+			}
+
+			if err := api.get("/player/state", nil, &state); err != nil {
+				return err
+			}
+
+			var q queueResponse
+
+			if err := api.get("/queue", nil, &q); err != nil {
+				return err
+			}
+
+			return output(state, func(t *table) {
+				s := state.State
+				status := "playing"
+
+				if s.Paused {
+					status = "paused"
+				}
+
+				t.row("Status", status)
+				t.row("Track", trackName(s.Track))
+				t.row("Album", s.Track.Album.Name)
+				t.row("Position", fmt.Sprintf("%s / %s", formatMs(s.position()), formatMs(int64(s.Track.Duration))))
+				t.row("Context", s.ContextURI)
+
+				if q.Playing != nil {
+					t.row("Requested by", q.Playing.Requester)
+				}
+
+				if s.HasVolume {
+					t.row("Volume", fmt.Sprintf("%d%%", s.Volume*100/maxVolume))
+				}
+
+				t.row("Queued", fmt.Sprint(len(q.Queue)))
+			})
+		},
+	}
+}
+
+func playCommand() *cobra.Command {
+	var shuffle bool
+
+	cmd := &cobra.Command{
+		Use:   "play <uri>",
+		Short: "Play a Spotify track, album or playlist",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return message(api.post("/player/play", url.Values{
+				"uri":     {args[0]},
+				"shuffle": {strconv.FormatBool(shuffle)},
+			}, nil), "Playing "+args[0])
+		},
+	}
+
+	cmd.Flags().BoolVar(&shuffle, "shuffle", false, "shuffle the context")
+
+	return cmd
+}
+
+func pauseCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "pause",
+		Short: "Pause playback",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return message(api.post("/player/pause", nil, nil), "Paused")
+		},
+	}
+}
+
+func resumeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume",
+		Short: "Resume playback",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return message(api.post("/player/resume", nil, nil), "Resumed")
+		},
+	}
+}
+
+func skipCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "skip",
+		Aliases: []string{"next"},
+		Short:   "Skip to the next track",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return message(api.post("/player/next", nil, nil), "Skipped")
+		},
+	}
+}
+
+type queueResponse struct {
+	Playing *request  `json:"playing"`
+	Queue   []request `json:"queue"`
+}
+
+func queueCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "queue",
+		Short: "Manage the request queue",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the requests that are waiting",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var q queueResponse
+
+			if err := api.get("/queue", nil, &q); err != nil {
+				return err
+			}
+
+			return output(q, func(t *table) {
+				t.header("ID", "URI", "REQUESTER", "REQUESTED")
+
+				for _, r := range q.Queue {
+					t.row(fmt.Sprint(r.ID), r.URI, r.Requester, since(r.RequestedAt))
+				}
+			})
+		},
+	}, &cobra.Command{
+		Use:   "add <uri>",
+		Short: "Request a track",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var res struct {
+				Request request `json:"request"`
+			}
+
+			if err := api.post("/queue", url.Values{"uri": {args[0]}}, &res); err != nil {
+				return err
+			}
+
+			return output(res, func(t *table) {
+				t.row("Queued", fmt.Sprintf("%s (id %d)", res.Request.URI, res.Request.ID))
+			})
+		},
+	}, &cobra.Command{
+		Use:               "rm <id>",
+		Aliases:           []string{"remove"},
+		Short:             "Remove a request",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRequests,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return message(api.delete("/queue/"+url.PathEscape(args[0]), nil), "Removed request "+args[0])
+		},
+	})
+
+	return cmd
+}
+
+/*
+Complete request ids from the live queue.
+*/
+func completeRequests(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var q queueResponse
+
+	if len(args) > 0 || newClient(server, user).get("/queue", nil, &q) != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var ids []string
+
+	for _, r := range q.Queue {
+		ids = append(ids, fmt.Sprintf("%d\t%s", r.ID, r.URI))
+	}
+
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+func volumeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "volume [percentage|up|down|+step]",
+		Short: "Show or change the volume",
+		Long:  "Show or change the volume. Negative steps need to follow --, like `aetherctl volume -- -5`.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				var res struct {
+					Volume int `json:"volume"`
+				}
+
+				if err := api.get("/player/volume", nil, &res); err != nil {
+					return err
+				}
+
+				return output(res, func(t *table) {
+					t.row("Volume", fmt.Sprintf("%d%%", res.Volume*100/maxVolume))
+				})
+			}
+
+			switch args[0] {
+			case "up":
+				args[0] = "+1"
+			case "down":
+				args[0] = "-1"
+			}
+
+			arg := strings.TrimSuffix(args[0], "%")
+			value, err := strconv.Atoi(arg)
+
+			if err != nil {
+				return fmt.Errorf("invalid volume %s", args[0])
+			}
+
+			if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-") {
+				return message(api.post("/player/volume", url.Values{"step": {fmt.Sprint(value)}}, nil), "Volume changed")
+			}
+
+			if value < 0 || value > 100 {
+				return fmt.Errorf("volume should be between 0 and 100")
+			}
+
+			return message(api.post("/player/volume", url.Values{"volume": {fmt.Sprint(value * maxVolume / 100)}}, nil), fmt.Sprintf("Volume set to %d%%", value))
+		},
+	}
+}
+
+func searchCommand() *cobra.Command {
+	var types string
+	var limit int
+	var offset int
+
+	cmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Search Spotify and the local library",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var page searchPage
+
+			err := api.get("/search", url.Values{
+				"q":      {strings.Join(args, " ")},
+				"type":   {types},
+				"limit":  {fmt.Sprint(limit)},
+				"offset": {fmt.Sprint(offset)},
+			}, &page)
+
+			if err != nil {
+				return err
+			}
+
+			return output(page, func(t *table) {
+				t.header("TYPE", "SOURCE", "NAME", "ARTISTS", "URI")
+
+				for _, r := range page.Results {
+					t.row(r.Type, r.Source, r.Name, strings.Join(r.Artists, ", "), r.URI)
+				}
+
+				t.footer(fmt.Sprintf("%d-%d of %d", page.Offset+1, page.Offset+len(page.Results), page.Total))
+
+				for source, err := range page.Errors {
+					t.footer(fmt.Sprintf("%s failed: %s", source, err))
+				}
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&types, "type", "t", "", "comma separated result types (track, album, artist, playlist)")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20, "results per page")
+	cmd.Flags().IntVar(&offset, "offset", 0, "skip this many results")
+
+	cmd.RegisterFlagCompletionFunc("type", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{typeTrack, typeAlbum, typeArtist, typePlaylist}, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	return cmd
+}
+
+func historyCommand() *cobra.Command {
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show recently played tracks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var res struct {
+				History []historyEntry `json:"history"`
+			}
+
+			if err := api.get("/history", url.Values{"limit": {fmt.Sprint(limit)}}, &res); err != nil {
+				return err
+			}
+
+			return output(res, func(t *table) {
+				t.header("PLAYED", "TRACK", "ARTISTS", "REQUESTER")
+
+				for _, e := range res.History {
+					t.row(e.PlayedAt.Format("15:04"), e.Name, strings.Join(e.Artists, ", "), e.Requester)
+				}
+			})
+		},
+	}
+
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20, "number of tracks")
+
+	return cmd
+}
+
+func healthCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "health",
+		Short: "Check the connections of aether",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var health map[string]bool
+
+			err := api.get("/health", nil, &health)
+
+			// Unhealthy is reported with a 503, the body still tells what is wrong
+			if apiErr, ok := err.(*apiError); ok && apiErr.Status == 503 {
+				err = nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			return output(health, func(t *table) {
+				t.header("CONNECTION", "STATUS")
+
+				for _, name := range sortedKeys(health) {
+					status := "ok"
+
+					if !health[name] {
+						status = "down"
+					}
+
+					t.row(name, status)
+				}
+			})
+		},
+	}
+}
+
+func watchCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "watch",
+		Short: "Follow the live event stream",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return api.follow(func(e event) bool {
+				if jsonOutput {
+					line, _ := json.Marshal(e)
+					fmt.Println(string(line))
+					return true
+				}
+
+				fmt.Printf("%s  %-26s %s\n", e.Time.Format("15:04:05"), e.Type, summarize(e))
+				return true
+			})
+		},
+	}
+}
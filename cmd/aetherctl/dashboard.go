@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+/*
+Everything the dashboard shows, only touched by the dashboard loop.
+*/
+type dashboard struct {
+	state     playerState
+	playing   *request
+	queue     []request
+	connected bool
+	health    map[string]bool
+	status    string
+}
+
+func dashboardCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "dashboard",
+		Short: "Full screen now playing view with playback controls",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fd := int(os.Stdin.Fd())
+
+			if !term.IsTerminal(fd) {
+				return fmt.Errorf("the dashboard needs a terminal")
+			}
+
+			old, err := term.MakeRaw(fd)
+
+			if err != nil {
+				return err
+			}
+
+			// Alternate screen without cursor, restored on the way out
+			fmt.Print("\x1b[?1049h\x1b[?25l")
+			defer func() {
+				fmt.Print("\x1b[?25h\x1b[?1049l")
+				term.Restore(fd, old)
+			}()
+
+			return runDashboard()
+		},
+	}
+}
+
+func runDashboard() error {
+	d := &dashboard{status: "connecting"}
+
+	events := make(chan event, 64)
+	disconnected := make(chan error, 1)
+	keys := make(chan byte)
+	health := make(chan map[string]bool)
+
+	go followForever(events, disconnected)
+	go readKeys(keys)
+	go checkHealth(health)
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		d.render()
+
+		select {
+		case e := <-events:
+			if e.Type == "state" {
+				d.connected = true
+				d.status = ""
+				d.refreshQueue()
+			}
+
+			d.apply(e)
+		case err := <-disconnected:
+			d.connected = false
+			d.status = fmt.Sprintf("stream lost (%s), reconnecting", err)
+		case h := <-health:
+			d.health = h
+		case key := <-keys:
+			if key == 'q' || key == 3 {
+				return nil
+			}
+
+			d.status = d.handleKey(key)
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+Keep following the live event stream, reconnecting after a short wait when it breaks.
+*/
+func followForever(events chan<- event, disconnected chan<- error) {
+	for {
+		err := api.follow(func(e event) bool {
+			events <- e
+			return true
+		})
+
+		if err == nil {
+			err = fmt.Errorf("stream ended")
+		}
+
+		disconnected <- err
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func readKeys(keys chan<- byte) {
+	buf := make([]byte, 1)
+
+	for {
+		if _, err := os.Stdin.Read(buf); err != nil {
+			return
+		}
+
+		keys <- buf[0]
+	}
+}
+
+func checkHealth(health chan<- map[string]bool) {
+	for {
+		var h map[string]bool
+		api.get("/health", nil, &h)
+		health <- h
+		time.Sleep(30 * time.Second)
+	}
+}
+
+func (d *dashboard) refreshQueue() {
+	var q queueResponse
+
+	if api.get("/queue", nil, &q) == nil {
+		d.playing = q.Playing
+		d.queue = q.Queue
+	}
+}
+
+/*
+Update the dashboard from a live event, these mirror what aether itself does with them.
+*/
+func (d *dashboard) apply(e event) {
+	var data struct {
+		URI       string          `json:"uri"`
+		TrackTime *int64          `json:"trackTime"`
+		Value     float64         `json:"value"`
+		Halted    bool            `json:"halted"`
+		Track     json.RawMessage `json:"track"`
+	}
+
+	json.Unmarshal(e.Data, &data)
+
+	s := &d.state
+
+	// trackTime is measured when the event is sent, so interpolation starts there
+	if data.TrackTime != nil {
+		s.TrackTime = *data.TrackTime
+		s.UpdatedAt = e.Time
+	}
+
+	switch e.Type {
+	case "state":
+		json.Unmarshal(e.Data, s)
+	case "contextChanged":
+		s.ContextURI = data.URI
+	case "trackChanged":
+		s.URI = data.URI
+		s.TrackTime = 0
+		s.UpdatedAt = e.Time
+	case "metadataAvailable":
+		json.Unmarshal(data.Track, &s.Track)
+	case "playbackPaused", "playbackEnded", "playbackFailed":
+		s.Paused = true
+	case "playbackResumed":
+		s.Paused = false
+	case "playbackHaltStateChanged":
+		s.Paused = data.Halted
+	case "volumeChanged":
+		s.Volume = int(data.Value * maxVolume)
+		s.HasVolume = true
+	case "requestQueued":
+		var r request
+		json.Unmarshal(e.Data, &r)
+		d.queue = append(d.queue, r)
+	case "requestRemoved", "requestPlaying":
+		var r request
+		json.Unmarshal(e.Data, &r)
+
+		for i := range d.queue {
+			if d.queue[i].ID == r.ID {
+				d.queue = append(d.queue[:i], d.queue[i+1:]...)
+				break
+			}
+		}
+
+		if e.Type == "requestPlaying" {
+			d.playing = &r
+		}
+	}
+
+	if e.Type == "trackChanged" && (d.playing == nil || d.playing.URI != data.URI) {
+		d.playing = nil
+	}
+}
+
+/*
+Run the command for a key, returns the message to show in the status line.
+*/
+func (d *dashboard) handleKey(key byte) string {
+	var err error
+
+	switch key {
+	case ' ', 'p':
+		if d.state.Paused {
+			err = api.post("/player/resume", nil, nil)
+		} else {
+			err = api.post("/player/pause", nil, nil)
+		}
+	case 'n', 's':
+		err = api.post("/player/next", nil, nil)
+	case 'b':
+		err = api.post("/player/prev", nil, nil)
+	case '+', '=':
+		err = api.post("/player/volume", url.Values{"step": {"1"}}, nil)
+	case '-', '_':
+		err = api.post("/player/volume", url.Values{"step": {"-1"}}, nil)
+	default:
+		return ""
+	}
+
+	if err != nil {
+		return err.Error()
+	}
+
+	return ""
+}
+
+func (d *dashboard) render() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	s := d.state
+	status := "playing"
+
+	if s.Paused {
+		status = "paused"
+	}
+
+	add("\x1b[1maether\x1b[0m  %s", status)
+	add("")
+	add("\x1b[1m%s\x1b[0m", fallback(s.Track.Name, "Nothing playing"))
+	add("%s", artistNames(s.Track))
+	add("\x1b[2m%s\x1b[0m", s.Track.Album.Name)
+
+	if d.playing != nil {
+		add("requested by \x1b[36m%s\x1b[0m", d.playing.Requester)
+	} else {
+		add("")
+	}
+
+	add("")
+
+	pos, total := s.position(), int64(s.Track.Duration)
+	add("%s %s %s", formatMs(pos), progressBar(pos, total, width-14), formatMs(total))
+
+	if s.HasVolume {
+		add("volume %s %d%%", progressBar(int64(s.Volume), maxVolume, 20), s.Volume*100/maxVolume)
+	} else {
+		add("volume unknown")
+	}
+
+	add("")
+	add("\x1b[1mUp next\x1b[0m")
+
+	if len(d.queue) == 0 {
+		add("\x1b[2m  no requests\x1b[0m")
+	}
+
+	// Leave room for the header above and the footer below
+	for i, r := range d.queue {
+		if len(lines) >= height-4 {
+			add("  … %d more", len(d.queue)-i)
+			break
+		}
+
+		add("  %2d. %-40s \x1b[36m%s\x1b[0m", i+1, r.URI, r.Requester)
+	}
+
+	for len(lines) < height-3 {
+		add("")
+	}
+
+	add("%s", d.connection())
+	add("\x1b[2m[space] pause/resume  [n] next  [b] previous  [+/-] volume  [q] quit\x1b[0m")
+	add("\x1b[33m%s\x1b[0m", d.status)
+
+	fmt.Print("\x1b[H")
+
+	// No newline after the last line, that would scroll the screen
+	fmt.Print(strings.Join(lines, "\x1b[K\r\n"), "\x1b[K\x1b[J")
+}
+
+func (d *dashboard) connection() string {
+	stream := "\x1b[32mlive\x1b[0m"
+
+	if !d.connected {
+		stream = "\x1b[31moffline\x1b[0m"
+	}
+
+	parts := []string{"stream " + stream}
+
+	for _, name := range sortedKeys(d.health) {
+		status := "\x1b[32mok\x1b[0m"
+
+		if !d.health[name] {
+			status = "\x1b[31mdown\x1b[0m"
+		}
+
+		parts = append(parts, name+" "+status)
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+func progressBar(value int64, total int64, width int) string {
+	if width < 1 {
+		return ""
+	}
+
+	filled := 0
+
+	if total > 0 {
+		filled = int(min(value, total) * int64(width) / total)
+	}
+
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+func artistNames(t track) string {
+	var names []string
+
+	for _, artist := range t.Artist {
+		names = append(names, artist.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func fallback(s string, otherwise string) string {
+	if s == "" {
+		return otherwise
+	}
+
+	return s
+}
@@ -0,0 +1,57 @@
+// aetherctl controls a running aether over its HTTP API.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var server string
+var user string
+var jsonOutput bool
+
+var api *client
+
+func main() {
+	root := &cobra.Command{
+		Use:           "aetherctl",
+		Short:         "Control the aether music player",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			api = newClient(server, user)
+		},
+	}
+
+	defaultServer := os.Getenv("AETHER_URL")
+
+	if defaultServer == "" {
+		defaultServer = "http://localhost:8080"
+	}
+
+	root.PersistentFlags().StringVarP(&server, "server", "s", defaultServer, "aether server url (or set AETHER_URL)")
+	root.PersistentFlags().StringVarP(&user, "user", "u", os.Getenv("USER"), "who is making the request")
+	root.PersistentFlags().BoolVar(&jsonOutput, "json", false, "print raw JSON instead of tables")
+
+	root.AddCommand(
+		statusCommand(),
+		playCommand(),
+		pauseCommand(),
+		resumeCommand(),
+		skipCommand(),
+		queueCommand(),
+		volumeCommand(),
+		searchCommand(),
+		historyCommand(),
+		healthCommand(),
+		watchCommand(),
+		dashboardCommand(),
+	)
+
+	if err := root.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "aetherctl:", err)
+		os.Exit(1)
+	}
+}
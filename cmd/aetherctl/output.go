@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type table struct {
+	w     *tabwriter.Writer
+	notes []string
+}
+
+func (t *table) header(columns ...string) {
+	fmt.Fprintln(t.w, strings.Join(columns, "\t"))
+}
+
+func (t *table) row(columns ...string) {
+	fmt.Fprintln(t.w, strings.Join(columns, "\t"))
+}
+
+/*
+Add a line that is printed below the table.
+*/
+func (t *table) footer(line string) {
+	t.notes = append(t.notes, line)
+}
+
+/*
+Print v as JSON when --json is given, otherwise as the table that render builds.
+*/
+func output(v any, render func(t *table)) error {
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(v)
+	}
+
+	t := &table{w: tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)}
+	render(t)
+
+	if err := t.w.Flush(); err != nil {
+		return err
+	}
+
+	for _, line := range t.notes {
+		fmt.Println(line)
+	}
+
+	return nil
+}
+
+/*
+Print msg if the call succeeded.
+*/
+func message(err error, msg string) error {
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		return output(map[string]string{"message": msg}, nil)
+	}
+
+	fmt.Println(msg)
+
+	return nil
+}
+
+func trackName(t track) string {
+	if t.Name == "" {
+		return "-"
+	}
+
+	return fmt.Sprintf("%s - %s", artistNames(t), t.Name)
+}
+
+func formatMs(ms int64) string {
+	d := time.Duration(ms) * time.Millisecond
+
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+func since(t time.Time) string {
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+/*
+Describe an event on one line for watch.
+*/
+func summarize(e event) string {
+	var data map[string]any
+
+	if json.Unmarshal(e.Data, &data) != nil {
+		return string(e.Data)
+	}
+
+	switch e.Type {
+	case "state":
+		var s playerState
+		json.Unmarshal(e.Data, &s)
+		return trackName(s.Track)
+	case "metadataAvailable":
+		var t struct {
+			Track track `json:"track"`
+		}
+		json.Unmarshal(e.Data, &t)
+		return trackName(t.Track)
+	case "requestQueued", "requestRemoved", "requestPlaying":
+		return fmt.Sprintf("%v by %v", data["uri"], data["requester"])
+	}
+
+	var parts []string
+
+	for _, key := range sortedKeys(keysOf(data)) {
+		if key == "event" {
+			continue
+		}
+
+		parts = append(parts, fmt.Sprintf("%s=%v", key, data[key]))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func keysOf(m map[string]any) map[string]bool {
+	keys := map[string]bool{}
+
+	for key := range m {
+		keys[key] = true
+	}
+
+	return keys
+}
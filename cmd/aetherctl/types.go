@@ -0,0 +1,96 @@
+package main
+
+import "time"
+
+// What the aether API sends, only the fields aetherctl uses. These are kept apart from the server packages so the
+// client builds without the audio output and everything else the server needs.
+
+const (
+	typeTrack    = "track"
+	typeAlbum    = "album"
+	typeArtist   = "artist"
+	typePlaylist = "playlist"
+)
+
+type playerState struct {
+	URI        string    `json:"uri"`
+	ContextURI string    `json:"contextUri"`
+	Paused     bool      `json:"paused"`
+	TrackTime  int64     `json:"trackTime"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Volume     int       `json:"volume"`
+	HasVolume  bool      `json:"hasVolume"`
+	Track      track     `json:"track"`
+}
+
+/*
+Returns the position in ms right now, TrackTime was measured at UpdatedAt.
+*/
+func (s playerState) position() int64 {
+	pos := s.TrackTime
+
+	if !s.Paused && !s.UpdatedAt.IsZero() {
+		pos += time.Since(s.UpdatedAt).Milliseconds()
+	}
+
+	if s.Track.Duration > 0 && pos > int64(s.Track.Duration) {
+		pos = int64(s.Track.Duration)
+	}
+
+	return pos
+}
+
+type track struct {
+	Name     string  `json:"name"`
+	Album    named   `json:"album"`
+	Artist   []named `json:"artist"`
+	Duration int     `json:"duration"` // ms
+}
+
+type named struct {
+	Name string `json:"name"`
+}
+
+type request struct {
+	ID          int       `json:"id"`
+	URI         string    `json:"uri"`
+	ISRC        string    `json:"isrc,omitempty"`
+	Requester   string    `json:"requester"`
+	RequestedAt time.Time `json:"requestedAt"`
+	Merged      []string  `json:"merged,omitempty"`
+}
+
+type historyEntry struct {
+	URI       string    `json:"uri"`
+	Name      string    `json:"name"`
+	Artists   []string  `json:"artists"`
+	Album     string    `json:"album"`
+	Duration  int       `json:"duration"` // ms
+	ISRC      string    `json:"isrc,omitempty"`
+	Requester string    `json:"requester,omitempty"`
+	PlayedAt  time.Time `json:"playedAt"`
+}
+
+type searchPage struct {
+	Query   string            `json:"query"`
+	Results []searchResult    `json:"results"`
+	Total   int               `json:"total"`
+	Offset  int               `json:"offset"`
+	Limit   int               `json:"limit"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+type searchResult struct {
+	Type         string   `json:"type"`
+	Source       string   `json:"source"`
+	URI          string   `json:"uri"`
+	Name         string   `json:"name"`
+	Artists      []string `json:"artists,omitempty"`
+	Album        string   `json:"album,omitempty"`
+	Image        string   `json:"image,omitempty"`
+	Duration     int      `json:"duration,omitempty"` // ms
+	ISRC         string   `json:"isrc,omitempty"`
+	Owner        string   `json:"owner,omitempty"`
+	Alternatives []string `json:"alternatives,omitempty"`
+	Score        float64  `json:"score"`
+}
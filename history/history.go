@@ -0,0 +1,221 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+type Entry struct {
+	URI       string    `json:"uri"`
+	Name      string    `json:"name"`
+	Artists   []string  `json:"artists"`
+	Album     string    `json:"album"`
+	Duration  int       `json:"duration"` // ms
+	ISRC      string    `json:"isrc,omitempty"`
+	Requester string    `json:"requester,omitempty"`
+	PlayedAt  time.Time `json:"playedAt"`
+	Heard     int       `json:"heard,omitempty"` // ms, set once the next track starts
+}
+
+// Like a scrobble, a track counts as heard after half its duration or this long, whichever comes first
+const maxListen = 4 * time.Minute
+
+/*
+Returns whether the track was played long enough to count, tracks that are still playing always do.
+*/
+func (e Entry) Listened() bool {
+	if e.Heard == 0 {
+		return true
+	}
+
+	return time.Duration(e.Heard)*time.Millisecond >= min(time.Duration(e.Duration)*time.Millisecond/2, maxListen)
+}
+
+var mu sync.Mutex
+var entries []Entry
+
+/*
+Start recording every track that is played.
+*/
+func Init() {
+	Log("Recording play history")
+
+	if err := utils.ReadJSON(utils.Cfg().History.File, &entries); err != nil {
+		logger.Err("Could not restore the play history", err)
+	}
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		var uri string
+		requesters := map[string]string{}
+
+		// How long uri was heard, the pauses left out
+		var heard time.Duration
+		var since time.Time
+		paused := false
+
+		stop := func() {
+			if !paused {
+				heard += time.Since(since)
+			}
+
+			finish(uri, heard)
+		}
+
+		for event := range events {
+			data, _ := event.Data.(map[string]interface{})
+
+			switch event.Type {
+			case "trackChanged":
+				stop()
+				uri = fmt.Sprint(data["uri"])
+				heard, since, paused = 0, time.Now(), false
+			case "playbackPaused":
+				if !paused {
+					heard += time.Since(since)
+					paused = true
+				}
+			case "playbackResumed":
+				if paused {
+					since = time.Now()
+					paused = false
+				}
+			case "playbackEnded":
+				stop()
+				uri = ""
+			case "requestPlaying":
+				request, _ := json.Marshal(event.Data)
+				var r struct {
+					URI       string `json:"uri"`
+					Requester string `json:"requester"`
+				}
+				json.Unmarshal(request, &r)
+
+				requesters[r.URI] = r.Requester
+				setRequester(r.URI, r.Requester)
+			case "metadataAvailable":
+				var track spotify.Track
+
+				raw, _ := json.Marshal(data["track"])
+				if json.Unmarshal(raw, &track) != nil || uri == "" {
+					continue
+				}
+
+				record(uri, track, requesters[uri])
+				delete(requesters, uri)
+			}
+		}
+	}()
+}
+
+/*
+Returns at most limit played tracks, most recent first.
+*/
+func List(limit int) []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := []Entry{}
+
+	for i := len(entries) - 1; i >= 0 && len(list) < limit; i-- {
+		list = append(list, entries[i])
+	}
+
+	return list
+}
+
+/*
+Returns all tracks played after t, most recent first.
+*/
+func Since(t time.Time) []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := []Entry{}
+
+	for i := len(entries) - 1; i >= 0 && entries[i].PlayedAt.After(t); i-- {
+		list = append(list, entries[i])
+	}
+
+	return list
+}
+
+func record(uri string, track spotify.Track, requester string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// librespot sends metadata again when it is refreshed, that is not a new play
+	if n := len(entries); n > 0 && entries[n-1].URI == uri {
+		if time.Since(entries[n-1].PlayedAt) < time.Duration(entries[n-1].Duration)*time.Millisecond {
+			return
+		}
+	}
+
+	entry := Entry{
+		URI:       uri,
+		Name:      track.Name,
+		Album:     track.Album.Name,
+		Duration:  track.Duration,
+		ISRC:      track.ISRC(),
+		Requester: requester,
+		PlayedAt:  time.Now(),
+	}
+
+	for _, artist := range track.Artist {
+		entry.Artists = append(entry.Artists, artist.Name)
+	}
+
+	entries = append(entries, entry)
+
+	if size := utils.Cfg().History.Size; len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+
+	Log("Played " + entry.Name)
+}
+
+/*
+Store the history in history.file, it is read back when aether starts.
+*/
+func Save() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return utils.WriteJSON(utils.Cfg().History.File, entries)
+}
+
+/*
+Note how long the last entry was heard, when it is uri.
+*/
+func finish(uri string, heard time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if n := len(entries); n > 0 && entries[n-1].URI == uri && entries[n-1].Heard == 0 {
+		entries[n-1].Heard = max(int(heard.Milliseconds()), 1)
+	}
+}
+
+/*
+The request can be reported after the metadata arrived, so fill in the requester of the last entry afterwards.
+*/
+func setRequester(uri string, requester string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if n := len(entries); n > 0 && entries[n-1].URI == uri {
+		entries[n-1].Requester = requester
+	}
+}
+
+func Log(str string) {
+	logger.Verbose("[History] " + str)
+}
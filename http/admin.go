@@ -0,0 +1,147 @@
+package http
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/policy"
+	"github.com/ODDInvictus/aether/supervisor"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/volume"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+func adminRoutes() {
+	admin := r.Group("/admin", adminOnly)
+
+	if utils.Cfg().Admin.Token == "" {
+		logger.Warn("[HTTP] admin.token is not set, the admin routes are refused")
+	}
+
+	// The running config, changes to the config file show up here once they are applied
+	r.GET("/config", adminOnly, func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"file":   viper.ConfigFileUsed(),
+			"config": utils.Cfg().Redacted(),
+		})
+	})
+
+	admin.GET("/librespot", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"status": supervisor.Current(),
+			"lines":  supervisor.Lines(),
+		})
+	})
+
+	admin.POST("/librespot/restart", func (c *gin.Context) {
+		if !supervisor.Restart() {
+			c.JSON(409, gin.H{
+				"message": "librespot is not started by aether",
+			})
+			return
+		}
+
+		c.JSON(202, gin.H{
+			"message": "Restarting librespot",
+		})
+	})
+
+	admin.GET("/volume", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"max":      volume.Limit(),
+			"cap":      volume.Cap(),
+			"schedule": volume.Schedule(),
+		})
+	})
+
+	admin.POST("/volume/max", func (c *gin.Context) {
+		var params VolumeMax
+
+		if c.ShouldBind(&params) != nil || params.Max == nil {
+			c.JSON(400, gin.H{
+				"message": "Invalid volume cap",
+			})
+			return
+		}
+
+		if err := volume.SetLimit(*params.Max); err != nil {
+			c.JSON(400, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+
+	admin.PUT("/volume/schedule", func (c *gin.Context) {
+		var params VolumeSchedule
+
+		if c.ShouldBindJSON(&params) != nil {
+			c.JSON(400, gin.H{
+				"message": "Invalid schedule",
+			})
+			return
+		}
+
+		if err := volume.SetSchedule(params.Schedule); err != nil {
+			c.JSON(400, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+
+	// Apply a profile regardless of the schedule, an empty profile hands control back to the config
+	admin.PUT("/policy/profile", func (c *gin.Context) {
+		var params PolicyOverride
+
+		if c.ShouldBind(&params) != nil {
+			c.JSON(400, gin.H{
+				"message": "Invalid profile",
+			})
+			return
+		}
+
+		if err := policy.SetOverride(params.Profile); err != nil {
+			c.JSON(404, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+}
+
+/*
+Only lets requests through with the admin token as bearer token. Without an admin.token in the config nobody is admin.
+*/
+func adminOnly(c *gin.Context) {
+	token := utils.Cfg().Admin.Token
+
+	if token == "" {
+		c.AbortWithStatusJSON(403, gin.H{
+			"message": "Set admin.token in the config to use the admin routes",
+		})
+		return
+	}
+
+	given := []byte(c.GetHeader("Authorization"))
+
+	if subtle.ConstantTimeCompare(given, []byte("Bearer "+token)) != 1 {
+		c.AbortWithStatusJSON(401, gin.H{
+			"message": "Invalid admin token",
+		})
+	}
+}
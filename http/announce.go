@@ -0,0 +1,116 @@
+package http
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ODDInvictus/aether/announce"
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func announceRoutes() {
+	r.GET("/announce", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"queue": announce.Queue(),
+		})
+	})
+
+	// The zones fetch what they announce here
+	r.GET("/announce/:id/audio", func (c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		path, ok := announce.File(id)
+
+		if !ok {
+			c.JSON(404, gin.H{
+				"message": "There is no such announcement",
+			})
+			return
+		}
+
+		c.File(path)
+	})
+
+	r.POST("/announce", func (c *gin.Context) {
+		var params AnnouncePlay
+
+		if c.ShouldBind(&params) != nil {
+			c.JSON(400, gin.H{
+				"message": "Invalid announcement",
+			})
+			return
+		}
+
+		// The worker would drop it right away
+		if !audio.Ready() {
+			c.JSON(503, gin.H{
+				"message": fmt.Sprint(audio.ErrUnavailable),
+			})
+			return
+		}
+
+		if params.Everywhere && utils.Cfg().Announce.URL == "" {
+			c.JSON(400, gin.H{
+				"message": "Set announce.url to announce everywhere",
+			})
+			return
+		}
+
+		// Either an uploaded file or the name of a clip in the announcement directory
+		if file, err := c.FormFile("file"); err == nil {
+			if !audio.Supported(file.Filename) {
+				c.JSON(400, gin.H{
+					"message": "Only mp3, flac and wav files can be announced",
+				})
+				return
+			}
+
+			tmp, err := os.CreateTemp("", "aether-announce-*" + filepath.Ext(file.Filename))
+
+			if err != nil {
+				c.JSON(500, gin.H{
+					"message": fmt.Sprint(err),
+				})
+				return
+			}
+
+			tmp.Close()
+
+			if err := c.SaveUploadedFile(file, tmp.Name()); err != nil {
+				os.Remove(tmp.Name())
+				c.JSON(500, gin.H{
+					"message": fmt.Sprint(err),
+				})
+				return
+			}
+
+			c.JSON(202, gin.H{
+				"announcement": announce.EnqueueFile(tmp.Name(), params.Priority, params.Everywhere),
+			})
+			return
+		}
+
+		if params.Clip == "" {
+			c.JSON(400, gin.H{
+				"message": "Either upload a file or specify a clip",
+			})
+			return
+		}
+
+		a, err := announce.EnqueueClip(params.Clip, params.Priority, params.Everywhere)
+
+		if err != nil {
+			c.JSON(404, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(202, gin.H{
+			"announcement": a,
+		})
+	})
+}
@@ -0,0 +1,35 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/ODDInvictus/aether/chat"
+	"github.com/gin-gonic/gin"
+)
+
+func chatRoutes() {
+	// Talk to the chat bridge like a chat user would, anyone who can post here can pose as any user
+	r.POST("/chat/say", adminOnly, func (c *gin.Context) {
+		var params ChatSay
+
+		if c.ShouldBind(&params) != nil || params.User == "" || params.Text == "" {
+			c.JSON(400, gin.H{
+				"message": "Invalid user or text",
+			})
+			return
+		}
+
+		replies, err := chat.Say(params.User, params.Text)
+
+		if err != nil {
+			c.JSON(404, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"replies": replies,
+		})
+	})
+}
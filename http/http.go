@@ -11,8 +11,11 @@ import (
 )
 
 var r *gin.Engine
+var player *spotify.SpotifyPlayer
+
+func Init(state *spotify.SpotifyPlayer) *gin.Engine {
+	player = state
 
-func Init() *gin.Engine {
 	r = gin.Default()
 
 	r.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
@@ -37,6 +40,22 @@ func Init() *gin.Engine {
   }))
 
 	apiRoutes()
+	playerRoutes()
+	queueRoutes()
+	announceRoutes()
+	soundboardRoutes()
+	libraryRoutes()
+	searchRoutes()
+	localRoutes()
+	adminRoutes()
+	sessionRoutes()
+	webhookRoutes()
+	chatRoutes()
+	lyricsRoutes()
+	policyRoutes()
+	zoneRoutes()
+	instanceRoutes()
+	uiRoutes()
 
 	return r
 }
@@ -93,4 +112,16 @@ func apiRoutes() {
 	r.POST("/skip", func (c *gin.Context) {
 		spotify.Next()
 	})
+}
+
+/*
+Returns who made the request, clients identify their user with the X-Aether-User header.
+Falls back to the client IP for anonymous requests.
+*/
+func requester(c *gin.Context) string {
+	if user := c.GetHeader("X-Aether-User"); user != "" {
+		return user
+	}
+
+	return c.ClientIP()
 }
\ No newline at end of file
@@ -0,0 +1,371 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/history"
+	aetherhttp "github.com/ODDInvictus/aether/http"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/volume"
+	"github.com/ODDInvictus/aether/zones"
+	"github.com/gin-gonic/gin"
+)
+
+var state spotify.SpotifyPlayer
+var router *gin.Engine
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	// The tests play the same few tracks over and over
+	os.Setenv("AETHER_QUEUE_RECENT", "0")
+	utils.LoadConfig()
+
+	queue.Init()
+	history.Init()
+	volume.Init(&state)
+	zones.Init(&state)
+	router = aetherhttp.Init(&state)
+
+	os.Exit(m.Run())
+}
+
+/*
+Start a fake librespot with a small playlist and follow its events until the test ends.
+*/
+func fake(t *testing.T) *spotifytest.Server {
+	s := spotifytest.Start(t)
+
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Artist: []spotify.Artist{{Name: "AC/DC"}}})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Artist: []spotify.Artist{{Name: "AC/DC"}}})
+	s.AddContext("spotify:playlist:test", "spotify:track:one", "spotify:track:two")
+
+	s.Listen(t, &state)
+
+	return s
+}
+
+/*
+Make a request to the router, form values are sent as the body.
+*/
+func do(t *testing.T, method string, path string, form url.Values, header ...string) (int, map[string]any) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var body map[string]any
+	json.Unmarshal(w.Body.Bytes(), &body)
+
+	return w.Code, body
+}
+
+func TestPlayer(t *testing.T) {
+	s := fake(t)
+
+	if code, body := do(t, "POST", "/player/play", url.Values{"uri": {"spotify:playlist:test"}}); code != 200 {
+		t.Fatalf("play returned %d %v", code, body)
+	}
+
+	do(t, "POST", "/player/next", nil)
+	do(t, "POST", "/player/pause", nil)
+
+	if got := s.State(); got.Track != "spotify:track:two" || !got.Paused {
+		t.Fatalf("librespot state is %+v", got)
+	}
+
+	for deadline := time.Now().Add(time.Second); !state.Paused(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the events did not reach the player state")
+		}
+	}
+
+	code, body := do(t, "GET", "/player/state", nil)
+	snapshot, _ := body["state"].(map[string]any)
+
+	if code != 200 || snapshot["uri"] != "spotify:track:two" {
+		t.Fatalf("/player/state returned %d %v", code, body)
+	}
+
+	if code, _ := do(t, "POST", "/player/play", nil); code != 400 {
+		t.Errorf("play without an uri returned %d", code)
+	}
+}
+
+func TestZones(t *testing.T) {
+	s := fake(t)
+
+	if code, body := do(t, "POST", "/zones/main/player/play", url.Values{"uri": {"spotify:track:one"}}); code != 200 {
+		t.Fatalf("play in main returned %d %v", code, body)
+	}
+
+	if code, body := do(t, "POST", "/zones/main/player/seek", url.Values{"pos": {"30000"}}); code != 200 || s.State().Position < 30000 {
+		t.Fatalf("seek in main returned %d %v, librespot is at %d", code, body, s.State().Position)
+	}
+
+	code, body := do(t, "GET", "/zones", nil)
+
+	if list, _ := body["zones"].([]any); code != 200 || len(list) != 1 {
+		t.Errorf("/zones returned %d %v", code, body)
+	}
+
+	if code, _ := do(t, "POST", "/zones/attic/player/pause", nil); code != 404 {
+		t.Errorf("pause in an unknown zone returned %d", code)
+	}
+
+	if code, _ := do(t, "PUT", "/zones/main/follow", url.Values{"leader": {"main"}}); code != 400 {
+		t.Errorf("main following itself returned %d", code)
+	}
+}
+
+func TestInstance(t *testing.T) {
+	s := fake(t)
+
+	code, body := do(t, "GET", "/instance", nil)
+	info, _ := body["instance"].(map[string]any)
+
+	if code != 200 || info["device_name"] != "aether-test" {
+		t.Errorf("/instance returned %d %v", code, body)
+	}
+
+	s.Close()
+
+	if code, _ := do(t, "GET", "/instance", nil); code != 503 {
+		t.Errorf("/instance without librespot returned %d", code)
+	}
+}
+
+func TestAnnounceWithoutAudio(t *testing.T) {
+	// audio.Init is not called, there is no output to play on
+	if code, body := do(t, "POST", "/announce", url.Values{"clip": {"doorbell"}}); code != 503 {
+		t.Errorf("announcing without an output returned %d %v", code, body)
+	}
+}
+
+func TestAnnouncementAudio(t *testing.T) {
+	if code, _ := do(t, "GET", "/announce/42/audio", nil); code != 404 {
+		t.Errorf("the audio of an unknown announcement returned %d", code)
+	}
+}
+
+func TestSpotifyFailure(t *testing.T) {
+	s := fake(t)
+	s.Fail("/player/resume", spotifytest.Fault{Status: http.StatusInternalServerError})
+
+	code, body := do(t, "POST", "/player/resume", nil)
+
+	if code != 500 || !strings.Contains(body["message"].(string), "500") {
+		t.Fatalf("resume during a librespot failure returned %d %v", code, body)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	s := fake(t)
+
+	code, body := do(t, "POST", "/queue", url.Values{"uri": {"spotify:track:two"}}, "X-Aether-User", "dj")
+
+	if code != 200 {
+		t.Fatalf("adding a request returned %d %v", code, body)
+	}
+
+	request := body["request"].(map[string]any)
+
+	if request["requester"] != "dj" || len(s.State().Queue) != 1 {
+		t.Fatalf("request is %v, librespot queue %v", request, s.State().Queue)
+	}
+
+	if code, _ := do(t, "POST", "/queue", url.Values{"uri": {"spotify:playlist:test"}}); code != 400 {
+		t.Errorf("requesting a playlist returned %d", code)
+	}
+
+	_, body = do(t, "GET", "/queue", nil)
+
+	if queued := body["queue"].([]any); len(queued) != 1 {
+		t.Fatalf("/queue lists %v", queued)
+	}
+
+	path := "/queue/" + strconv.Itoa(int(request["id"].(float64)))
+
+	if code, _ := do(t, "DELETE", path, nil); code != 200 {
+		t.Fatalf("removing the request returned %d", code)
+	}
+
+	if code, _ := do(t, "DELETE", path, nil); code != 404 {
+		t.Errorf("removing the request twice returned %d", code)
+	}
+
+	if queue := s.State().Queue; len(queue) != 0 {
+		t.Errorf("librespot queue is %v after removing the request", queue)
+	}
+}
+
+func TestVolumeCap(t *testing.T) {
+	s := fake(t)
+
+	volume.SetLimit(20000)
+	t.Cleanup(func() { volume.SetLimit(0) })
+
+	do(t, "POST", "/player/volume", url.Values{"volume": {"60000"}})
+
+	if got := s.State().Volume; got != 20000 {
+		t.Fatalf("volume is %d, the cap is 20000", got)
+	}
+
+	if code, _ := do(t, "POST", "/player/volume", nil); code != 400 {
+		t.Errorf("setting the volume without a value returned %d", code)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	fake(t)
+
+	t.Setenv("AETHER_ADMIN_TOKEN", "")
+	utils.LoadConfig()
+
+	if code, _ := do(t, "GET", "/admin/volume", nil, "Authorization", "Bearer "); code != 403 {
+		t.Errorf("without a token in the config /admin/volume returned %d", code)
+	}
+
+	t.Setenv("AETHER_ADMIN_TOKEN", "hunter2")
+	utils.LoadConfig()
+
+	if code, _ := do(t, "GET", "/admin/volume", nil); code != 401 {
+		t.Errorf("without a token /admin/volume returned %d", code)
+	}
+
+	if code, _ := do(t, "GET", "/admin/volume", nil, "Authorization", "Bearer wrong"); code != 401 {
+		t.Errorf("with a wrong token /admin/volume returned %d", code)
+	}
+
+	if code, _ := do(t, "PUT", "/admin/policy/profile", url.Values{"profile": {""}}); code != 401 {
+		t.Errorf("without a token /admin/policy/profile returned %d", code)
+	}
+
+	if code, _ := do(t, "PUT", "/admin/policy/profile", url.Values{"profile": {""}}, "Authorization", "Bearer hunter2"); code != 200 {
+		t.Errorf("with the token /admin/policy/profile returned %d", code)
+	}
+
+	code, body := do(t, "GET", "/config", nil, "Authorization", "Bearer hunter2")
+
+	if code != 200 || strings.Contains(jsonString(body), "hunter2") {
+		t.Errorf("/config returned %d %v", code, body)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := fake(t)
+
+	if code, _ := do(t, "GET", "/health", nil); code != 200 {
+		t.Errorf("/health returned %d while librespot is up", code)
+	}
+
+	s.Close()
+
+	if code, _ := do(t, "GET", "/health", nil); code != 503 {
+		t.Errorf("/health returned %d while librespot is down", code)
+	}
+}
+
+func TestLive(t *testing.T) {
+	s := fake(t)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/live", nil)
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	events := make(chan string, 100)
+
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+
+		for scanner.Scan() {
+			if event, ok := strings.CutPrefix(scanner.Text(), "event:"); ok {
+				events <- event
+			}
+		}
+
+		close(events)
+	}()
+
+	expect := func(want string) {
+		t.Helper()
+
+		timeout := time.After(2 * time.Second)
+
+		for {
+			select {
+			case event := <-events:
+				if event == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no %s event on /live", want)
+			}
+		}
+	}
+
+	expect("state")
+
+	s.AddContext("spotify:playlist:live", "spotify:track:one")
+	do(t, "POST", "/player/play", url.Values{"uri": {"spotify:playlist:live"}})
+	do(t, "POST", "/player/pause", nil)
+
+	expect("trackChanged")
+	expect("playbackPaused")
+}
+
+func TestSearch(t *testing.T) {
+	fake(t)
+
+	code, body := do(t, "GET", "/search?q=thunderstruck&type=track", nil)
+
+	if code != 200 {
+		t.Fatalf("/search returned %d %v", code, body)
+	}
+
+	results := body["results"].([]any)
+
+	if len(results) == 0 || results[0].(map[string]any)["uri"] != "spotify:track:two" {
+		t.Fatalf("searching thunderstruck found %v", results)
+	}
+
+	if code, _ := do(t, "GET", "/search?q=x&type=podcast", nil); code != 400 {
+		t.Errorf("searching an unknown type returned %d", code)
+	}
+}
+
+func jsonString(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/ODDInvictus/aether/instance"
+	"github.com/gin-gonic/gin"
+)
+
+func instanceRoutes() {
+	r.GET("/instance", func (c *gin.Context) {
+		info, err := instance.Get()
+
+		if err != nil {
+			c.JSON(503, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"instance": info,
+		})
+	})
+
+	// Recovering takes a while, the result is published as sessionRecovered
+	r.POST("/instance/recover", adminOnly, func (c *gin.Context) {
+		if instance.Recovering() {
+			c.JSON(409, gin.H{
+				"message": "The session is already being recovered",
+			})
+			return
+		}
+
+		go instance.Recover("manual")
+
+		c.JSON(202, gin.H{
+			"message": "Recovering the session",
+		})
+	})
+}
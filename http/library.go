@@ -0,0 +1,54 @@
+package http
+
+import (
+	"strconv"
+
+	"github.com/ODDInvictus/aether/library"
+	"github.com/gin-gonic/gin"
+)
+
+func libraryRoutes() {
+	r.GET("/library/artists", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"artists": library.Artists(),
+		})
+	})
+
+	r.GET("/library/albums", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"albums": library.Albums(c.Query("artist")),
+		})
+	})
+
+	r.GET("/library/tracks", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"tracks": library.Tracks(c.Query("artist"), c.Query("album")),
+		})
+	})
+
+	r.GET("/library/search", func (c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+		if err != nil || limit < 1 {
+			c.JSON(400, gin.H{
+				"message": "Invalid limit",
+			})
+			return
+		}
+
+		c.JSON(200, library.Search(c.Query("q"), limit))
+	})
+
+	r.POST("/library/rescan", func (c *gin.Context) {
+		if !library.Rescan() {
+			c.JSON(409, gin.H{
+				"message": "The library is already being scanned",
+			})
+			return
+		}
+
+		c.JSON(202, gin.H{
+			"message": "Rescanning library",
+		})
+	})
+}
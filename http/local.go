@@ -0,0 +1,114 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/local"
+	"github.com/ODDInvictus/aether/policy"
+	"github.com/gin-gonic/gin"
+)
+
+func localRoutes() {
+	r.GET("/local/state", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"state": local.Current(),
+		})
+	})
+
+	r.POST("/local/play", func (c *gin.Context) {
+		tracks, ok := localTracks(c)
+
+		if !ok {
+			return
+		}
+
+		if err := local.Play(tracks); err != nil {
+			c.JSON(500, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+
+	r.POST("/local/queue", func (c *gin.Context) {
+		tracks, ok := localTracks(c)
+
+		if !ok {
+			return
+		}
+
+		local.Enqueue(tracks)
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+
+	r.POST("/local/next", func (c *gin.Context) {
+		localResult(c, local.Next())
+	})
+
+	r.POST("/local/prev", func (c *gin.Context) {
+		localResult(c, local.Prev())
+	})
+
+	r.POST("/local/pause", func (c *gin.Context) {
+		local.Pause()
+		localResult(c, nil)
+	})
+
+	r.POST("/local/resume", func (c *gin.Context) {
+		local.Resume()
+		localResult(c, nil)
+	})
+
+	r.POST("/local/stop", func (c *gin.Context) {
+		local.Stop()
+		localResult(c, nil)
+	})
+}
+
+func localTracks(c *gin.Context) ([]library.Track, bool) {
+	var params LocalPlay
+
+	if c.ShouldBind(&params) != nil || params.URI == "" {
+		c.JSON(400, gin.H{
+			"message": "Invalid uri",
+		})
+		return nil, false
+	}
+
+	tracks, err := library.Resolve(params.URI)
+
+	if err != nil {
+		c.JSON(404, gin.H{
+			"message": fmt.Sprint(err),
+		})
+		return nil, false
+	}
+
+	if err := policy.CheckLibrary(tracks); err != nil {
+		rejected(c, err)
+		return nil, false
+	}
+
+	return tracks, true
+}
+
+func localResult(c *gin.Context, err error) {
+	if err != nil {
+		c.JSON(500, gin.H{
+			"message": fmt.Sprint(err),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Success",
+	})
+}
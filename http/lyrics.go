@@ -0,0 +1,50 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/ODDInvictus/aether/lyrics"
+	"github.com/gin-gonic/gin"
+)
+
+func lyricsRoutes() {
+	// The lyrics of a track, or of what is playing along with the line that is sung right now
+	r.GET("/lyrics", func (c *gin.Context) {
+		if uri := c.Query("uri"); uri != "" {
+			found, err := lyrics.Get(uri)
+
+			if err != nil {
+				c.JSON(500, gin.H{
+					"message": fmt.Sprint(err),
+				})
+				return
+			}
+
+			if found == nil {
+				c.JSON(404, gin.H{
+					"message": "No lyrics for " + uri,
+				})
+				return
+			}
+
+			c.JSON(200, gin.H{
+				"lyrics": found,
+			})
+			return
+		}
+
+		current, line := lyrics.Current()
+
+		if current == nil {
+			c.JSON(404, gin.H{
+				"message": "No lyrics for what is playing",
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"lyrics": current,
+			"line":   line,
+		})
+	})
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/history"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/volume"
+	"github.com/gin-gonic/gin"
+)
+
+func playerRoutes() {
+	r.GET("/player/state", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"state": player.Snapshot(),
+		})
+	})
+
+	r.POST("/player/play", func (c *gin.Context) {
+		var params PlayerPlay
+
+		if c.ShouldBind(&params) != nil || params.URI == "" {
+			c.JSON(400, gin.H{
+				"message": "Invalid uri",
+			})
+			return
+		}
+
+		spotifyResult(c)(spotify.Load(params.URI, true, params.Shuffle))
+	})
+
+	r.POST("/player/pause", func (c *gin.Context) {
+		spotifyResult(c)(spotify.Pause())
+	})
+
+	r.POST("/player/resume", func (c *gin.Context) {
+		spotifyResult(c)(spotify.Resume())
+	})
+
+	r.POST("/player/next", func (c *gin.Context) {
+		spotifyResult(c)(spotify.Next())
+	})
+
+	r.POST("/player/prev", func (c *gin.Context) {
+		spotifyResult(c)(spotify.Prev())
+	})
+
+	r.GET("/player/volume", func (c *gin.Context) {
+		current, ok := player.Volume()
+
+		if !ok {
+			c.JSON(404, gin.H{
+				"message": "Volume is not known yet",
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"volume": current,
+		})
+	})
+
+	r.POST("/player/volume", func (c *gin.Context) {
+		var params PlayerVolume
+
+		if c.ShouldBind(&params) != nil || (params.Volume == nil && params.Step == 0) {
+			c.JSON(400, gin.H{
+				"message": "Specify either a volume or a step",
+			})
+			return
+		}
+
+		value := -1
+
+		if params.Volume != nil {
+			value = *params.Volume
+		}
+
+		// Steps past the cap are lowered again once librespot reports the new volume
+		spotifyResult(c)(spotify.SetVolume(volume.Clamp(value), params.Step))
+	})
+
+	r.GET("/history", func (c *gin.Context) {
+		var params HistoryQuery
+
+		if c.ShouldBindQuery(&params) != nil || params.Limit < 0 {
+			c.JSON(400, gin.H{
+				"message": "Invalid limit",
+			})
+			return
+		}
+
+		if params.Limit == 0 {
+			params.Limit = 50
+		}
+
+		c.JSON(200, gin.H{
+			"history": history.List(params.Limit),
+		})
+	})
+
+	r.GET("/health", func (c *gin.Context) {
+		health := utils.CheckHealth()
+		status := 200
+
+		if !health.Spotify {
+			status = 503
+		}
+
+		c.JSON(status, gin.H{
+			"spotify": health.Spotify,
+			"mp3":     health.MP3,
+			"audio":   audio.Ready(),
+		})
+	})
+
+	// Server sent events, starting with the current state so clients never have to poll /state
+	r.GET("/live", func (c *gin.Context) {
+		events, stop := live.Subscribe()
+		defer stop()
+
+		c.SSEvent("state", live.Event{Type: "state", Time: time.Now(), Data: player.Snapshot()})
+		c.Writer.Flush()
+
+		c.Stream(func (w io.Writer) bool {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return false
+				}
+
+				c.SSEvent(event.Type, event)
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			case <-closing:
+				return false
+			}
+		})
+	})
+}
+
+/*
+Respond to a call to one of the spotify wrappers.
+*/
+func spotifyResult(c *gin.Context) func (bool, error) {
+	return func (ok bool, err error) {
+		if !ok {
+			c.JSON(500, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ODDInvictus/aether/policy"
+	"github.com/gin-gonic/gin"
+)
+
+func policyRoutes() {
+	// What may be played right now, so clients can explain it before anyone tries
+	r.GET("/policy", func (c *gin.Context) {
+		name, profile, ok := policy.Active()
+
+		if !ok {
+			c.JSON(200, gin.H{
+				"profile":  nil,
+				"override": policy.Override(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"profile":  name,
+			"rules":    profile,
+			"override": policy.Override(),
+		})
+	})
+
+	r.GET("/policy/check", func (c *gin.Context) {
+		uri := c.Query("uri")
+
+		if uri == "" {
+			c.JSON(400, gin.H{
+				"message": "Invalid uri",
+			})
+			return
+		}
+
+		err := policy.CheckURI(uri)
+		var rejection *policy.Rejection
+
+		switch {
+		case errors.As(err, &rejection):
+			c.JSON(200, gin.H{
+				"allowed":   false,
+				"rejection": rejection,
+			})
+		case err != nil:
+			c.JSON(500, gin.H{
+				"message": fmt.Sprint(err),
+			})
+		default:
+			c.JSON(200, gin.H{
+				"allowed": true,
+			})
+		}
+	})
+}
+
+/*
+Respond with the reason a track was rejected, or a bad request for any other error.
+*/
+func rejected(c *gin.Context, err error) {
+	var rejection *policy.Rejection
+
+	if errors.As(err, &rejection) {
+		c.JSON(403, gin.H{
+			"message":   rejection.Reason,
+			"rejection": rejection,
+		})
+		return
+	}
+
+	c.JSON(400, gin.H{
+		"message": fmt.Sprint(err),
+	})
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/gin-gonic/gin"
+)
+
+func queueRoutes() {
+	r.GET("/queue", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"playing": queue.Playing(),
+			"queue":   queue.List(),
+		})
+	})
+
+	r.POST("/queue", func (c *gin.Context) {
+		var params QueueAdd
+
+		if c.ShouldBind(&params) != nil || params.URI == "" {
+			c.JSON(400, gin.H{
+				"message": "Invalid uri",
+			})
+			return
+		}
+
+		request, err := queue.Add(params.URI, requester(c))
+
+		if err != nil {
+			rejected(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"request": request,
+		})
+	})
+
+	r.DELETE("/queue/:id", func (c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": "Invalid request id",
+			})
+			return
+		}
+
+		if err := queue.Remove(id); err != nil {
+			c.JSON(404, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ODDInvictus/aether/search"
+	"github.com/gin-gonic/gin"
+)
+
+func searchRoutes() {
+	r.GET("/search", func (c *gin.Context) {
+		var params SearchQuery
+
+		if c.ShouldBindQuery(&params) != nil || strings.TrimSpace(params.Query) == "" {
+			c.JSON(400, gin.H{
+				"message": "Missing search query",
+			})
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+		if err != nil || limit < 1 || limit > 100 {
+			c.JSON(400, gin.H{
+				"message": "Limit should be between 1 and 100",
+			})
+			return
+		}
+
+		if params.Offset < 0 {
+			c.JSON(400, gin.H{
+				"message": "Invalid offset",
+			})
+			return
+		}
+
+		var types []string
+
+		for _, t := range strings.Split(params.Type, ",") {
+			switch t = strings.TrimSpace(t); t {
+			case "":
+			case search.TypeTrack, search.TypeAlbum, search.TypeArtist, search.TypePlaylist:
+				types = append(types, t)
+			default:
+				c.JSON(400, gin.H{
+					"message": "Invalid type " + t + ", possible options: track, album, artist, playlist",
+				})
+				return
+			}
+		}
+
+		c.JSON(200, search.Search(params.Query, types, params.Offset, limit))
+	})
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"context"
+	nethttp "net/http"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+// Closed when the server shuts down, so streams like /live end instead of keeping the shutdown waiting.
+var closing = make(chan struct{})
+
+/*
+Serve the router on addr until ctx is cancelled, then let open requests finish within shutdown.timeout.
+Returns an error when the server could not start or did not shut down in time.
+*/
+func Serve(ctx context.Context, addr string) error {
+	server := &nethttp.Server{Addr: addr, Handler: r}
+	server.RegisterOnShutdown(func () {
+		close(closing)
+	})
+
+	failed := make(chan error, 1)
+
+	go func () {
+		logger.Log("Listening on " + addr)
+
+		if err := server.ListenAndServe(); err != nethttp.ErrServerClosed {
+			failed <- err
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Log("Shutting down the HTTP server")
+
+	timeout, cancel := context.WithTimeout(context.Background(), utils.Cfg().Shutdown.Timeout.Duration)
+	defer cancel()
+
+	return server.Shutdown(timeout)
+}
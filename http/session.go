@@ -0,0 +1,46 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/ODDInvictus/aether/session"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func sessionRoutes() {
+	r.GET("/session", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"resume":  utils.Cfg().Session.Resume,
+			"pending": session.Pending(),
+		})
+	})
+
+	r.POST("/session/resume", adminOnly, func (c *gin.Context) {
+		if session.Pending() == nil {
+			c.JSON(404, gin.H{
+				"message": "There is no session to resume",
+			})
+			return
+		}
+
+		if err := session.Resume(); err != nil {
+			c.JSON(500, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+
+	r.DELETE("/session", adminOnly, func (c *gin.Context) {
+		session.Discard()
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+}
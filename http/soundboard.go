@@ -0,0 +1,53 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/soundboard"
+	"github.com/gin-gonic/gin"
+)
+
+func soundboardRoutes() {
+	r.GET("/soundboard", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"clips": soundboard.Clips(),
+		})
+	})
+
+	r.POST("/soundboard/:name", func (c *gin.Context) {
+		err := soundboard.Trigger(c.Param("name"), requester(c))
+
+		var cooldown *soundboard.CooldownError
+
+		if errors.As(err, &cooldown) {
+			c.Header("Retry-After", fmt.Sprint(math.Ceil(cooldown.Remaining.Seconds())))
+			c.JSON(429, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		if err != nil {
+			status := 500
+
+			switch {
+			case errors.Is(err, soundboard.ErrNotFound):
+				status = 404
+			case errors.Is(err, audio.ErrUnavailable):
+				status = 503
+			}
+
+			c.JSON(status, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+}
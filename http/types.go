@@ -1,5 +1,67 @@
 package http
 
+import "github.com/ODDInvictus/aether/volume"
+
 type PlaylistPlay struct {
 	SpotifyID string `form:"spotify_id"`
-}
\ No newline at end of file
+}
+
+type AnnouncePlay struct {
+	Clip     string `form:"clip"`
+	Priority int    `form:"priority"`
+	// Play it in every zone, not just on the audio output of aether
+	Everywhere bool `form:"everywhere"`
+}
+
+type SearchQuery struct {
+	Query  string `form:"q"`
+	Type   string `form:"type"`
+	Offset int    `form:"offset"`
+}
+
+type LocalPlay struct {
+	URI string `form:"uri"`
+}
+
+type PlayerPlay struct {
+	URI     string `form:"uri"`
+	Shuffle bool   `form:"shuffle"`
+}
+
+type PlayerVolume struct {
+	Volume *int `form:"volume"`
+	Step   int  `form:"step"`
+}
+
+type QueueAdd struct {
+	URI string `form:"uri"`
+}
+
+type HistoryQuery struct {
+	Limit int `form:"limit"`
+}
+
+type VolumeMax struct {
+	Max *int `form:"max"`
+}
+
+type VolumeSchedule struct {
+	Schedule []volume.Window `json:"schedule"`
+}
+
+type ChatSay struct {
+	User string `form:"user"`
+	Text string `form:"text"`
+}
+
+type PolicyOverride struct {
+	Profile string `form:"profile"`
+}
+
+type ZoneSeek struct {
+	Pos *int `form:"pos"`
+}
+
+type ZoneFollow struct {
+	Leader string `form:"leader"`
+}
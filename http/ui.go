@@ -0,0 +1,16 @@
+package http
+
+import (
+	nethttp "net/http"
+
+	"github.com/ODDInvictus/aether/web"
+	"github.com/gin-gonic/gin"
+)
+
+func uiRoutes() {
+	r.StaticFS("/ui", nethttp.FS(web.Static()))
+
+	r.GET("/", func (c *gin.Context) {
+		c.Redirect(302, "/ui/")
+	})
+}
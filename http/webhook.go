@@ -0,0 +1,27 @@
+package http
+
+import (
+	"strconv"
+
+	"github.com/ODDInvictus/aether/webhook"
+	"github.com/gin-gonic/gin"
+)
+
+func webhookRoutes() {
+	// The URLs of the hooks can hold credentials, so only admins get to see them
+	r.GET("/webhooks/deliveries", adminOnly, func (c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+		if err != nil || limit < 1 {
+			c.JSON(400, gin.H{
+				"message": "Invalid limit",
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"pending":  webhook.Pending(),
+			"attempts": webhook.Attempts(limit),
+		})
+	})
+}
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/ODDInvictus/aether/volume"
+	"github.com/ODDInvictus/aether/zones"
+	"github.com/gin-gonic/gin"
+)
+
+func zoneRoutes() {
+	r.GET("/zones", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"zones": zones.List(),
+		})
+	})
+
+	// The same as /player, but for a single zone. Loading and seeking in a zone with followers moves them along.
+	z := r.Group("/zones/:zone", findZone)
+
+	z.GET("/player/state", func (c *gin.Context) {
+		c.JSON(200, gin.H{
+			"state": zoneOf(c).State.Snapshot(),
+		})
+	})
+
+	z.POST("/player/play", func (c *gin.Context) {
+		var params PlayerPlay
+
+		if c.ShouldBind(&params) != nil || params.URI == "" {
+			c.JSON(400, gin.H{
+				"message": "Invalid uri",
+			})
+			return
+		}
+
+		spotifyResult(c)(zones.Load(zoneOf(c).Name, params.URI, true, params.Shuffle))
+	})
+
+	z.POST("/player/pause", func (c *gin.Context) {
+		spotifyResult(c)(zoneOf(c).Client.Pause())
+	})
+
+	z.POST("/player/resume", func (c *gin.Context) {
+		spotifyResult(c)(zoneOf(c).Client.Resume())
+	})
+
+	z.POST("/player/next", func (c *gin.Context) {
+		spotifyResult(c)(zoneOf(c).Client.Next())
+	})
+
+	z.POST("/player/prev", func (c *gin.Context) {
+		spotifyResult(c)(zoneOf(c).Client.Prev())
+	})
+
+	z.POST("/player/seek", func (c *gin.Context) {
+		var params ZoneSeek
+
+		if c.ShouldBind(&params) != nil || params.Pos == nil || *params.Pos < 0 {
+			c.JSON(400, gin.H{
+				"message": "Invalid position",
+			})
+			return
+		}
+
+		spotifyResult(c)(zones.Seek(zoneOf(c).Name, *params.Pos))
+	})
+
+	z.POST("/player/volume", func (c *gin.Context) {
+		var params PlayerVolume
+
+		if c.ShouldBind(&params) != nil || (params.Volume == nil && params.Step == 0) {
+			c.JSON(400, gin.H{
+				"message": "Specify either a volume or a step",
+			})
+			return
+		}
+
+		value := -1
+
+		if params.Volume != nil {
+			value = *params.Volume
+		}
+
+		spotifyResult(c)(zoneOf(c).Client.SetVolume(volume.Clamp(value), params.Step))
+	})
+
+	// Play along with another zone, or on its own again
+	z.PUT("/follow", func (c *gin.Context) {
+		var params ZoneFollow
+
+		if c.ShouldBind(&params) != nil || params.Leader == "" {
+			c.JSON(400, gin.H{
+				"message": "Specify the zone to follow",
+			})
+			return
+		}
+
+		if err := zones.Join(zoneOf(c).Name, params.Leader); err != nil {
+			c.JSON(400, gin.H{
+				"message": fmt.Sprint(err),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+
+	z.DELETE("/follow", func (c *gin.Context) {
+		zones.Leave(zoneOf(c).Name)
+
+		c.JSON(200, gin.H{
+			"message": "Success",
+		})
+	})
+}
+
+func findZone(c *gin.Context) {
+	zone, ok := zones.Get(c.Param("zone"))
+
+	if !ok {
+		c.AbortWithStatusJSON(404, gin.H{
+			"message": "There is no zone " + c.Param("zone"),
+		})
+		return
+	}
+
+	c.Set("zone", zone)
+}
+
+func zoneOf(c *gin.Context) *zones.Zone {
+	return c.MustGet("zone").(*zones.Zone)
+}
@@ -0,0 +1,262 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/session"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/supervisor"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+The librespot instance and the Spotify session it has.
+*/
+type Info struct {
+	spotify.InstanceData
+	// The account from the Web API, empty when it could not be asked
+	Account string `json:"account,omitempty"`
+	// premium, free or open
+	Product   string `json:"product,omitempty"`
+	Connected bool   `json:"connected"`
+	// When the session started, as far as aether knows
+	Since      time.Time `json:"since"`
+	Age        string    `json:"age"`
+	Recovering bool      `json:"recovering"`
+	// The last time the session was recovered, nil when it never was
+	LastRecovery *Recovery `json:"lastRecovery,omitempty"`
+}
+
+/*
+How getting the session back went.
+*/
+type Recovery struct {
+	// sessionCleared, connectionDropped or manual
+	Reason   string    `json:"reason"`
+	Started  time.Time `json:"started"`
+	Duration int64     `json:"duration"` // ms
+	Error    string    `json:"error,omitempty"`
+}
+
+var mu sync.Mutex
+var since time.Time
+var account spotify.WebUser
+var connected = true
+var recovering bool
+var last *Recovery
+
+// Recovers the connection when it does not come back by itself
+var dropped *time.Timer
+
+/*
+Keep track of the Spotify session and recover it when librespot loses it.
+*/
+func Init() {
+	mu.Lock()
+	since = time.Now()
+	mu.Unlock()
+
+	go refreshAccount()
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		for event := range events {
+			cfg := utils.Cfg().Recovery
+
+			switch event.Type {
+			case "sessionChanged":
+				mu.Lock()
+				since = time.Now()
+				connected = true
+				mu.Unlock()
+
+				go refreshAccount()
+			case "sessionCleared":
+				// Closing the session clears it too, librespot reports that while recovering
+				if cfg.Enabled && !Recovering() {
+					go recoverLogged("sessionCleared")
+				}
+			case "connectionDropped":
+				mu.Lock()
+				connected = false
+
+				if cfg.Enabled && dropped == nil {
+					var timer *time.Timer
+
+					timer = time.AfterFunc(cfg.Grace.Duration, func() {
+						// Fired, so the next drop starts a timer of its own even when this recovery is refused
+						mu.Lock()
+						if dropped == timer {
+							dropped = nil
+						}
+						mu.Unlock()
+
+						recoverLogged("connectionDropped")
+					})
+
+					dropped = timer
+				}
+				mu.Unlock()
+			case "connectionEstablished":
+				mu.Lock()
+				connected = true
+				stopDropped()
+				mu.Unlock()
+			}
+		}
+	}()
+}
+
+/*
+Returns the librespot instance and its session.
+*/
+func Get() (*Info, error) {
+	data, err := spotify.Instance()
+
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	info := Info{
+		InstanceData: *data,
+		Account:      account.DisplayName,
+		Product:      account.Product,
+		Connected:    connected,
+		Since:        since,
+		Age:          time.Since(since).Round(time.Second).String(),
+		Recovering:   recovering,
+	}
+
+	if info.Account == "" {
+		info.Account = account.ID
+	}
+
+	if last != nil {
+		r := *last
+		info.LastRecovery = &r
+	}
+
+	return &info, nil
+}
+
+func Recovering() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return recovering
+}
+
+/*
+Close the Spotify session, wait for librespot to have a new one and load what was playing again, at the same
+position and with the requests queued.
+*/
+func Recover(reason string) error {
+	mu.Lock()
+
+	if recovering {
+		mu.Unlock()
+		return errors.New("the session is already being recovered")
+	}
+
+	recovering = true
+	stopDropped()
+	mu.Unlock()
+
+	r := Recovery{Reason: reason, Started: time.Now()}
+
+	Log("Recovering the session after " + reason)
+	live.Publish("failover", map[string]string{"reason": reason, "action": "reloadSession"})
+
+	err := reload()
+
+	r.Duration = time.Since(r.Started).Milliseconds()
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	mu.Lock()
+	recovering = false
+	last = &r
+	mu.Unlock()
+
+	live.Publish("sessionRecovered", r)
+
+	return err
+}
+
+func reload() error {
+	cfg := utils.Cfg().Recovery
+	c, playing := session.Current()
+
+	if ok, err := spotify.CloseSession(); !ok {
+		// It may be gone already, a new one is made either way
+		logger.Err("[Instance] Could not close the session", err)
+	}
+
+	time.Sleep(cfg.Wait.Duration)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout.Duration)
+	defer cancel()
+
+	if !supervisor.WaitReady(ctx) {
+		return fmt.Errorf("librespot did not answer within %s", cfg.Timeout.Duration)
+	}
+
+	mu.Lock()
+	since = time.Now()
+	connected = true
+	mu.Unlock()
+
+	go refreshAccount()
+
+	if !playing {
+		Log("Nothing was playing, there is nothing to reload")
+		return nil
+	}
+
+	return session.Reload(c)
+}
+
+func recoverLogged(reason string) {
+	if err := Recover(reason); err != nil {
+		logger.Err("[Instance] Could not recover the session", err)
+	}
+}
+
+func refreshAccount() {
+	user, err := spotify.Me()
+
+	if err != nil {
+		logger.Err("[Instance] Could not look up the account", err)
+		return
+	}
+
+	mu.Lock()
+	account = *user
+	mu.Unlock()
+}
+
+/*
+Called with mu held.
+*/
+func stopDropped() {
+	if dropped != nil {
+		dropped.Stop()
+		dropped = nil
+	}
+}
+
+func Log(str string) {
+	logger.Verbose("[Instance] " + str)
+}
@@ -0,0 +1,212 @@
+package instance_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/instance"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/session"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+var state spotify.SpotifyPlayer
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "aether-instance")
+
+	os.Setenv("AETHER_SESSION_FILE", filepath.Join(dir, "session.json"))
+	os.Setenv("AETHER_SESSION_RESUME", "never")
+	os.Setenv("AETHER_QUEUE_RECENT", "0")
+	os.Setenv("AETHER_RECOVERY_GRACE", "100ms")
+	os.Setenv("AETHER_RECOVERY_WAIT", "100ms")
+	utils.LoadConfig()
+
+	queue.Init()
+	session.Init(&state)
+	instance.Init()
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func fake(t *testing.T) *spotifytest.Server {
+	s := spotifytest.Start(t)
+
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Duration: 208000})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Duration: 292000})
+	s.AddTrack("spotify:track:three", spotify.Track{Name: "T.N.T.", Duration: 214000})
+	s.AddContext("spotify:playlist:test", "spotify:track:one", "spotify:track:two")
+
+	s.Listen(t, &state)
+
+	t.Cleanup(func() {
+		for _, request := range queue.List() {
+			queue.Remove(request.ID)
+		}
+	})
+
+	return s
+}
+
+/*
+Wait for the next sessionRecovered on events.
+*/
+func recovered(t *testing.T, events <-chan live.Event) instance.Recovery {
+	t.Helper()
+
+	timeout := time.After(3 * time.Second)
+
+	for {
+		select {
+		case e := <-events:
+			if e.Type == "sessionRecovered" {
+				return e.Data.(instance.Recovery)
+			}
+		case <-timeout:
+			t.Fatal("the session was not recovered")
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	s := fake(t)
+	s.SetInstance(spotify.InstanceData{DeviceID: "0123456789abcdef", DeviceName: "Bar", CountryCode: "NL"})
+	s.Emit("sessionChanged", map[string]any{"username": "aether"})
+
+	var info *instance.Info
+
+	for deadline := time.Now().Add(2 * time.Second); info == nil || info.Product != "premium"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("instance %+v", info)
+		}
+
+		info, _ = instance.Get()
+	}
+
+	if info.DeviceName != "Bar" || info.CountryCode != "NL" || info.Account != "Aether" || !info.Connected || time.Since(info.Since) > time.Second {
+		t.Errorf("instance %+v", info)
+	}
+}
+
+func TestSessionCleared(t *testing.T) {
+	s := fake(t)
+
+	spotify.Load("spotify:playlist:test", true, false)
+	spotify.Next()
+	spotify.Seek(60000)
+
+	if _, err := queue.Add("spotify:track:three", "Jan"); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); state.Snapshot().URI != "spotify:track:two" || state.Snapshot().TrackTime < 60000; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the player state is %+v", state.Snapshot())
+		}
+	}
+
+	events, unsubscribe := live.Subscribe()
+	defer unsubscribe()
+
+	// librespot lost its session by itself, everything is gone
+	s.Emit("sessionCleared", nil)
+
+	if r := recovered(t, events); r.Reason != "sessionCleared" || r.Error != "" {
+		t.Errorf("recovered %+v", r)
+	}
+
+	got := s.State()
+
+	if got.Context != "spotify:playlist:test" || got.Track != "spotify:track:two" || got.Position < 60000 || got.Paused {
+		t.Errorf("librespot is at %+v after recovering", got)
+	}
+
+	if !slices.Contains(got.Queue, "spotify:track:three") || len(queue.List()) != 1 {
+		t.Errorf("librespot queued %v, aether %+v", got.Queue, queue.List())
+	}
+
+	// Closing the session clears it too, that is not recovered again
+	timeout := time.After(300 * time.Millisecond)
+
+	for waiting := true; waiting; {
+		select {
+		case e := <-events:
+			if e.Type == "sessionRecovered" {
+				t.Fatal("recovered the session twice")
+			}
+		case <-timeout:
+			waiting = false
+		}
+	}
+}
+
+func TestConnectionDropped(t *testing.T) {
+	s := fake(t)
+
+	events, unsubscribe := live.Subscribe()
+	defer unsubscribe()
+
+	// Back within the grace period
+	s.Emit("connectionDropped", nil)
+	s.Emit("connectionEstablished", nil)
+
+	timeout := time.After(300 * time.Millisecond)
+
+	for waiting := true; waiting; {
+		select {
+		case e := <-events:
+			if e.Type == "sessionRecovered" {
+				t.Fatal("recovered a connection that came back")
+			}
+		case <-timeout:
+			waiting = false
+		}
+	}
+
+	s.Emit("connectionDropped", nil)
+
+	if r := recovered(t, events); r.Reason != "connectionDropped" {
+		t.Errorf("recovered %+v", r)
+	}
+}
+
+func TestDroppedWhileRecovering(t *testing.T) {
+	s := fake(t)
+
+	viper.Set("recovery.wait", "400ms")
+	utils.LoadConfig()
+
+	t.Cleanup(func() {
+		viper.Set("recovery.wait", "100ms")
+		utils.LoadConfig()
+	})
+
+	events, unsubscribe := live.Subscribe()
+	defer unsubscribe()
+
+	s.Emit("sessionCleared", nil)
+	spotifytest.Eventually(t, "the recovery to start", instance.Recovering)
+
+	// The grace period runs out halfway the recovery, that drop is not recovered again
+	s.Emit("connectionDropped", nil)
+
+	if r := recovered(t, events); r.Reason != "sessionCleared" {
+		t.Fatalf("recovered %+v", r)
+	}
+
+	s.Emit("connectionDropped", nil)
+
+	if r := recovered(t, events); r.Reason != "connectionDropped" {
+		t.Errorf("recovered %+v", r)
+	}
+}
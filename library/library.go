@@ -0,0 +1,307 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+// Bump when Track gets new fields, so older indexes are rebuilt instead of missing them.
+const indexVersion = 2
+
+type index struct {
+	Version int      `json:"version"`
+	Tracks  []*Track `json:"tracks"`
+}
+
+var mu sync.RWMutex
+var tracks = map[string]*Track{}
+
+// Held while scanning, so two scans never walk the directories at the same time
+var scanning sync.Mutex
+
+// Held while writing the index, the watcher saves too
+var saveMu sync.Mutex
+
+/*
+Load the stored index, bring it up to date with the configured directories and keep watching them for changes.
+*/
+func Init() {
+	Log("Initializing local music library")
+
+	if err := load(); err != nil {
+		logger.Err("Could not load the library index, rebuilding it", err)
+	}
+
+	go func() {
+		Scan()
+		watch()
+	}()
+
+	utils.OnConfigChange(func(old utils.Config, new utils.Config) {
+		if slices.Equal(old.Library.Dirs, new.Library.Dirs) {
+			return
+		}
+
+		Log("Library directories changed, rescanning")
+		watchDirs(new.Library.Dirs)
+		go Scan()
+	})
+}
+
+/*
+Walk all library directories, (re)reading files that are new or changed since the last scan and dropping files that are gone.
+Waits for a scan that is already running to finish first.
+*/
+func Scan() {
+	scanning.Lock()
+	defer scanning.Unlock()
+
+	scan()
+}
+
+/*
+Scan in the background, unless a scan is running already. Returns whether a scan was started.
+*/
+func Rescan() bool {
+	if !scanning.TryLock() {
+		return false
+	}
+
+	go func() {
+		defer scanning.Unlock()
+		scan()
+	}()
+
+	return true
+}
+
+func scan() {
+	seen := map[string]bool{}
+
+	for _, dir := range utils.Cfg().Library.Dirs {
+		Log("Scanning " + dir)
+
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				logger.Err("Could not read "+path, err)
+				return nil
+			}
+
+			if d.IsDir() || !audio.Supported(path) {
+				return nil
+			}
+
+			seen[path] = true
+			update(path)
+
+			return nil
+		})
+
+		if err != nil {
+			logger.Err("Could not scan "+dir, err)
+		}
+	}
+
+	mu.Lock()
+	for path := range tracks {
+		if !seen[path] {
+			delete(tracks, path)
+		}
+	}
+	count := len(tracks)
+	mu.Unlock()
+
+	Log(fmt.Sprintf("Library contains %d tracks", count))
+
+	save()
+}
+
+/*
+Returns the track with the given local URI or path.
+*/
+func Lookup(uri string) (*Track, bool) {
+	path := uri
+
+	if strings.HasPrefix(uri, "local:track:") {
+		path, _ = url.QueryUnescape(strings.TrimPrefix(uri, "local:track:"))
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	t, ok := tracks[path]
+
+	if !ok {
+		return nil, false
+	}
+
+	track := *t
+
+	return &track, true
+}
+
+/*
+Returns the tracks behind a local track, album or artist URI, in album order.
+*/
+func Resolve(uri string) ([]Track, error) {
+	parts := strings.Split(uri, ":")
+
+	if len(parts) < 3 || parts[0] != "local" {
+		return nil, fmt.Errorf("%s is not a local uri", uri)
+	}
+
+	for i := range parts[2:] {
+		parts[i+2], _ = url.QueryUnescape(parts[i+2])
+	}
+
+	var list []Track
+
+	switch {
+	case parts[1] == "track":
+		if t, ok := Lookup(uri); ok {
+			list = []Track{*t}
+		}
+	case parts[1] == "album" && len(parts) == 4:
+		list = Tracks(parts[2], parts[3])
+	case parts[1] == "artist":
+		list = Tracks(parts[2], "")
+	default:
+		return nil, fmt.Errorf("%s is not a local uri", uri)
+	}
+
+	if len(list) == 0 {
+		return nil, fmt.Errorf("nothing found for %s", uri)
+	}
+
+	return list, nil
+}
+
+func TrackURI(path string) string {
+	return "local:track:" + url.QueryEscape(path)
+}
+
+func AlbumURI(artist string, album string) string {
+	return "local:album:" + url.QueryEscape(artist) + ":" + url.QueryEscape(album)
+}
+
+func ArtistURI(artist string) string {
+	return "local:artist:" + url.QueryEscape(artist)
+}
+
+/*
+Index a single file if it is new or changed, returns whether the index changed.
+*/
+func update(path string) bool {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return remove(path)
+	}
+
+	mu.RLock()
+	known, ok := tracks[path]
+	mu.RUnlock()
+
+	if ok && known.Size == info.Size() && known.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+
+	t, err := readTrack(path, info)
+
+	if err != nil {
+		logger.Err("Could not index "+path, err)
+		return remove(path)
+	}
+
+	mu.Lock()
+	tracks[path] = t
+	mu.Unlock()
+
+	return true
+}
+
+func remove(path string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := tracks[path]; !ok {
+		return false
+	}
+
+	delete(tracks, path)
+
+	return true
+}
+
+func load() error {
+	data, err := os.ReadFile(utils.Cfg().Library.Index)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var stored index
+
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != indexVersion {
+		Log("Library index is outdated, rebuilding it")
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, t := range stored.Tracks {
+		tracks[t.Path] = t
+	}
+
+	return nil
+}
+
+func save() {
+	mu.RLock()
+	list := make([]*Track, 0, len(tracks))
+	for _, t := range tracks {
+		list = append(list, t)
+	}
+	data, err := json.Marshal(index{Version: indexVersion, Tracks: list})
+	mu.RUnlock()
+
+	if err != nil {
+		logger.Err("Could not encode the library index", err)
+		return
+	}
+
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	path := utils.Cfg().Library.Index
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		logger.Err("Could not write the library index", err)
+		return
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		logger.Err("Could not write the library index", err)
+	}
+}
+
+func Log(str string) {
+	logger.Verbose("[Library] " + str)
+}
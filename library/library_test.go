@@ -0,0 +1,185 @@
+package library_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+var dir string
+
+func TestMain(m *testing.M) {
+	dir, _ = os.MkdirTemp("", "aether-library")
+	music := filepath.Join(dir, "music")
+	os.Mkdir(music, 0o755)
+
+	os.WriteFile(filepath.Join(music, "highway.flac"), flacFile(3*44100, map[string]string{
+		"TITLE":                 "Highway to Hell",
+		"ARTIST":                "AC/DC",
+		"ALBUM":                 "Highway to Hell",
+		"DATE":                  "1979",
+		"TRACKNUMBER":           "1",
+		"ISRC":                  "AUAP07900030",
+		"BPM":                   "115.6",
+		"REPLAYGAIN_TRACK_GAIN": "-6.54 dB",
+		"REPLAYGAIN_TRACK_PEAK": "0.988312",
+		"REPLAYGAIN_ALBUM_GAIN": "-7.1 dB",
+	}), 0o644)
+	os.WriteFile(filepath.Join(music, "tnt.wav"), wavFile("T.N.T.", "AC/DC", "High Voltage"), 0o644)
+	os.WriteFile(filepath.Join(music, "Untitled.flac"), flacFile(44100, nil), 0o644)
+
+	viper.Set("library.dirs", []string{music})
+	viper.Set("library.index", filepath.Join(dir, "library.json"))
+	utils.LoadConfig()
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+/*
+A FLAC file with only its metadata, STREAMINFO says how long it is and the Vorbis comments hold the tags.
+*/
+func flacFile(samples int, comments map[string]string) []byte {
+	var b bytes.Buffer
+	b.WriteString("fLaC")
+
+	// STREAMINFO, 4096 samples per block, 44.1 kHz, 2 channels, 16 bits
+	b.Write([]byte{0x00, 0x00, 0x00, 34})
+	binary.Write(&b, binary.BigEndian, uint16(4096))
+	binary.Write(&b, binary.BigEndian, uint16(4096))
+	b.Write(make([]byte, 6))
+	binary.Write(&b, binary.BigEndian, uint64(44100)<<44|uint64(1)<<41|uint64(15)<<36|uint64(samples))
+	b.Write(make([]byte, 16))
+
+	var vorbis bytes.Buffer
+	binary.Write(&vorbis, binary.LittleEndian, uint32(4))
+	vorbis.WriteString("test")
+	binary.Write(&vorbis, binary.LittleEndian, uint32(len(comments)))
+
+	for key, value := range comments {
+		binary.Write(&vorbis, binary.LittleEndian, uint32(len(key)+1+len(value)))
+		vorbis.WriteString(key + "=" + value)
+	}
+
+	// VORBIS_COMMENT, the last block
+	b.Write([]byte{0x84, byte(vorbis.Len() >> 16), byte(vorbis.Len() >> 8), byte(vorbis.Len())})
+	b.Write(vorbis.Bytes())
+
+	return b.Bytes()
+}
+
+/*
+An empty WAV file with an ID3v1 tag at the end.
+*/
+func wavFile(title string, artist string, album string) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, struct {
+		Size             uint32
+		Format, Channels uint16
+		Rate, ByteRate   uint32
+		Align, Bits      uint16
+	}{16, 1, 2, 44100, 44100 * 4, 4, 16})
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+
+	field := func(s string, n int) []byte {
+		return append([]byte(s), make([]byte, n-len(s))...)
+	}
+
+	b.WriteString("TAG")
+	b.Write(field(title, 30))
+	b.Write(field(artist, 30))
+	b.Write(field(album, 30))
+	b.Write(field("1976", 4))
+	b.Write(make([]byte, 31))
+
+	return b.Bytes()
+}
+
+func lookup(t *testing.T, name string) *library.Track {
+	t.Helper()
+
+	track, ok := library.Lookup(filepath.Join(dir, "music", name))
+
+	if !ok {
+		t.Fatalf("%s was not indexed", name)
+	}
+
+	return track
+}
+
+func TestTags(t *testing.T) {
+	library.Scan()
+
+	got := lookup(t, "highway.flac")
+
+	if got.Title != "Highway to Hell" || got.Artist != "AC/DC" || got.AlbumArtist != "AC/DC" || got.Album != "Highway to Hell" {
+		t.Errorf("flac tags %+v", got)
+	}
+
+	if got.Year != 1979 || got.Number != 1 || got.ISRC != "AUAP07900030" || got.BPM != 116 || got.Duration != 3000 {
+		t.Errorf("flac fields %+v", got)
+	}
+
+	if got.TrackGain != -6.54 || got.TrackPeak != 0.988312 || got.AlbumGain != -7.1 || got.AlbumPeak != 0 {
+		t.Errorf("flac replaygain %+v", got)
+	}
+
+	if got := lookup(t, "tnt.wav"); got.Title != "T.N.T." || got.Artist != "AC/DC" || got.Album != "High Voltage" || got.Year != 1976 {
+		t.Errorf("id3v1 tags %+v", got)
+	}
+
+	if got := lookup(t, "Untitled.flac"); got.Title != "Untitled" || got.Artist != "" || got.Duration != 1000 {
+		t.Errorf("untagged %+v", got)
+	}
+}
+
+func TestConcurrentScans(t *testing.T) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			library.Scan()
+		}()
+
+		go func() {
+			defer wg.Done()
+			library.Rescan()
+		}()
+	}
+
+	wg.Wait()
+
+	// A rescan that was started last may still run, Scan waits for it
+	library.Scan()
+
+	data, err := os.ReadFile(filepath.Join(dir, "library.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var index struct {
+		Tracks []library.Track `json:"tracks"`
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil || len(index.Tracks) != 3 {
+		t.Errorf("the index has %d tracks: %v", len(index.Tracks), err)
+	}
+}
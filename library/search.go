@@ -0,0 +1,251 @@
+package library
+
+import (
+	"sort"
+	"strings"
+)
+
+/*
+Returns all artists in the library, sorted by name.
+*/
+func Artists() []Artist {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	counts := map[string]int{}
+
+	for _, t := range tracks {
+		counts[t.AlbumArtist]++
+	}
+
+	list := make([]Artist, 0, len(counts))
+
+	for name, count := range counts {
+		list = append(list, Artist{Name: name, URI: ArtistURI(name), Tracks: count})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+
+	return list
+}
+
+/*
+Returns all albums in the library, only the albums of artist if it is not empty.
+*/
+func Albums(artist string) []Album {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	albums := map[[2]string]*Album{}
+
+	for _, t := range tracks {
+		if artist != "" && !strings.EqualFold(t.AlbumArtist, artist) {
+			continue
+		}
+
+		key := [2]string{t.AlbumArtist, t.Album}
+
+		if albums[key] == nil {
+			albums[key] = &Album{Name: t.Album, Artist: t.AlbumArtist, URI: AlbumURI(t.AlbumArtist, t.Album)}
+		}
+
+		albums[key].Tracks++
+	}
+
+	list := make([]Album, 0, len(albums))
+
+	for _, album := range albums {
+		list = append(list, *album)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Artist != list[j].Artist {
+			return strings.ToLower(list[i].Artist) < strings.ToLower(list[j].Artist)
+		}
+
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+
+	return list
+}
+
+/*
+Returns the tracks of an album (or of all albums when album is empty) in album order, only of artist if it is not empty.
+*/
+func Tracks(artist string, album string) []Track {
+	mu.RLock()
+	list := []Track{}
+
+	for _, t := range tracks {
+		if artist != "" && !strings.EqualFold(t.AlbumArtist, artist) && !strings.EqualFold(t.Artist, artist) {
+			continue
+		}
+
+		if album != "" && !strings.EqualFold(t.Album, album) {
+			continue
+		}
+
+		list = append(list, *t)
+	}
+	mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+
+		if a.AlbumArtist != b.AlbumArtist {
+			return strings.ToLower(a.AlbumArtist) < strings.ToLower(b.AlbumArtist)
+		}
+
+		if a.Album != b.Album {
+			return strings.ToLower(a.Album) < strings.ToLower(b.Album)
+		}
+
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+
+		if a.Number != b.Number {
+			return a.Number < b.Number
+		}
+
+		return a.Path < b.Path
+	})
+
+	return list
+}
+
+/*
+Search the library for tracks, albums and artists matching every word in query. At most limit hits are returned per category.
+*/
+func Search(query string, limit int) *SearchResult {
+	words := strings.Fields(strings.ToLower(query))
+	result := &SearchResult{CategoriesOrder: []string{"tracks", "albums", "artists"}}
+
+	if len(words) == 0 {
+		return result
+	}
+
+	type scored struct {
+		track Track
+		score int
+	}
+
+	var hits []scored
+	albums := map[string]AlbumHit{}
+	artists := map[string]ArtistHit{}
+
+	mu.RLock()
+	for _, t := range tracks {
+		if score := match(words, t.Title, t.Artist, t.Album); score > 0 {
+			hits = append(hits, scored{*t, score})
+		}
+
+		if match(words, t.Album, t.AlbumArtist) > 0 {
+			uri := AlbumURI(t.AlbumArtist, t.Album)
+			albums[uri] = AlbumHit{
+				Name:    t.Album,
+				URI:     uri,
+				Artists: []NamedURI{{Name: t.AlbumArtist, URI: ArtistURI(t.AlbumArtist)}},
+			}
+		}
+
+		if match(words, t.Artist) > 0 {
+			artists[t.Artist] = ArtistHit{Name: t.Artist, URI: ArtistURI(t.Artist)}
+		}
+	}
+	mu.RUnlock()
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+
+		return strings.ToLower(hits[i].track.Title) < strings.ToLower(hits[j].track.Title)
+	})
+
+	tracks := &result.Results.Tracks
+	tracks.Total = len(hits)
+
+	for i, hit := range hits {
+		if i == limit {
+			break
+		}
+
+		t := hit.track
+		tracks.Hits = append(tracks.Hits, TrackHit{
+			Name:     t.Title,
+			URI:      t.URI,
+			Artists:  []NamedURI{{Name: t.Artist, URI: ArtistURI(t.Artist)}},
+			Album:    NamedURI{Name: t.Album, URI: AlbumURI(t.AlbumArtist, t.Album)},
+			Duration: t.Duration,
+		})
+	}
+
+	result.Results.Albums.Total = len(albums)
+	for _, album := range albums {
+		result.Results.Albums.Hits = append(result.Results.Albums.Hits, album)
+	}
+
+	result.Results.Artists.Total = len(artists)
+	for _, artist := range artists {
+		result.Results.Artists.Hits = append(result.Results.Artists.Hits, artist)
+	}
+
+	sort.Slice(result.Results.Albums.Hits, func(i, j int) bool {
+		return result.Results.Albums.Hits[i].Name < result.Results.Albums.Hits[j].Name
+	})
+
+	sort.Slice(result.Results.Artists.Hits, func(i, j int) bool {
+		return result.Results.Artists.Hits[i].Name < result.Results.Artists.Hits[j].Name
+	})
+
+	if len(result.Results.Albums.Hits) > limit {
+		result.Results.Albums.Hits = result.Results.Albums.Hits[:limit]
+	}
+
+	if len(result.Results.Artists.Hits) > limit {
+		result.Results.Artists.Hits = result.Results.Artists.Hits[:limit]
+	}
+
+	return result
+}
+
+/*
+Returns how well the fields match all words, 0 means no match. A field equal to the whole query scores highest,
+then fields starting with a word, then fields only containing a word.
+*/
+func match(words []string, fields ...string) int {
+	score := 0
+	query := strings.Join(words, " ")
+
+	for _, field := range fields {
+		if strings.ToLower(field) == query {
+			score += 10
+		}
+	}
+
+	for _, word := range words {
+		best := 0
+
+		for _, field := range fields {
+			field = strings.ToLower(field)
+
+			switch {
+			case strings.HasPrefix(field, word):
+				best = max(best, 2)
+			case strings.Contains(field, word):
+				best = max(best, 1)
+			}
+		}
+
+		if best == 0 {
+			return 0
+		}
+
+		score += best
+	}
+
+	return score
+}
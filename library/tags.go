@@ -0,0 +1,143 @@
+package library
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/dhowden/tag"
+)
+
+/*
+Read the tags and duration of an audio file. ID3v2 is preferred, fields it leaves empty are filled from ID3v1.
+FLAC files use their Vorbis comments.
+*/
+func readTrack(path string, info os.FileInfo) (*Track, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	t := &Track{
+		URI:     TrackURI(path),
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	if m, err := tag.ReadFrom(f); err == nil {
+		fillTrack(t, m)
+	}
+
+	if t.Title == "" || t.Artist == "" || t.Album == "" {
+		if m, err := tag.ReadID3v1Tags(f); err == nil {
+			fillTrack(t, m)
+		}
+	}
+
+	if t.Title == "" {
+		t.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	if t.AlbumArtist == "" {
+		t.AlbumArtist = t.Artist
+	}
+
+	streamer, format, err := audio.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.Duration = int(format.SampleRate.D(streamer.Len()).Milliseconds())
+	streamer.Close()
+
+	return t, nil
+}
+
+/*
+Copy the tags from m into t, fields that are already set are kept.
+*/
+func fillTrack(t *Track, m tag.Metadata) {
+	set := func(field *string, value string) {
+		if *field == "" {
+			*field = strings.TrimSpace(value)
+		}
+	}
+
+	set(&t.Title, m.Title())
+	set(&t.Artist, m.Artist())
+	set(&t.AlbumArtist, m.AlbumArtist())
+	set(&t.Album, m.Album())
+	set(&t.Genre, m.Genre())
+	set(&t.ISRC, rawTag(m, "TSRC", "isrc"))
+
+	if t.TrackGain == 0 {
+		t.TrackGain = parseGain(rawTag(m, "replaygain_track_gain"))
+		t.TrackPeak = parseGain(rawTag(m, "replaygain_track_peak"))
+		t.AlbumGain = parseGain(rawTag(m, "replaygain_album_gain"))
+		t.AlbumPeak = parseGain(rawTag(m, "replaygain_album_peak"))
+	}
+
+	if t.Year == 0 {
+		t.Year = m.Year()
+	}
+
+	if t.BPM == 0 {
+		bpm, _ := strconv.ParseFloat(strings.TrimSpace(rawTag(m, "TBPM", "bpm")), 64)
+		t.BPM = int(math.Round(bpm))
+	}
+
+	if t.Number == 0 {
+		t.Number, _ = m.Track()
+	}
+
+	if t.DiscNumber == 0 {
+		t.DiscNumber, _ = m.Disc()
+	}
+}
+
+/*
+Look up a tag that dhowden/tag does not expose, by its ID3 frame, ID3 TXXX description or Vorbis comment name.
+*/
+func rawTag(m tag.Metadata, names ...string) string {
+	raw := m.Raw()
+
+	for _, name := range names {
+		for key, value := range raw {
+			if comm, ok := value.(*tag.Comm); ok && strings.EqualFold(comm.Description, name) {
+				return comm.Text
+			}
+
+			if !strings.EqualFold(key, name) {
+				continue
+			}
+
+			if s, ok := value.(string); ok {
+				return s
+			}
+		}
+	}
+
+	return ""
+}
+
+/*
+Parse ReplayGain values like "-6.54 dB" or "0.988312", returns 0 for anything else.
+*/
+func parseGain(value string) float64 {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "dB"))
+	gain, err := strconv.ParseFloat(value, 64)
+
+	if err != nil {
+		return 0
+	}
+
+	return gain
+}
@@ -0,0 +1,88 @@
+package library
+
+import "time"
+
+type Track struct {
+	URI         string    `json:"uri"`
+	Path        string    `json:"path"`
+	Title       string    `json:"title"`
+	Artist      string    `json:"artist"`
+	AlbumArtist string    `json:"albumArtist"`
+	Album       string    `json:"album"`
+	Genre       string    `json:"genre"`
+	Year        int       `json:"year"`
+	Number      int       `json:"number"`
+	DiscNumber  int       `json:"discNumber"`
+	Duration    int       `json:"duration"` // ms
+	ISRC        string    `json:"isrc"`
+	BPM         int       `json:"bpm,omitempty"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+
+	// ReplayGain adjustments in dB and peak amplitudes, zero when the file is not tagged.
+	TrackGain float64 `json:"trackGain,omitempty"`
+	TrackPeak float64 `json:"trackPeak,omitempty"`
+	AlbumGain float64 `json:"albumGain,omitempty"`
+	AlbumPeak float64 `json:"albumPeak,omitempty"`
+}
+
+type Album struct {
+	Name   string `json:"name"`
+	Artist string `json:"artist"`
+	URI    string `json:"uri"`
+	Tracks int    `json:"tracks"`
+}
+
+type Artist struct {
+	Name   string `json:"name"`
+	URI    string `json:"uri"`
+	Tracks int    `json:"tracks"`
+}
+
+type NamedURI struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+type TrackHit struct {
+	Name     string     `json:"name"`
+	URI      string     `json:"uri"`
+	Image    string     `json:"image"`
+	Artists  []NamedURI `json:"artists"`
+	Album    NamedURI   `json:"album"`
+	Duration int        `json:"duration"`
+}
+
+type AlbumHit struct {
+	Name    string     `json:"name"`
+	URI     string     `json:"uri"`
+	Image   string     `json:"image"`
+	Artists []NamedURI `json:"artists"`
+}
+
+type ArtistHit struct {
+	Name  string `json:"name"`
+	URI   string `json:"uri"`
+	Image string `json:"image"`
+}
+
+/*
+Search results for the local library, in the same shape as spotify.SearchResult so clients can handle both alike.
+*/
+type SearchResult struct {
+	Results struct {
+		Tracks struct {
+			Hits  []TrackHit `json:"hits"`
+			Total int        `json:"total"`
+		} `json:"tracks"`
+		Albums struct {
+			Hits  []AlbumHit `json:"hits"`
+			Total int        `json:"total"`
+		} `json:"albums"`
+		Artists struct {
+			Hits  []ArtistHit `json:"hits"`
+			Total int         `json:"total"`
+		} `json:"artists"`
+	} `json:"results"`
+	CategoriesOrder []string `json:"categoriesOrder"`
+}
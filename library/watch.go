@@ -0,0 +1,63 @@
+package library
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/rjeczalik/notify"
+)
+
+var events = make(chan notify.EventInfo, 128)
+
+/*
+Watch the library directories recursively and index files as they change.
+Changes are collected for a moment before the index is written, copying an album triggers a lot of events.
+*/
+func watch() {
+	watchDirs(utils.Cfg().Library.Dirs)
+
+	changed := map[string]bool{}
+	flush := time.NewTimer(time.Hour)
+	flush.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			if audio.Supported(event.Path()) {
+				changed[event.Path()] = true
+				flush.Reset(2 * time.Second)
+			}
+		case <-flush.C:
+			dirty := false
+
+			for path := range changed {
+				if update(path) {
+					dirty = true
+				}
+			}
+
+			changed = map[string]bool{}
+
+			if dirty {
+				Log("Library changed on disk, saving index")
+				save()
+			}
+		}
+	}
+}
+
+/*
+Replace the watched directories, events keeps its buffer so nothing that is still queued gets lost.
+*/
+func watchDirs(dirs []string) {
+	notify.Stop(events)
+
+	for _, dir := range dirs {
+		if err := notify.Watch(filepath.Join(dir, "..."), events, notify.Create, notify.Write, notify.Remove, notify.Rename); err != nil {
+			logger.Err("Could not watch "+dir, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package live
+
+import (
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+)
+
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+var mu sync.Mutex
+var subscribers = map[chan Event]bool{}
+
+/*
+Send an event to every subscriber. Subscribers that cannot keep up miss events instead of blocking the publisher.
+*/
+func Publish(eventType string, data any) {
+	event := Event{Type: eventType, Time: time.Now(), Data: data}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for c := range subscribers {
+		select {
+		case c <- event:
+		default:
+			logger.Warn("[Live] Subscriber is too slow, dropped " + eventType)
+		}
+	}
+}
+
+/*
+Receive all events published from now on. Call the returned function to stop receiving them.
+*/
+func Subscribe() (<-chan Event, func()) {
+	c := make(chan Event, 64)
+
+	mu.Lock()
+	subscribers[c] = true
+	mu.Unlock()
+
+	return c, func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if subscribers[c] {
+			delete(subscribers, c)
+			close(c)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package local_test
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/local"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+func TestMain(m *testing.M) {
+	utils.LoadConfig()
+
+	os.Exit(m.Run())
+}
+
+func constant(n int, v float64) []float64 {
+	out := make([]float64, n)
+
+	for i := range out {
+		out[i] = v
+	}
+
+	return out
+}
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestGapless(t *testing.T) {
+	local.LoadDeck(0, constant(100, 0.5), constant(100, -0.5))
+
+	got := local.StreamDeck(150)
+
+	if got[99] != 0.5 || got[100] != -0.5 {
+		t.Errorf("the tracks meet as %v, %v", got[99], got[100])
+	}
+
+	// The deck moved on by itself, the queue knows right away
+	if index := local.DeckIndex(); index != 1 {
+		t.Errorf("the queue is at %d after the first track", index)
+	}
+
+	got = local.StreamDeck(100)
+
+	if got[49] != -0.5 || got[50] != 0 || local.DeckIndex() != 2 {
+		t.Errorf("the queue is at %d, the end sounds like %v, %v", local.DeckIndex(), got[49], got[50])
+	}
+}
+
+func TestCrossfade(t *testing.T) {
+	local.LoadDeck(40, constant(100, 1), constant(100, 2))
+
+	got := local.StreamDeck(200)
+
+	if got[59] != 1 {
+		t.Errorf("the fade started early: %v", got[59])
+	}
+
+	// Equal power: the outgoing track follows a cosine and the incoming one a sine
+	for _, i := range []int{0, 10, 20, 39} {
+		x := float64(i) / 40
+		want := math.Cos(x*math.Pi/2) + 2*math.Sin(x*math.Pi/2)
+
+		if !near(got[60+i], want) {
+			t.Errorf("%d samples into the fade got %v, want %v", i, got[60+i], want)
+		}
+	}
+
+	// The next track overlapped by 40 samples, so both together take 160
+	if got[159] != 2 || got[160] != 0 {
+		t.Errorf("the second track ends as %v, %v", got[159], got[160])
+	}
+
+	if index := local.DeckIndex(); index != 1 {
+		t.Errorf("the queue is at %d during the second track", index)
+	}
+
+	local.StreamDeck(1)
+
+	if index := local.DeckIndex(); index != 2 {
+		t.Errorf("the queue is at %d after the second track", index)
+	}
+}
+
+func TestReplayGain(t *testing.T) {
+	track := library.Track{TrackGain: -6, TrackPeak: 0.4, AlbumGain: 10, AlbumPeak: 0.5}
+
+	cases := []struct {
+		mode   string
+		preamp float64
+		track  library.Track
+		want   float64
+	}{
+		{"track", 0, track, math.Pow(10, -6.0/20)},
+		{"track", 3, track, math.Pow(10, -3.0/20)},
+		// +10 dB would clip a peak of 0.5
+		{"album", 0, track, 2},
+		{"album", 0, library.Track{TrackGain: -6}, math.Pow(10, -6.0/20)},
+		{"off", 0, track, 1},
+		{"track", 0, library.Track{}, 1},
+	}
+
+	defer func() {
+		viper.Set("local.replaygain", "track")
+		viper.Set("local.preamp", 0.0)
+		utils.LoadConfig()
+	}()
+
+	for _, c := range cases {
+		viper.Set("local.replaygain", c.mode)
+		viper.Set("local.preamp", c.preamp)
+		utils.LoadConfig()
+
+		if got := local.ReplayGain(c.track); !near(got, c.want) {
+			t.Errorf("%s with %v dB preamp for %+v got %v, want %v", c.mode, c.preamp, c.track, got, c.want)
+		}
+	}
+}
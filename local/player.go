@@ -0,0 +1,375 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/faiface/beep/speaker"
+)
+
+type State struct {
+	Playing  bool            `json:"playing"`
+	Paused   bool            `json:"paused"`
+	Track    *library.Track  `json:"track"`
+	Position int             `json:"position"` // ms
+	Index    int             `json:"index"`
+	Queue    []library.Track `json:"queue"`
+}
+
+// mu guards the queue, fields of the deck are only touched while holding the speaker lock.
+var mu sync.Mutex
+var queue []library.Track
+
+var d = &deck{}
+var advanced = make(chan struct{}, 16)
+
+/*
+Start the local player, it stays silent on the local output until something is played.
+*/
+func Init() {
+	Log("Initializing local player")
+
+	d.fade = audio.SampleRate().N(utils.Cfg().Local.Crossfade.Duration)
+
+	if err := audio.Add(d); err != nil {
+		logger.Err("Local player is not available", err)
+		return
+	}
+
+	utils.OnConfigChange(func(old utils.Config, new utils.Config) {
+		speaker.Lock()
+		d.fade = audio.SampleRate().N(new.Local.Crossfade.Duration)
+		speaker.Unlock()
+	})
+
+	go func() {
+		for range advanced {
+			onAdvance()
+		}
+	}()
+}
+
+/*
+Replace the local queue with tracks and start playing the first one.
+*/
+func Play(tracks []library.Track) error {
+	if len(tracks) == 0 {
+		return errors.New("nothing to play")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	queue = tracks
+
+	return jump(0)
+}
+
+/*
+Add tracks to the end of the local queue.
+*/
+func Enqueue(tracks []library.Track) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	queue = append(queue, tracks...)
+	preload()
+}
+
+/*
+Skip to the next track in the local queue.
+*/
+func Next() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return jump(position() + 1)
+}
+
+/*
+Skip straight to track i of the local queue, without opening the tracks in between.
+*/
+func Jump(i int) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return jump(i)
+}
+
+/*
+Go back to the previous track in the local queue.
+*/
+func Prev() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return jump(max(position()-1, 0))
+}
+
+func Pause() {
+	speaker.Lock()
+	d.paused = true
+	speaker.Unlock()
+}
+
+func Resume() {
+	speaker.Lock()
+	d.paused = false
+	speaker.Unlock()
+}
+
+/*
+Stop playing and clear the local queue.
+*/
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	queue = nil
+
+	speaker.Lock()
+	d.replace(nil)
+	d.index = 0
+	speaker.Unlock()
+}
+
+func Current() State {
+	mu.Lock()
+	defer mu.Unlock()
+
+	state := State{Queue: append([]library.Track{}, queue...)}
+
+	speaker.Lock()
+	state.Index = d.index
+
+	if d.current != nil {
+		track := d.current.track
+		state.Track = &track
+		state.Playing = !d.paused
+		state.Paused = d.paused
+		state.Position = int(audio.SampleRate().D(d.current.played).Milliseconds())
+	}
+	speaker.Unlock()
+
+	return state
+}
+
+/*
+Start playing the track at position i in the queue, tracks that cannot be opened are skipped. Must hold mu.
+*/
+func jump(i int) error {
+	for ; i < len(queue); i++ {
+		src, err := open(queue[i])
+
+		if err != nil {
+			logger.Err("Could not open "+queue[i].Path, err)
+			continue
+		}
+
+		Log("Playing " + src.track.Title)
+
+		speaker.Lock()
+		d.replace(src)
+		d.index = i
+		d.paused = false
+		speaker.Unlock()
+
+		preload()
+
+		return nil
+	}
+
+	speaker.Lock()
+	d.replace(nil)
+	d.index = len(queue)
+	speaker.Unlock()
+
+	return errors.New("reached the end of the local queue")
+}
+
+/*
+Decode the start of the next track ahead of time, so the deck can switch to it without a gap. Must hold mu.
+*/
+func preload() {
+	speaker.Lock()
+	ready := d.next != nil || d.current == nil || (!utils.Cfg().Local.Gapless && d.fade == 0)
+	at := d.index
+	speaker.Unlock()
+
+	for i := at + 1; !ready && i < len(queue); i++ {
+		src, err := open(queue[i])
+
+		if err != nil {
+			logger.Err("Could not open "+queue[i].Path, err)
+			continue
+		}
+
+		speaker.Lock()
+		defer speaker.Unlock()
+
+		// The deck finished the track while the next one was being opened, onAdvance preloads again
+		if d.index != at || d.current == nil || d.next != nil {
+			src.close()
+			return
+		}
+
+		// Tracks that cannot be opened are dropped, so the deck and the queue agree on what comes next
+		queue = append(queue[:at+1], queue[i:]...)
+		d.next = src
+
+		return
+	}
+}
+
+/*
+Called after the deck finished a track, the deck has already moved on to the preloaded track if there was one and
+points at the track after the finished one either way.
+*/
+func onAdvance() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	speaker.Lock()
+	playing := d.current != nil
+	at := d.index
+	speaker.Unlock()
+
+	if playing {
+		preload()
+		return
+	}
+
+	if err := jump(at); err != nil {
+		Log(fmt.Sprint(err))
+	}
+}
+
+/*
+Returns the position of the current track in the queue.
+*/
+func position() int {
+	speaker.Lock()
+	defer speaker.Unlock()
+
+	return d.index
+}
+
+/*
+The streamer that is permanently added to the local output. It plays the current track and, when the configured
+crossfade window is reached, fades it out while fading in the preloaded next track.
+*/
+type deck struct {
+	current *source
+	next    *source
+	// The position of current in the queue, moved along with it
+	index   int
+	paused  bool
+	fade    int // output samples
+	scratch [][2]float64
+}
+
+func (d *deck) Stream(samples [][2]float64) (int, bool) {
+	for filled := 0; filled < len(samples); {
+		buf := samples[filled:]
+
+		if d.paused || d.current == nil {
+			clear(buf)
+			break
+		}
+
+		if d.next != nil && d.fade > 0 {
+			left := d.current.remaining()
+
+			if left <= d.fade {
+				filled += d.crossfade(buf, left)
+				continue
+			}
+
+			buf = buf[:min(len(buf), left-d.fade)]
+		}
+
+		n, _ := d.current.Stream(buf)
+		filled += n
+
+		if n < len(buf) {
+			d.advance()
+		}
+	}
+
+	return len(samples), true
+}
+
+func (d *deck) Err() error {
+	return nil
+}
+
+/*
+Mix the end of the current track with the start of the next one using an equal power curve, left is how many samples
+the current track has left.
+*/
+func (d *deck) crossfade(buf [][2]float64, left int) int {
+	if cap(d.scratch) < len(buf) {
+		d.scratch = make([][2]float64, len(buf))
+	}
+
+	in := d.scratch[:len(buf)]
+	clear(in)
+
+	n, _ := d.current.Stream(buf)
+	d.next.Stream(in)
+
+	for i := range buf {
+		x := math.Min(float64(d.fade-left+i)/float64(d.fade), 1)
+		out := [2]float64{}
+
+		if i < n {
+			out = buf[i]
+		}
+
+		fadeOut, fadeIn := math.Cos(x*math.Pi/2), math.Sin(x*math.Pi/2)
+		buf[i][0] = out[0]*fadeOut + in[i][0]*fadeIn
+		buf[i][1] = out[1]*fadeOut + in[i][1]*fadeIn
+	}
+
+	if n < len(buf) {
+		d.advance()
+	}
+
+	return len(buf)
+}
+
+/*
+Move on to the preloaded track (if any) and let the queue know.
+*/
+func (d *deck) advance() {
+	d.current.close()
+	d.current, d.next = d.next, nil
+	d.index++
+
+	select {
+	case advanced <- struct{}{}:
+	default:
+	}
+}
+
+func (d *deck) replace(src *source) {
+	if d.current != nil {
+		d.current.close()
+	}
+
+	if d.next != nil {
+		d.next.close()
+	}
+
+	d.current, d.next = src, nil
+}
+
+func Log(str string) {
+	logger.Verbose("[Local] " + str)
+}
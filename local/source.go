@@ -0,0 +1,115 @@
+package local
+
+import (
+	"math"
+
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/faiface/beep"
+)
+
+/*
+A decoded track, resampled to the output rate with ReplayGain applied. The first part of the track is decoded
+ahead of time so switching to it never has to wait on the disk.
+*/
+type source struct {
+	track    library.Track
+	decoder  beep.StreamSeekCloser
+	stream   beep.Streamer
+	ratio    float64 // output samples per decoded sample
+	gain     float64
+	buffered [][2]float64
+	played   int // output samples
+}
+
+func open(track library.Track) (*source, error) {
+	decoder, format, err := audio.Open(track.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &source{
+		track:   track,
+		decoder: decoder,
+		stream:  audio.Resample(decoder, format),
+		ratio:   float64(audio.SampleRate()) / float64(format.SampleRate),
+		gain:    replayGain(track),
+	}
+
+	s.buffered = make([][2]float64, audio.SampleRate().N(utils.Cfg().Local.Preload.Duration))
+	n, _ := s.stream.Stream(s.buffered)
+	s.buffered = s.buffered[:n]
+
+	return s, nil
+}
+
+func (s *source) Stream(samples [][2]float64) (int, bool) {
+	n := 0
+
+	if len(s.buffered) > 0 {
+		n = copy(samples, s.buffered)
+		s.buffered = s.buffered[n:]
+	}
+
+	if n < len(samples) {
+		m, _ := s.stream.Stream(samples[n:])
+		n += m
+	}
+
+	for i := range samples[:n] {
+		samples[i][0] *= s.gain
+		samples[i][1] *= s.gain
+	}
+
+	s.played += n
+
+	return n, n > 0
+}
+
+func (s *source) Err() error {
+	return s.decoder.Err()
+}
+
+/*
+Returns how many output samples are left in this track.
+*/
+func (s *source) remaining() int {
+	left := int(float64(s.decoder.Len()-s.decoder.Position())*s.ratio) + len(s.buffered)
+
+	return max(left, 0)
+}
+
+func (s *source) close() {
+	s.decoder.Close()
+}
+
+/*
+Returns the linear gain for a track according to local.replaygain (track, album or off) and local.preamp,
+limited by the tagged peak so normalizing never clips.
+*/
+func replayGain(t library.Track) float64 {
+	gain, peak := t.TrackGain, t.TrackPeak
+
+	switch utils.Cfg().Local.ReplayGain {
+	case "off":
+		return 1
+	case "album":
+		if t.AlbumGain != 0 {
+			gain, peak = t.AlbumGain, t.AlbumPeak
+		}
+	}
+
+	if gain == 0 {
+		return 1
+	}
+
+	linear := math.Pow(10, (gain+utils.Cfg().Local.Preamp)/20)
+
+	if peak > 0 {
+		linear = math.Min(linear, 1/peak)
+	}
+
+	return linear
+}
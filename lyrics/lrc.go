@@ -0,0 +1,75 @@
+package lyrics
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var timeTag = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+var offsetTag = regexp.MustCompile(`^\[offset:\s*([+-]?\d+)\]`)
+
+/*
+Parse LRC lyrics. Lines can have several time tags when they are sung more than once, lines without a time tag
+(like [ar:Artist]) are left out. A positive [offset:ms] makes every line come earlier.
+*/
+func Parse(lrc string) []Line {
+	var lines []Line
+	offset := 0
+
+	for _, raw := range strings.Split(lrc, "\n") {
+		raw = strings.TrimSpace(raw)
+
+		if m := offsetTag.FindStringSubmatch(raw); m != nil {
+			offset, _ = strconv.Atoi(m[1])
+			continue
+		}
+
+		var times []int
+
+		for {
+			m := timeTag.FindStringSubmatch(raw)
+
+			if m == nil {
+				break
+			}
+
+			minutes, _ := strconv.Atoi(m[1])
+			seconds, _ := strconv.Atoi(m[2])
+
+			// .5 is half a second, .05 and .050 are 50ms
+			fraction, _ := strconv.Atoi((m[3] + "000")[:3])
+
+			times = append(times, (minutes*60+seconds)*1000+fraction)
+			raw = raw[len(m[0]):]
+		}
+
+		for _, t := range times {
+			lines = append(lines, Line{Time: t, Text: strings.TrimSpace(raw)})
+		}
+	}
+
+	for i := range lines {
+		lines[i].Time = max(lines[i].Time-offset, 0)
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Time < lines[j].Time
+	})
+
+	return lines
+}
+
+/*
+Returns the plain lines of unsynced lyrics.
+*/
+func plain(text string) []Line {
+	var lines []Line
+
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		lines = append(lines, Line{Text: strings.TrimSpace(line)})
+	}
+
+	return lines
+}
@@ -0,0 +1,314 @@
+package lyrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/local"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+type Line struct {
+	Time int    `json:"time"` // ms, 0 for every line when the lyrics are not synced
+	Text string `json:"text"`
+}
+
+type Lyrics struct {
+	URI string `json:"uri"`
+	// sidecar for an .lrc file next to a local track, lrclib otherwise
+	Source string `json:"source"`
+	Synced bool   `json:"synced"`
+	Lines  []Line `json:"lines"`
+}
+
+/*
+Returns the index of the line that is sung at pos, -1 before the first line.
+*/
+func (l *Lyrics) At(pos int64) int {
+	if !l.Synced {
+		return -1
+	}
+
+	i := -1
+
+	for i+1 < len(l.Lines) && int64(l.Lines[i+1].Time) <= pos {
+		i++
+	}
+
+	return i
+}
+
+// Lyrics that were looked up, nil when a track has none. Cleared when it gets big.
+var mu sync.Mutex
+var cache = map[string]*Lyrics{}
+
+// Tracks that are being looked up right now
+var fetching = map[string]bool{}
+
+var player *spotify.SpotifyPlayer
+
+// The lyrics of what is playing and the line that was sent last
+var current struct {
+	sync.Mutex
+	uri    string
+	lyrics *Lyrics
+	line   int
+}
+
+/*
+Look up the lyrics of every track that plays and send the lines over the live events as they are sung:
+lyricsAvailable when a track has lyrics and lyricsLine for every new line.
+*/
+func Init(state *spotify.SpotifyPlayer) {
+	player = state
+
+	if !utils.Cfg().Lyrics.Enabled {
+		return
+	}
+
+	Log("Following the lyrics")
+
+	go func() {
+		// Local tracks have no events, so the position is checked often instead
+		for range time.Tick(100 * time.Millisecond) {
+			step()
+		}
+	}()
+}
+
+/*
+Returns the lyrics of uri, nil when there are none. Lyrics are only looked up once.
+*/
+func Get(uri string) (*Lyrics, error) {
+	mu.Lock()
+	lyrics, ok := cache[uri]
+	mu.Unlock()
+
+	if ok {
+		return lyrics, nil
+	}
+
+	lyrics, err := find(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(cache) > 200 {
+		cache = map[string]*Lyrics{}
+	}
+
+	cache[uri] = lyrics
+
+	return lyrics, nil
+}
+
+/*
+Returns the lyrics of what is playing and the line that is sung right now.
+*/
+func Current() (*Lyrics, int) {
+	current.Lock()
+	defer current.Unlock()
+
+	return current.lyrics, current.line
+}
+
+/*
+Check what is playing and where, and publish the line when a new one starts.
+*/
+func step() {
+	uri, pos := position()
+
+	current.Lock()
+	defer current.Unlock()
+
+	if uri != current.uri {
+		current.uri = uri
+		current.lyrics = nil
+		current.line = -1
+
+		if uri != "" {
+			go load(uri)
+		}
+	}
+
+	if current.lyrics == nil {
+		return
+	}
+
+	line := current.lyrics.At(pos + utils.Cfg().Lyrics.Lead.Milliseconds())
+
+	if line == current.line {
+		return
+	}
+
+	current.line = line
+
+	if line >= 0 {
+		live.Publish("lyricsLine", map[string]any{"uri": uri, "index": line, "time": current.lyrics.Lines[line].Time, "text": current.lyrics.Lines[line].Text})
+	}
+}
+
+/*
+Look up the lyrics of uri and make them current, if it is still playing by then.
+*/
+func load(uri string) {
+	mu.Lock()
+	busy := fetching[uri]
+	fetching[uri] = true
+	mu.Unlock()
+
+	if busy {
+		return
+	}
+
+	lyrics, err := Get(uri)
+
+	mu.Lock()
+	delete(fetching, uri)
+	mu.Unlock()
+
+	if err != nil {
+		logger.Err("[Lyrics] Could not look up the lyrics of "+uri, err)
+		return
+	}
+
+	if lyrics == nil {
+		return
+	}
+
+	current.Lock()
+	defer current.Unlock()
+
+	if current.uri == uri {
+		current.lyrics = lyrics
+		live.Publish("lyricsAvailable", lyrics)
+	}
+}
+
+/*
+Returns what is playing and the position in ms, the local player goes first.
+*/
+func position() (string, int64) {
+	if state := local.Current(); state.Track != nil {
+		return state.Track.URI, int64(state.Position)
+	}
+
+	s := player.Snapshot()
+
+	return s.URI, s.Position()
+}
+
+func find(uri string) (*Lyrics, error) {
+	if strings.HasPrefix(uri, "local:") {
+		track, ok := library.Lookup(uri)
+
+		if !ok {
+			return nil, errors.New(uri + " is not in the library")
+		}
+
+		sidecar := strings.TrimSuffix(track.Path, filepath.Ext(track.Path)) + ".lrc"
+
+		if lrc, err := os.ReadFile(sidecar); err == nil {
+			return &Lyrics{URI: uri, Source: "sidecar", Synced: true, Lines: Parse(string(lrc))}, nil
+		}
+
+		return lrclib(uri, track.Title, track.Artist, track.Album, track.Duration)
+	}
+
+	track, err := spotify.TrackMetadata(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Spotify knows when a track has no lyrics, like instrumentals
+	if !track.HasLyrics {
+		return nil, nil
+	}
+
+	var artists []string
+
+	for _, artist := range track.Artist {
+		artists = append(artists, artist.Name)
+	}
+
+	return lrclib(uri, track.Name, strings.Join(artists, ", "), track.Album.Name, track.Duration)
+}
+
+/*
+Ask LRCLIB for the lyrics of a track, synced lyrics are preferred. Spotify has no lyrics in its Web API.
+*/
+func lrclib(uri string, title string, artist string, album string, duration int) (*Lyrics, error) {
+	query := url.Values{
+		"track_name":  {title},
+		"artist_name": {artist},
+		"album_name":  {album},
+		"duration":    {strconv.Itoa(duration / 1000)},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(utils.Cfg().Lyrics.URL, "/")+"/api/get?"+query.Encode(), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// LRCLIB asks clients to introduce themselves
+	req.Header.Set("User-Agent", "aether (https://github.com/ODDInvictus/aether)")
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lrclib responded with %s", resp.Status)
+	}
+
+	var result struct {
+		Instrumental bool   `json:"instrumental"`
+		Plain        string `json:"plainLyrics"`
+		Synced       string `json:"syncedLyrics"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case result.Synced != "":
+		return &Lyrics{URI: uri, Source: "lrclib", Synced: true, Lines: Parse(result.Synced)}, nil
+	case result.Plain != "" && !result.Instrumental:
+		return &Lyrics{URI: uri, Source: "lrclib", Lines: plain(result.Plain)}, nil
+	}
+
+	return nil, nil
+}
+
+func Log(str string) {
+	logger.Verbose("[Lyrics] " + str)
+}
@@ -0,0 +1,117 @@
+package lyrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/lyrics"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+var state spotify.SpotifyPlayer
+
+// The number of lookups the mock LRCLIB got
+var lookups atomic.Int32
+
+func TestMain(m *testing.M) {
+	lrclib := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+
+		if r.URL.Path != "/api/get" || r.URL.Query().Get("track_name") != "Highway to Hell" ||
+			r.URL.Query().Get("artist_name") != "AC/DC" || r.URL.Query().Get("duration") != "208" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(`{"instrumental":false,"plainLyrics":"Living easy\nLiving free","syncedLyrics":"[00:00.00] Living easy\n[00:00.30] Living free"}`))
+	}))
+
+	os.Setenv("AETHER_LYRICS_URL", lrclib.URL)
+	utils.LoadConfig()
+
+	lyrics.Init(&state)
+
+	code := m.Run()
+	lrclib.Close()
+	os.Exit(code)
+}
+
+func TestParse(t *testing.T) {
+	lrc := "[ar:AC/DC]\n[ti:Highway to Hell]\n[offset:+100]\n[00:12.5]Living easy\n[00:01.05][00:20.123] Living free\n\n[01:02]Season ticket on a one-way ride\n"
+
+	want := []lyrics.Line{
+		{Time: 950, Text: "Living free"},
+		{Time: 12400, Text: "Living easy"},
+		{Time: 20023, Text: "Living free"},
+		{Time: 61900, Text: "Season ticket on a one-way ride"},
+	}
+
+	if got := lyrics.Parse(lrc); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsed %+v", got)
+	}
+}
+
+func TestAt(t *testing.T) {
+	l := lyrics.Lyrics{Synced: true, Lines: []lyrics.Line{{Time: 1000}, {Time: 2000}, {Time: 2000}, {Time: 3000}}}
+
+	for pos, want := range map[int64]int{0: -1, 999: -1, 1000: 0, 2500: 2, 10000: 3} {
+		if got := l.At(pos); got != want {
+			t.Errorf("line at %d is %d, not %d", pos, got, want)
+		}
+	}
+}
+
+func TestFollow(t *testing.T) {
+	s := spotifytest.Start(t)
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 208000, HasLyrics: true})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 292000})
+	s.AddContext("spotify:playlist:test", "spotify:track:one", "spotify:track:two")
+
+	s.Listen(t, &state)
+
+	events, unsubscribe := live.Subscribe()
+	defer unsubscribe()
+
+	spotify.Load("spotify:playlist:test", true, false)
+
+	var texts []string
+	timeout := time.After(3 * time.Second)
+
+	for len(texts) < 2 {
+		select {
+		case e := <-events:
+			if e.Type == "lyricsLine" {
+				texts = append(texts, e.Data.(map[string]any)["text"].(string))
+			}
+		case <-timeout:
+			t.Fatalf("got lines %v", texts)
+		}
+	}
+
+	if !reflect.DeepEqual(texts, []string{"Living easy", "Living free"}) {
+		t.Errorf("got lines %v", texts)
+	}
+
+	if current, line := lyrics.Current(); current == nil || current.Source != "lrclib" || line != 1 {
+		t.Errorf("current lyrics %+v at line %d", current, line)
+	}
+
+	// Spotify says the second track has no lyrics, LRCLIB is not asked
+	before := lookups.Load()
+
+	if found, err := lyrics.Get("spotify:track:two"); found != nil || err != nil {
+		t.Errorf("lyrics of a track without them: %+v, %v", found, err)
+	}
+
+	if lookups.Load() != before {
+		t.Error("LRCLIB was asked for a track without lyrics")
+	}
+}
@@ -1,23 +1,194 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/announce"
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/autoplay"
+	"github.com/ODDInvictus/aether/chat"
+	"github.com/ODDInvictus/aether/history"
 	"github.com/ODDInvictus/aether/http"
+	"github.com/ODDInvictus/aether/instance"
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/local"
+	"github.com/ODDInvictus/aether/lyrics"
+	"github.com/ODDInvictus/aether/mpd"
+	"github.com/ODDInvictus/aether/mqtt"
+	"github.com/ODDInvictus/aether/policy"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/scrobble"
+	"github.com/ODDInvictus/aether/session"
+	"github.com/ODDInvictus/aether/soundboard"
 	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/supervisor"
 	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/volume"
+	"github.com/ODDInvictus/aether/webhook"
+	"github.com/ODDInvictus/aether/zones"
 )
 
 var spotifyState spotify.SpotifyPlayer
 
+var replay = flag.String("replay", "", "replay a recording (see record.file) instead of connecting to librespot")
+var speed = flag.Float64("speed", 1, "how many times faster than real time to replay, 0 replays at once")
+
 func main() {
+	flag.Parse()
+
+	if *replay != "" {
+		os.Exit(replayRecording(*replay, *speed))
+	}
+
+	os.Exit(run())
+}
+
+/*
+Start everything in order, run until SIGINT or SIGTERM and shut down again. Returns the exit code.
+*/
+func run() int {
 	logger.Log("Starting the Aether")
 	logger.Debug(true)
 	utils.LoadConfig()
 	utils.InitConnectionStatus()
-	
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// librespot outlives ctx, it is needed to pause the music while shutting down
+	supervising, stopSupervising := context.WithCancel(context.Background())
+	supervised := make(chan struct{})
+
+	supervisor.Init()
+
+	go func() {
+		defer close(supervised)
+		supervisor.Run(supervising)
+	}()
+
+	// Player backends and everything that follows their events, before the events start flowing
 	spotify.Init(false)
-	go spotify.ListenToEvents(&spotifyState)
+	audio.Init()
+	queue.Init()
+	policy.Init()
+	history.Init()
+	scrobble.Init()
+	autoplay.Init()
+	announce.Init(&spotifyState)
+	soundboard.Init()
+	library.Init()
+	local.Init()
+	volume.Init(&spotifyState)
+	session.Init(&spotifyState)
+	webhook.Init(&spotifyState)
+	mqtt.Init(&spotifyState)
+	mpd.Init(&spotifyState)
+	chat.Init(&spotifyState)
+	lyrics.Init(&spotifyState)
+	zones.Init(&spotifyState)
+	instance.Init()
+
+	listening := make(chan struct{})
+
+	go func() {
+		defer close(listening)
+		supervisor.Listen(ctx, &spotifyState)
+	}()
+
+	go zones.Listen(ctx)
+
+	http.Init(&spotifyState)
+
+	code := 0
+
+	if err := http.Serve(ctx, utils.Cfg().HTTP.Addr); err != nil {
+		logger.Err("HTTP server failed", err)
+		code = 1
+	}
+
+	// From here on a second signal kills aether right away
+	stop()
+	logger.Log("Shutting down the Aether")
+
+	<-listening
+
+	// Before pausing, the session should resume playing
+	if err := session.Save(); err != nil {
+		logger.Err("Could not save the session", err)
+		code = 1
+	}
+
+	if utils.Cfg().Shutdown.Pause {
+		pause()
+	}
+
+	if err := history.Save(); err != nil {
+		logger.Err("Could not save the play history", err)
+		code = 1
+	}
+
+	mpd.Close()
+	mqtt.Close()
+
+	stopSupervising()
+	<-supervised
+
+	return code
+}
+
+/*
+Fade out and pause the music, the volume is put back afterwards so it is not silent when playback resumes.
+*/
+func pause() {
+	local.Pause()
+
+	if spotifyState.Paused() {
+		return
+	}
+
+	volume, ok := spotifyState.Volume()
+	fade := utils.Cfg().Shutdown.Fade.Duration
+
+	if ok && fade > 0 {
+		spotify.FadeVolume(volume, 0, fade)
+	}
+
+	spotify.Pause()
+
+	if ok && fade > 0 {
+		spotify.SetVolume(volume, 0)
+	}
+}
+
+/*
+Feed a recording through the event handling and print every line with the player state after it, as JSON lines.
+*/
+func replayRecording(path string, speed float64) int {
+	logger.Debug(false)
+	utils.LoadConfig()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	out := json.NewEncoder(os.Stdout)
+
+	err := spotify.Replay(ctx, &spotifyState, path, speed, func(r spotify.Recorded, s spotify.PlayerState) {
+		out.Encode(struct {
+			spotify.Recorded
+			State spotify.PlayerState `json:"state"`
+		}{r, s})
+	})
+
+	if err != nil {
+		logger.Err("Could not replay "+path, err)
+		return 1
+	}
 
-	router := http.Init()
-	router.Run()
+	return 0
 }
@@ -0,0 +1,860 @@
+package mpd
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/local"
+	"github.com/ODDInvictus/aether/policy"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/search"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/volume"
+)
+
+type command struct {
+	run func(c *client, args []string) error
+	// Number of arguments, max is -1 when there is no limit
+	min, max int
+	// Allowed before the password was sent
+	public bool
+}
+
+var commands map[string]command
+
+// Filled in init, because the commands command reads the table
+func init() {
+	commands = map[string]command{
+		"password": {run: password, min: 1, max: 1, public: true},
+		"ping":     {run: nothing, public: true},
+		"idle":     {run: idle, max: -1},
+
+		"status":      {run: status},
+		"currentsong": {run: currentSong},
+		"stats":       {run: stats},
+		"clearerror":  {run: nothing},
+
+		"play":     {run: play, max: 1},
+		"playid":   {run: playID, max: 1},
+		"pause":    {run: pause, max: 1},
+		"stop":     {run: stop},
+		"next":     {run: next},
+		"previous": {run: previous},
+		"seekcur":  {run: seekCur, min: 1, max: 1},
+		"seek":     {run: seek, min: 2, max: 2},
+		"seekid":   {run: seekID, min: 2, max: 2},
+		"random":   {run: random, min: 1, max: 1},
+		"repeat":   {run: repeat, min: 1, max: 1},
+		"single":   {run: onlyOff, min: 1, max: 1},
+		"consume":  {run: onlyOff, min: 1, max: 1},
+
+		"setvol":             {run: setVol, min: 1, max: 1},
+		"volume":             {run: changeVol, min: 1, max: 1},
+		"getvol":             {run: getVol},
+		"replay_gain_status": {run: replayGainStatus},
+
+		"add":            {run: add, min: 1, max: 2},
+		"addid":          {run: addID, min: 1, max: 2},
+		"playlistinfo":   {run: playlistInfo, max: 1},
+		"playlistid":     {run: playlistID, max: 1},
+		"plchanges":      {run: plChanges, min: 1, max: 2},
+		"plchangesposid": {run: plChangesPosID, min: 1, max: 2},
+		"clear":          {run: shared},
+		"delete":         {run: shared, min: 1, max: 1},
+		"deleteid":       {run: shared, min: 1, max: 1},
+		"move":           {run: shared, min: 2, max: 2},
+		"moveid":         {run: shared, min: 2, max: 2},
+		"shuffle":        {run: shared, max: 1},
+		"search":         {run: find, min: 1, max: -1},
+		"find":           {run: find, min: 1, max: -1},
+		"list":           {run: list, min: 1, max: -1},
+		"listplaylists":  {run: nothing},
+		"lsinfo":         {run: nothing, max: 1},
+
+		"outputs":     {run: outputs},
+		"commands":    {run: listCommands},
+		"notcommands": {run: nothing},
+		"tagtypes":    {run: tagTypes, max: -1},
+		"urlhandlers": {run: urlHandlers},
+		"decoders":    {run: nothing},
+	}
+}
+
+/*
+A track in the MPD playlist. Position 0 is what is playing now, Id is always the position plus one.
+*/
+type song struct {
+	file     string
+	title    string
+	artist   string
+	album    string
+	duration int // ms
+}
+
+func (c *client) song(s song, pos int) {
+	c.field("file", s.file)
+
+	if s.title != "" {
+		c.field("Title", s.title)
+	}
+
+	if s.artist != "" {
+		c.field("Artist", s.artist)
+	}
+
+	if s.album != "" {
+		c.field("Album", s.album)
+	}
+
+	c.field("Time", s.duration/1000)
+	c.field("duration", seconds(int64(s.duration)))
+
+	// Search results are not in the playlist
+	if pos >= 0 {
+		c.field("Pos", pos)
+		c.field("Id", pos+1)
+	}
+}
+
+func fromLibrary(t library.Track) song {
+	return song{file: t.URI, title: t.Title, artist: t.Artist, album: t.Album, duration: t.Duration}
+}
+
+func fromSpotify(uri string, t spotify.Track) song {
+	var artists []string
+
+	for _, artist := range t.Artist {
+		artists = append(artists, artist.Name)
+	}
+
+	return song{file: uri, title: t.Name, artist: strings.Join(artists, ", "), album: t.Album.Name, duration: t.Duration}
+}
+
+/*
+The local player takes over from Spotify while it has a track loaded.
+*/
+func localActive() (local.State, bool) {
+	state := local.Current()
+	return state, state.Track != nil
+}
+
+/*
+Returns the playlist and the position of the current track in it, -1 when nothing is loaded. For Spotify that is
+the current track followed by the queue and the rest of the context.
+*/
+func playlist() ([]song, int) {
+	if state, ok := localActive(); ok {
+		songs := make([]song, len(state.Queue))
+
+		for i, t := range state.Queue {
+			songs[i] = fromLibrary(t)
+		}
+
+		return songs, state.Index
+	}
+
+	s := player.Snapshot()
+
+	if s.URI == "" {
+		return nil, -1
+	}
+
+	songs := []song{fromSpotify(s.URI, s.Track)}
+
+	// Without the rest the current track is still worth showing
+	tracks, err := spotify.Tracks(true)
+
+	if err != nil {
+		return songs, 0
+	}
+
+	for _, next := range tracks.Next {
+		duration, _ := strconv.Atoi(next.Metadata.Duration)
+
+		songs = append(songs, song{
+			file:     next.URI,
+			title:    next.Metadata.Title,
+			artist:   next.Metadata.ArtistName,
+			album:    next.Metadata.AlbumTitle,
+			duration: duration,
+		})
+	}
+
+	return songs, 0
+}
+
+func nothing(c *client, args []string) error {
+	return nil
+}
+
+func password(c *client, args []string) error {
+	if args[0] != utils.Cfg().MPD.Password {
+		return fail(errPassword, "incorrect password")
+	}
+
+	c.authorized = true
+
+	return nil
+}
+
+func idle(c *client, args []string) error {
+	c.startIdle(args)
+	return nil
+}
+
+func status(c *client, args []string) error {
+	shuffle, repeat := spotify.Modes()
+	songs, current := playlist()
+
+	state, elapsed, duration := "stop", int64(0), int64(0)
+
+	if l, ok := localActive(); ok {
+		state = "play"
+
+		if l.Paused {
+			state = "pause"
+		}
+
+		elapsed, duration = int64(l.Position), int64(l.Track.Duration)
+	} else if s := player.Snapshot(); s.URI != "" {
+		state = "play"
+
+		if s.Paused {
+			state = "pause"
+		}
+
+		elapsed, duration = s.Position(), int64(s.Track.Duration)
+	}
+
+	if v, ok := player.Volume(); ok {
+		c.field("volume", percent(v))
+	}
+
+	c.field("repeat", flag(repeat != "" && repeat != "none"))
+	c.field("random", flag(shuffle))
+	c.field("single", 0)
+	c.field("consume", 0)
+	c.field("playlist", playlistVersion.Load())
+	c.field("playlistlength", len(songs))
+	c.field("state", state)
+
+	if current >= 0 {
+		c.field("song", current)
+		c.field("songid", current+1)
+
+		if current+1 < len(songs) {
+			c.field("nextsong", current+1)
+			c.field("nextsongid", current+2)
+		}
+
+		c.field("time", fmt.Sprintf("%d:%d", elapsed/1000, duration/1000))
+		c.field("elapsed", seconds(elapsed))
+		c.field("duration", seconds(duration))
+	}
+
+	return nil
+}
+
+func currentSong(c *client, args []string) error {
+	songs, current := playlist()
+
+	if current >= 0 && current < len(songs) {
+		c.song(songs[current], current)
+	}
+
+	return nil
+}
+
+func stats(c *client, args []string) error {
+	songs, playtime := 0, 0
+
+	for _, t := range library.Tracks("", "") {
+		songs++
+		playtime += t.Duration
+	}
+
+	c.field("artists", len(library.Artists()))
+	c.field("albums", len(library.Albums("")))
+	c.field("songs", songs)
+	c.field("uptime", int(time.Since(started).Seconds()))
+	c.field("db_playtime", playtime/1000)
+	c.field("playtime", 0)
+
+	return nil
+}
+
+var started = time.Now()
+
+/*
+Play the song at pos, or resume when no position is given. Spotify can only skip forward, local tracks both ways.
+*/
+func play(c *client, args []string) error {
+	if len(args) == 0 {
+		return resume()
+	}
+
+	pos, err := strconv.Atoi(args[0])
+
+	if err != nil || pos < 0 {
+		return fail(errArg, "Integer expected: %s", args[0])
+	}
+
+	return jump(pos)
+}
+
+func playID(c *client, args []string) error {
+	if len(args) == 0 {
+		return resume()
+	}
+
+	id, err := strconv.Atoi(args[0])
+
+	if err != nil {
+		return fail(errArg, "Integer expected: %s", args[0])
+	}
+
+	return jump(id - 1)
+}
+
+func jump(pos int) error {
+	songs, _ := playlist()
+
+	if pos < 0 || pos >= len(songs) {
+		return fail(errArg, "Bad song index")
+	}
+
+	if _, ok := localActive(); ok {
+		return local.Jump(pos)
+	}
+
+	if !spotify.SkipTo(songs[pos].file, pos) {
+		return fail(errSystem, "Could not skip to %s", songs[pos].file)
+	}
+
+	return resume()
+}
+
+func resume() error {
+	if _, ok := localActive(); ok {
+		local.Resume()
+		return nil
+	}
+
+	return check(spotify.Resume())
+}
+
+func pause(c *client, args []string) error {
+	if len(args) == 0 {
+		if l, ok := localActive(); ok {
+			if l.Paused {
+				local.Resume()
+			} else {
+				local.Pause()
+			}
+
+			return nil
+		}
+
+		return check(spotify.PlayPause())
+	}
+
+	switch args[0] {
+	case "0":
+		return resume()
+	case "1":
+		return stop(c, nil)
+	}
+
+	return fail(errArg, "Boolean (0/1) expected: %s", args[0])
+}
+
+/*
+Neither player can stop without losing its place, so stopping pauses.
+*/
+func stop(c *client, args []string) error {
+	if _, ok := localActive(); ok {
+		local.Pause()
+		return nil
+	}
+
+	return check(spotify.Pause())
+}
+
+func next(c *client, args []string) error {
+	if _, ok := localActive(); ok {
+		return local.Next()
+	}
+
+	return check(spotify.Next())
+}
+
+func previous(c *client, args []string) error {
+	if _, ok := localActive(); ok {
+		return local.Prev()
+	}
+
+	return check(spotify.Prev())
+}
+
+/*
+Seek in the current track, a time starting with + or - is relative to the current position.
+*/
+func seekCur(c *client, args []string) error {
+	if _, ok := localActive(); ok {
+		return fail(errSystem, "seeking is not supported for local tracks")
+	}
+
+	value, err := strconv.ParseFloat(args[0], 64)
+
+	if err != nil {
+		return fail(errArg, "Number expected: %s", args[0])
+	}
+
+	pos := int64(value * 1000)
+
+	if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+		pos += player.Snapshot().Position()
+	}
+
+	return check(spotify.Seek(int(max(pos, 0))))
+}
+
+func seek(c *client, args []string) error {
+	if _, current := playlist(); args[0] != strconv.Itoa(current) {
+		return fail(errArg, "only the current song can be seeked")
+	}
+
+	return seekCur(c, args[1:])
+}
+
+func seekID(c *client, args []string) error {
+	if _, current := playlist(); args[0] != strconv.Itoa(current+1) {
+		return fail(errArg, "only the current song can be seeked")
+	}
+
+	return seekCur(c, args[1:])
+}
+
+func random(c *client, args []string) error {
+	on, err := parseFlag(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	return check(spotify.Shuffle(on))
+}
+
+func repeat(c *client, args []string) error {
+	on, err := parseFlag(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	if on {
+		return check(spotify.Repeat("context"))
+	}
+
+	return check(spotify.Repeat("none"))
+}
+
+func onlyOff(c *client, args []string) error {
+	if on, err := parseFlag(args[0]); err != nil || on {
+		return fail(errArg, "not supported")
+	}
+
+	return nil
+}
+
+/*
+Set the volume in percent, it is capped the same way as through the HTTP api.
+*/
+func setVol(c *client, args []string) error {
+	value, err := strconv.Atoi(args[0])
+
+	if err != nil || value < 0 || value > 100 {
+		return fail(errArg, "Invalid volume value: %s", args[0])
+	}
+
+	return check(spotify.SetVolume(volume.Clamp(value*volume.Max/100), 0))
+}
+
+func changeVol(c *client, args []string) error {
+	change, err := strconv.Atoi(args[0])
+
+	if err != nil {
+		return fail(errArg, "Integer expected: %s", args[0])
+	}
+
+	current, ok := player.Volume()
+
+	if !ok {
+		return fail(errSystem, "the volume is not known yet")
+	}
+
+	value := min(max(percent(current)+change, 0), 100)
+
+	return check(spotify.SetVolume(volume.Clamp(value*volume.Max/100), 0))
+}
+
+func getVol(c *client, args []string) error {
+	if v, ok := player.Volume(); ok {
+		c.field("volume", percent(v))
+	}
+
+	return nil
+}
+
+func replayGainStatus(c *client, args []string) error {
+	c.field("replay_gain_mode", "track")
+	return nil
+}
+
+/*
+Add a Spotify track or episode to the request queue, or local tracks, albums and artists to the local queue.
+The position argument is accepted but the queue is first come, first served.
+*/
+func add(c *client, args []string) error {
+	uri := args[0]
+
+	if strings.HasPrefix(uri, "spotify:") {
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+
+		if _, err := queue.Add(uri, "mpd "+host); err != nil {
+			return fail(errArg, "%s", err)
+		}
+
+		return nil
+	}
+
+	var tracks []library.Track
+
+	if t, ok := library.Lookup(uri); ok {
+		tracks = []library.Track{*t}
+	} else if resolved, err := library.Resolve(uri); err == nil {
+		tracks = resolved
+	} else {
+		return fail(errNoExist, "No such song")
+	}
+
+	if err := policy.CheckLibrary(tracks); err != nil {
+		return fail(errArg, "%s", err)
+	}
+
+	if _, ok := localActive(); ok {
+		local.Enqueue(tracks)
+		return nil
+	}
+
+	return local.Play(tracks)
+}
+
+func addID(c *client, args []string) error {
+	if err := add(c, args); err != nil {
+		return err
+	}
+
+	uri := args[0]
+
+	if t, ok := library.Lookup(uri); ok {
+		uri = t.URI
+	}
+
+	// The queue decides where the track goes, the last occurrence is the one just added
+	songs, _ := playlist()
+
+	for i := len(songs) - 1; i >= 0; i-- {
+		if songs[i].file == uri {
+			c.field("Id", i+1)
+			break
+		}
+	}
+
+	return nil
+}
+
+/*
+List the playlist, or the songs at a position or in a start:end range.
+*/
+func playlistInfo(c *client, args []string) error {
+	songs, _ := playlist()
+	start, end := 0, len(songs)
+
+	if len(args) > 0 {
+		var err error
+
+		if start, end, err = parseRange(args[0], len(songs)); err != nil {
+			return err
+		}
+	}
+
+	for i := start; i < end; i++ {
+		c.song(songs[i], i)
+	}
+
+	return nil
+}
+
+func playlistID(c *client, args []string) error {
+	songs, _ := playlist()
+
+	if len(args) == 0 {
+		for i, s := range songs {
+			c.song(s, i)
+		}
+
+		return nil
+	}
+
+	id, err := strconv.Atoi(args[0])
+
+	if err != nil || id < 1 || id > len(songs) {
+		return fail(errNoExist, "No such song")
+	}
+
+	c.song(songs[id-1], id-1)
+
+	return nil
+}
+
+/*
+Changes are not tracked per version, so a client that is behind gets the whole playlist.
+*/
+func plChanges(c *client, args []string) error {
+	if since, _ := strconv.ParseInt(args[0], 10, 64); since == playlistVersion.Load() {
+		return nil
+	}
+
+	return playlistInfo(c, nil)
+}
+
+func plChangesPosID(c *client, args []string) error {
+	if since, _ := strconv.ParseInt(args[0], 10, 64); since == playlistVersion.Load() {
+		return nil
+	}
+
+	songs, _ := playlist()
+
+	for i := range songs {
+		c.field("cpos", i)
+		c.field("Id", i+1)
+	}
+
+	return nil
+}
+
+/*
+The queue is shared by everyone at the party, tracks can only be added.
+*/
+func shared(c *client, args []string) error {
+	return fail(errPermission, "the queue is shared, tracks can only be added")
+}
+
+/*
+Search tracks in Spotify and the local library. Both the old "type value" pairs and filter expressions are
+understood, but only the values are searched for.
+*/
+func find(c *client, args []string) error {
+	query := strings.Join(filterValues(args), " ")
+
+	if query == "" {
+		return fail(errArg, "nothing to search for")
+	}
+
+	page := search.Search(query, []string{search.TypeTrack}, 0, 100)
+
+	for _, result := range page.Results {
+		c.song(song{
+			file:     result.URI,
+			title:    result.Name,
+			artist:   strings.Join(result.Artists, ", "),
+			album:    result.Album,
+			duration: result.Duration,
+		}, -1)
+	}
+
+	return nil
+}
+
+/*
+List the artists or albums in the local library, albums can be limited to an artist.
+*/
+func list(c *client, args []string) error {
+	var artist string
+
+	if values := filterValues(args[1:]); len(values) > 0 {
+		artist = values[0]
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "artist", "albumartist":
+		for _, a := range library.Artists() {
+			c.field("Artist", a.Name)
+		}
+	case "album":
+		seen := map[string]bool{}
+
+		for _, a := range library.Albums(artist) {
+			if !seen[a.Name] {
+				seen[a.Name] = true
+				c.field("Album", a.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+var quoted = regexp.MustCompile(`'((?:[^'\\]|\\.)*)'|"((?:[^"\\]|\\.)*)"`)
+
+/*
+Returns the values to search for in the arguments of search, find and list. Sorting and windows are ignored.
+*/
+func filterValues(args []string) []string {
+	var values []string
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.HasPrefix(args[i], "("):
+			for _, match := range quoted.FindAllStringSubmatch(args[i], -1) {
+				values = append(values, match[1]+match[2])
+			}
+		case args[i] == "sort" || args[i] == "window":
+			i++
+		case i+1 < len(args):
+			// A type followed by the value
+			values = append(values, args[i+1])
+			i++
+		default:
+			values = append(values, args[i])
+		}
+	}
+
+	return values
+}
+
+func outputs(c *client, args []string) error {
+	c.field("outputid", 0)
+	c.field("outputname", "Aether")
+	c.field("plugin", "aether")
+	c.field("outputenabled", 1)
+
+	return nil
+}
+
+func listCommands(c *client, args []string) error {
+	names := make([]string, 0, len(commands))
+
+	for name, cmd := range commands {
+		if c.authorized || cmd.public {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		c.field("command", name)
+	}
+
+	return nil
+}
+
+func tagTypes(c *client, args []string) error {
+	if len(args) > 0 {
+		return nil
+	}
+
+	for _, tag := range []string{"Artist", "Album", "Title"} {
+		c.field("tagtype", tag)
+	}
+
+	return nil
+}
+
+func urlHandlers(c *client, args []string) error {
+	for _, handler := range []string{"spotify:", "local:"} {
+		c.field("handler", handler)
+	}
+
+	return nil
+}
+
+/*
+Turn the result of a spotify wrapper into an ACK.
+*/
+func check(ok bool, err error) error {
+	if ok {
+		return nil
+	}
+
+	if err == nil {
+		return fail(errSystem, "Spotify did not accept the command")
+	}
+
+	return fail(errSystem, "%s", err)
+}
+
+func parseFlag(value string) (bool, error) {
+	if !slices.Contains([]string{"0", "1"}, value) {
+		return false, fail(errArg, "Boolean (0/1) expected: %s", value)
+	}
+
+	return value == "1", nil
+}
+
+/*
+Parse a position or a start:end range, the end is exclusive and may be left out.
+*/
+func parseRange(value string, length int) (int, int, error) {
+	from, to, isRange := strings.Cut(value, ":")
+	start, err := strconv.Atoi(from)
+
+	if err != nil || start < 0 {
+		return 0, 0, fail(errArg, "Integer or range expected: %s", value)
+	}
+
+	end := start + 1
+
+	if isRange {
+		end = length
+
+		if to != "" {
+			if end, err = strconv.Atoi(to); err != nil || end < start {
+				return 0, 0, fail(errArg, "Integer or range expected: %s", value)
+			}
+		}
+	}
+
+	if start >= length && !(isRange && start == length) {
+		return 0, 0, fail(errArg, "Bad song index")
+	}
+
+	return start, min(end, length), nil
+}
+
+func percent(v int) int {
+	return int(math.Round(float64(v) * 100 / volume.Max))
+}
+
+func seconds(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', 3, 64)
+}
+
+func flag(on bool) int {
+	if on {
+		return 1
+	}
+
+	return 0
+}
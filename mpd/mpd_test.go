@@ -0,0 +1,222 @@
+package mpd_test
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/mpd"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/volume"
+)
+
+var state spotify.SpotifyPlayer
+
+func TestMain(m *testing.M) {
+	os.Setenv("AETHER_MPD_ADDR", "127.0.0.1:0")
+	os.Setenv("AETHER_MPD_PASSWORD", "hunter2")
+	utils.LoadConfig()
+
+	queue.Init()
+	volume.Init(&state)
+	mpd.Init(&state)
+
+	code := m.Run()
+	mpd.Close()
+	os.Exit(code)
+}
+
+/*
+A connection to the MPD server.
+*/
+type conn struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+}
+
+func dial(t *testing.T) *conn {
+	c, err := net.Dial("tcp", mpd.Addr())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { c.Close() })
+
+	conn := &conn{t: t, c: c, r: bufio.NewReader(c)}
+
+	if greeting := conn.read(); !strings.HasPrefix(greeting, "OK MPD ") {
+		t.Fatalf("greeted with %q", greeting)
+	}
+
+	return conn
+}
+
+func (c *conn) read() string {
+	c.t.Helper()
+
+	c.c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := c.r.ReadString('\n')
+
+	if err != nil {
+		c.t.Fatalf("reading failed: %v", err)
+	}
+
+	return strings.TrimSuffix(line, "\n")
+}
+
+/*
+Send lines and return the response up to and including the OK or ACK.
+*/
+func (c *conn) send(lines ...string) []string {
+	c.t.Helper()
+
+	c.c.Write([]byte(strings.Join(lines, "\n") + "\n"))
+
+	return c.response()
+}
+
+func (c *conn) response() []string {
+	c.t.Helper()
+
+	var response []string
+
+	for {
+		line := c.read()
+		response = append(response, line)
+
+		if line == "OK" || strings.HasPrefix(line, "ACK ") {
+			return response
+		}
+	}
+}
+
+func TestMPD(t *testing.T) {
+	s := spotifytest.Start(t)
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 208000})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 292000})
+	s.AddTrack("spotify:track:three", spotify.Track{Name: "Africa", Artist: []spotify.Artist{{Name: "Toto"}}, Duration: 295000})
+	s.AddContext("spotify:playlist:test", "spotify:track:one", "spotify:track:two")
+
+	s.Listen(t, &state)
+
+	c := dial(t)
+
+	if response := c.send("status"); !strings.HasPrefix(response[0], "ACK [4@0] {status}") {
+		t.Fatalf("status without a password: %v", response)
+	}
+
+	if response := c.send("password hunter2"); response[0] != "OK" {
+		t.Fatalf("password: %v", response)
+	}
+
+	spotify.Load("spotify:playlist:test", true, false)
+	spotifytest.Eventually(t, "the track to load", func() bool { return state.Snapshot().Track.Name == "Highway to Hell" })
+
+	t.Run("status", func(t *testing.T) {
+		c.t = t
+
+		status := c.send("status")
+
+		for _, want := range []string{"state: play", "playlistlength: 2", "song: 0", "songid: 1", "duration: 208.000"} {
+			if !slices.Contains(status, want) {
+				t.Errorf("status %v has no %q", status, want)
+			}
+		}
+
+		if song := c.send("currentsong"); !slices.Contains(song, "Title: Highway to Hell") || !slices.Contains(song, "Pos: 0") {
+			t.Errorf("currentsong %v", song)
+		}
+
+		if playlist := c.send("playlistinfo"); !slices.Contains(playlist, "file: spotify:track:two") || !slices.Contains(playlist, "Id: 2") {
+			t.Errorf("playlistinfo %v", playlist)
+		}
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		c.t = t
+
+		c.send("pause 1")
+		c.send("setvol 50")
+		c.send(`add "spotify:track:three"`)
+
+		got := s.State()
+
+		if !got.Paused || got.Volume != 32768 || !slices.Equal(got.Queue, []string{"spotify:track:three"}) {
+			t.Fatalf("after the commands the state is %+v", got)
+		}
+
+		if response := c.send("delete 0"); !strings.HasPrefix(response[0], "ACK [4@0] {delete}") {
+			t.Errorf("delete: %v", response)
+		}
+
+		if response := c.send("frobnicate"); response[0] != `ACK [5@0] {frobnicate} unknown command "frobnicate"` {
+			t.Errorf("an unknown command: %v", response)
+		}
+	})
+
+	t.Run("command list", func(t *testing.T) {
+		c.t = t
+
+		response := c.send("command_list_ok_begin", "next", "getvol", "command_list_end")
+
+		if !slices.Equal(response, []string{"list_OK", "volume: 50", "list_OK", "OK"}) {
+			t.Fatalf("command list: %v", response)
+		}
+
+		response = c.send("command_list_begin", "ping", "setvol 101", "ping", "command_list_end")
+
+		if !slices.Equal(response, []string{"ACK [2@1] {setvol} Invalid volume value: 101"}) {
+			t.Fatalf("failing command list: %v", response)
+		}
+
+		if s.State().Track != "spotify:track:three" {
+			t.Errorf("the list did not skip to the request, playing %s", s.State().Track)
+		}
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		c.t = t
+
+		// Drop what changed because of the earlier tests
+		c.send("idle")
+
+		c.c.Write([]byte("idle player\n"))
+		spotify.Resume()
+
+		if response := c.response(); !slices.Equal(response, []string{"changed: player", "OK"}) {
+			t.Fatalf("idle: %v", response)
+		}
+
+		c.c.Write([]byte("idle stored_playlist\n"))
+		spotify.SetVolume(16384, 0)
+
+		if response := c.send("noidle"); !slices.Equal(response, []string{"OK"}) {
+			t.Fatalf("noidle: %v", response)
+		}
+	})
+
+	t.Run("play", func(t *testing.T) {
+		c.t = t
+
+		if response := c.send("play 1"); !slices.Equal(response, []string{"OK"}) {
+			t.Fatalf("play: %v", response)
+		}
+
+		if s.State().Track != "spotify:track:two" {
+			t.Errorf("play 1 did not skip to the second song, playing %s", s.State().Track)
+		}
+
+		if response := c.send("play 5"); response[0] != "ACK [2@0] {play} Bad song index" {
+			t.Errorf("play past the end: %v", response)
+		}
+	})
+}
@@ -0,0 +1,451 @@
+package mpd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+// The protocol version aether claims to speak, clients use it to decide which commands they can send
+const version = "0.23.5"
+
+// ACK error codes, see https://mpd.readthedocs.io/en/latest/protocol.html#failure-responses
+const (
+	errNotList    = 1
+	errArg        = 2
+	errPassword   = 3
+	errPermission = 4
+	errUnknown    = 5
+	errNoExist    = 50
+	errSystem     = 52
+)
+
+/*
+A failed command, sent to the client as ACK [code@index] {command} message.
+*/
+type ack struct {
+	code int
+	msg  string
+}
+
+func (a *ack) Error() string {
+	return a.msg
+}
+
+func fail(code int, format string, args ...any) error {
+	return &ack{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// The idle subsystems that change with each live event
+var subsystems = map[string][]string{
+	"contextChanged":    {"playlist"},
+	"trackChanged":      {"player", "playlist"},
+	"metadataAvailable": {"player"},
+	"playbackPaused":    {"player"},
+	"playbackResumed":   {"player"},
+	"playbackEnded":     {"player"},
+	"playbackFailed":    {"player"},
+	"trackSeeked":       {"player"},
+	"volumeChanged":     {"mixer"},
+	"requestQueued":     {"playlist"},
+	"requestRemoved":    {"playlist"},
+	"sessionResumed":    {"player", "playlist"},
+}
+
+var player *spotify.SpotifyPlayer
+var listener net.Listener
+
+// Goes up every time the playlist changes, clients compare it to know when to fetch the playlist again
+var playlistVersion atomic.Int64
+
+/*
+Returns whether addr only accepts connections from this machine.
+*/
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+/*
+Listen for MPD clients on mpd.addr when it is set, so phones and desktop apps made for MPD can control aether.
+*/
+func Init(state *spotify.SpotifyPlayer) {
+	addr := utils.Cfg().MPD.Addr
+
+	if addr == "" {
+		return
+	}
+
+	player = state
+
+	l, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		logger.Err("[MPD] Could not listen on "+addr, err)
+		return
+	}
+
+	listener = l
+	playlistVersion.Store(1)
+
+	Log("Listening on " + l.Addr().String())
+
+	if utils.Cfg().MPD.Password == "" && !loopback(addr) {
+		logger.Warn("[MPD] Anyone on the network can control aether, set mpd.password or listen on 127.0.0.1")
+	}
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		for event := range events {
+			for _, subsystem := range subsystems[event.Type] {
+				if subsystem == "playlist" {
+					playlistVersion.Add(1)
+				}
+			}
+		}
+	}()
+
+	go serve(l)
+}
+
+/*
+Returns the address the server listens on, empty when it is not running.
+*/
+func Addr() string {
+	if listener == nil {
+		return ""
+	}
+
+	return listener.Addr().String()
+}
+
+/*
+Stop accepting clients, connected clients keep their connection until they hang up.
+*/
+func Close() {
+	if listener != nil {
+		listener.Close()
+	}
+}
+
+func serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+
+		if err != nil {
+			logger.Err("[MPD] Could not accept a client", err)
+			continue
+		}
+
+		go handle(conn)
+	}
+}
+
+/*
+A connected MPD client.
+*/
+type client struct {
+	conn       net.Conn
+	w          *bufio.Writer
+	authorized bool
+
+	// The commands of a command list, nil when no list was started
+	list   []string
+	listOK bool
+
+	// Subsystems that changed since the client last asked, and the ones it is waiting for while idle
+	changed map[string]bool
+	idle    map[string]bool
+	idling  bool
+}
+
+func handle(conn net.Conn) {
+	defer conn.Close()
+
+	c := &client{
+		conn:       conn,
+		w:          bufio.NewWriter(conn),
+		authorized: utils.Cfg().MPD.Password == "",
+		changed:    map[string]bool{},
+	}
+
+	Log("Client connected from " + conn.RemoteAddr().String())
+
+	// Events arrive while the client is idle, so lines are read on their own
+	events, unsubscribe := live.Subscribe()
+	defer unsubscribe()
+
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(conn)
+
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	c.w.WriteString("OK MPD " + version + "\n")
+	c.w.Flush()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok || !c.line(line) {
+				Log("Client disconnected from " + conn.RemoteAddr().String())
+				return
+			}
+		case event := <-events:
+			for _, subsystem := range subsystems[event.Type] {
+				c.changed[subsystem] = true
+			}
+
+			c.notify()
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+/*
+Handle a line sent by the client. Returns false when the connection should be closed.
+*/
+func (c *client) line(line string) bool {
+	name, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+	if c.idling {
+		// While idle the only thing a client may send is noidle
+		if name != "noidle" {
+			return false
+		}
+
+		c.idle = nil
+		c.idling = false
+		c.w.WriteString("OK\n")
+
+		return true
+	}
+
+	switch {
+	case name == "noidle":
+		// The idle ended before the noidle arrived, there is nothing to answer
+	case name == "command_list_begin" || name == "command_list_ok_begin":
+		if c.list != nil {
+			c.ack(fail(errNotList, "Already in a command list"), 0, name)
+			return true
+		}
+
+		c.list = []string{}
+		c.listOK = name == "command_list_ok_begin"
+	case name == "command_list_end":
+		if c.list == nil {
+			c.ack(fail(errNotList, "Not in a command list"), 0, name)
+			return true
+		}
+
+		list := c.list
+		c.list = nil
+
+		for i, line := range list {
+			if name, err := c.run(line); err != nil {
+				c.ack(err, i, name)
+				return true
+			}
+
+			if c.listOK {
+				c.w.WriteString("list_OK\n")
+			}
+		}
+
+		c.w.WriteString("OK\n")
+	case c.list != nil:
+		c.list = append(c.list, line)
+	case name == "close":
+		return false
+	default:
+		if name, err := c.run(line); err != nil {
+			c.ack(err, 0, name)
+		} else if name != "idle" {
+			// idle answers once something changes
+			c.w.WriteString("OK\n")
+		}
+	}
+
+	return true
+}
+
+/*
+Run a single command line, returns the name of the command for the error response.
+*/
+func (c *client) run(line string) (string, error) {
+	args, err := split(line)
+
+	if err != nil {
+		return "", fail(errArg, "%s", err)
+	}
+
+	if len(args) == 0 {
+		return "", fail(errUnknown, "No command given")
+	}
+
+	name := args[0]
+	cmd, ok := commands[name]
+
+	if !ok {
+		return name, fail(errUnknown, "unknown command \"%s\"", name)
+	}
+
+	if !c.authorized && !cmd.public {
+		return name, fail(errPermission, "you don't have permission for \"%s\"", name)
+	}
+
+	if len(args)-1 < cmd.min || (cmd.max >= 0 && len(args)-1 > cmd.max) {
+		return name, fail(errArg, "wrong number of arguments for \"%s\"", name)
+	}
+
+	return name, cmd.run(c, args[1:])
+}
+
+func (c *client) ack(err error, index int, name string) {
+	code := errSystem
+
+	var a *ack
+
+	if errors.As(err, &a) {
+		code = a.code
+	}
+
+	fmt.Fprintf(c.w, "ACK [%d@%d] {%s} %s\n", code, index, name, err.Error())
+}
+
+/*
+Write a key: value line of a response.
+*/
+func (c *client) field(key string, value any) {
+	fmt.Fprintf(c.w, "%s: %v\n", key, value)
+}
+
+/*
+Start waiting for changes to subsystems, any subsystem when none are given. Returns right away when something
+already changed.
+*/
+func (c *client) startIdle(subsystems []string) {
+	c.idling = true
+	c.idle = map[string]bool{}
+
+	for _, subsystem := range subsystems {
+		c.idle[subsystem] = true
+	}
+
+	c.notify()
+}
+
+/*
+End the idle of the client when one of the subsystems it waits for changed.
+*/
+func (c *client) notify() {
+	if !c.idling {
+		return
+	}
+
+	var changed []string
+
+	for subsystem := range c.changed {
+		if len(c.idle) == 0 || c.idle[subsystem] {
+			changed = append(changed, subsystem)
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	for _, subsystem := range changed {
+		c.field("changed", subsystem)
+		delete(c.changed, subsystem)
+	}
+
+	c.w.WriteString("OK\n")
+	c.idle = nil
+	c.idling = false
+}
+
+/*
+Split a command line into its arguments, arguments with spaces are quoted and may escape quotes with a backslash.
+*/
+func split(line string) ([]string, error) {
+	var args []string
+
+	for i := 0; i < len(line); {
+		switch {
+		case line[i] == ' ' || line[i] == '\t':
+			i++
+		case line[i] == '"':
+			var arg strings.Builder
+			i++
+
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+
+				arg.WriteByte(line[i])
+			}
+
+			if i >= len(line) {
+				return nil, errors.New("missing closing '\"'")
+			}
+
+			args = append(args, arg.String())
+			i++
+		default:
+			start := i
+
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				i++
+			}
+
+			args = append(args, line[start:i])
+		}
+	}
+
+	return args, nil
+}
+
+func Log(str string) {
+	logger.Verbose("[MPD] " + str)
+}
@@ -0,0 +1,318 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/volume"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+/*
+What is playing, published to <prefix>/state. The fields are also published on their own under <prefix>/player/
+for clients that can't read JSON.
+*/
+type NowPlaying struct {
+	// playing, paused or idle
+	State    string  `json:"state"`
+	URI      string  `json:"uri"`
+	Context  string  `json:"context"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Album    string  `json:"album"`
+	Cover    string  `json:"cover"`
+	Duration int     `json:"duration"` // ms
+	Position int64   `json:"position"` // ms
+	Volume   float64 `json:"volume"`   // 0 to 1
+}
+
+// The events after which the player state is published again
+var stateEvents = map[string]bool{
+	"contextChanged":    true,
+	"trackChanged":      true,
+	"metadataAvailable": true,
+	"playbackPaused":    true,
+	"playbackResumed":   true,
+	"playbackEnded":     true,
+	"playbackFailed":    true,
+	"trackSeeked":       true,
+	"volumeChanged":     true,
+	"sessionResumed":    true,
+}
+
+var client paho.Client
+var player *spotify.SpotifyPlayer
+
+/*
+Connect to mqtt.broker when it is set. Everything published is retained, so a dashboard that connects later sees
+the current state right away. The connection is retried in the background until the broker is reachable.
+*/
+func Init(state *spotify.SpotifyPlayer) {
+	cfg := utils.Cfg().MQTT
+
+	if cfg.Broker == "" {
+		return
+	}
+
+	player = state
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetWill(topic("availability"), "offline", 1, true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(time.Second).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(30 * time.Second).
+		SetOnConnectHandler(connected).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logger.Warn("[MQTT] Lost the connection to " + cfg.Broker + ": " + err.Error())
+		})
+
+	client = paho.NewClient(opts)
+	client.Connect()
+
+	Log("Connecting to " + cfg.Broker)
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		for event := range events {
+			if stateEvents[event.Type] {
+				publishState()
+			}
+		}
+	}()
+
+	// The position moves and the health changes without events
+	go func() {
+		for range time.Tick(cfg.Interval.Duration) {
+			publishState()
+			publishHealth()
+		}
+	}()
+}
+
+/*
+Tell everyone aether is going away and disconnect.
+*/
+func Close() {
+	if client == nil || !client.IsConnected() {
+		return
+	}
+
+	client.Publish(topic("availability"), 1, true, "offline").WaitTimeout(time.Second)
+	client.Disconnect(250)
+}
+
+/*
+Runs on every (re)connect. The session is not persistent, so the subscription and the retained state are
+sent again every time.
+*/
+func connected(c paho.Client) {
+	Log("Connected to " + utils.Cfg().MQTT.Broker)
+
+	if token := c.Subscribe(topic("cmd/#"), 1, command); token.WaitTimeout(5*time.Second) && token.Error() != nil {
+		logger.Err("[MQTT] Could not subscribe to the commands", token.Error())
+	}
+
+	publish("availability", "online")
+
+	if utils.Cfg().MQTT.Discovery {
+		announce()
+	}
+
+	publishState()
+	publishHealth()
+}
+
+/*
+Handle a message on <prefix>/cmd/<command>.
+*/
+func command(_ paho.Client, msg paho.Message) {
+	name := strings.TrimPrefix(msg.Topic(), topic("cmd/"))
+	payload := strings.TrimSpace(string(msg.Payload()))
+
+	Log(fmt.Sprintf("Command %s %q", name, payload))
+
+	var ok bool
+	var err error
+
+	switch name {
+	case "play":
+		// Home Assistant sends an empty payload to resume and an uri to play something
+		if payload == "" {
+			ok, err = spotify.Resume()
+		} else {
+			ok, err = spotify.Load(payload, true, false)
+		}
+	case "pause":
+		ok, err = spotify.Pause()
+	case "playpause":
+		ok, err = spotify.PlayPause()
+	case "skip", "next":
+		ok, err = spotify.Next()
+	case "previous":
+		ok, err = spotify.Prev()
+	case "volume":
+		var value int
+
+		if value, err = parseVolume(payload); err == nil {
+			ok, err = spotify.SetVolume(volume.Clamp(value), 0)
+		}
+	default:
+		logger.Warn("[MQTT] Unknown command " + msg.Topic())
+		return
+	}
+
+	if !ok {
+		logger.Err("[MQTT] Command "+name+" failed", err)
+	}
+}
+
+/*
+A volume is either a fraction from 0 to 1, like Home Assistant sends, or a librespot volume up to 65536.
+*/
+func parseVolume(payload string) (int, error) {
+	value, err := strconv.ParseFloat(payload, 64)
+
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid volume %q", payload)
+	}
+
+	if value <= 1 {
+		return int(value * volume.Max), nil
+	}
+
+	return int(value), nil
+}
+
+func publishState() {
+	s := player.Snapshot()
+	now := NowPlaying{State: "idle", URI: s.URI, Context: s.ContextURI}
+
+	if s.URI != "" {
+		now.State = "playing"
+
+		if s.Paused {
+			now.State = "paused"
+		}
+
+		var artists []string
+
+		for _, artist := range s.Track.Artist {
+			artists = append(artists, artist.Name)
+		}
+
+		now.Title = s.Track.Name
+		now.Artist = strings.Join(artists, ", ")
+		now.Album = s.Track.Album.Name
+		now.Cover = s.Track.Cover()
+		now.Duration = s.Track.Duration
+		now.Position = s.Position()
+	}
+
+	if s.HasVolume {
+		now.Volume = float64(s.Volume) / volume.Max
+	}
+
+	publish("state", now)
+	publish("player/state", now.State)
+	publish("player/title", now.Title)
+	publish("player/artist", now.Artist)
+	publish("player/album", now.Album)
+	publish("player/albumart", now.Cover)
+	publish("player/duration", strconv.Itoa(now.Duration/1000))
+	publish("player/position", strconv.FormatInt(now.Position/1000, 10))
+	publish("player/volume", strconv.FormatFloat(now.Volume, 'f', 3, 64))
+}
+
+func publishHealth() {
+	health := utils.CheckHealth()
+
+	publish("health", map[string]bool{
+		"spotify": health.Spotify,
+		"mp3":     health.MP3,
+		"audio":   audio.Ready(),
+	})
+}
+
+/*
+Announce aether to Home Assistant. Home Assistant has no MQTT media player of its own, this is the discovery
+message of the mqtt_media_player integration (https://github.com/bkbilly/mqtt_media_player).
+*/
+func announce() {
+	cfg := utils.Cfg().MQTT
+
+	config := map[string]any{
+		"name":               "Aether",
+		"unique_id":          cfg.ClientID,
+		"availability_topic": topic("availability"),
+		"device": map[string]any{
+			"identifiers":  []string{cfg.ClientID},
+			"name":         "Aether",
+			"manufacturer": "O.D.D. Invictus",
+		},
+		"state_state_topic":       topic("player/state"),
+		"state_title_topic":       topic("player/title"),
+		"state_artist_topic":      topic("player/artist"),
+		"state_album_topic":       topic("player/album"),
+		"state_albumart_topic":    topic("player/albumart"),
+		"state_duration_topic":    topic("player/duration"),
+		"state_position_topic":    topic("player/position"),
+		"state_volume_topic":      topic("player/volume"),
+		"command_play_topic":      topic("cmd/play"),
+		"command_pause_topic":     topic("cmd/pause"),
+		"command_playpause_topic": topic("cmd/playpause"),
+		"command_next_topic":      topic("cmd/skip"),
+		"command_previous_topic":  topic("cmd/previous"),
+		"command_volume_topic":    topic("cmd/volume"),
+		"command_playmedia_topic": topic("cmd/play"),
+	}
+
+	data, _ := json.Marshal(config)
+
+	client.Publish(cfg.DiscoveryPrefix+"/media_player/"+cfg.ClientID+"/config", 1, true, data)
+}
+
+/*
+Publish a retained message under the prefix, strings are sent as they are and anything else as JSON.
+*/
+func publish(sub string, payload any) {
+	if client == nil || !client.IsConnected() {
+		return
+	}
+
+	data, ok := payload.(string)
+
+	if !ok {
+		encoded, err := json.Marshal(payload)
+
+		if err != nil {
+			logger.Err("[MQTT] Could not encode "+sub, err)
+			return
+		}
+
+		data = string(encoded)
+	}
+
+	client.Publish(topic(sub), 1, true, data)
+}
+
+func topic(sub string) string {
+	return utils.Cfg().MQTT.Prefix + "/" + sub
+}
+
+func Log(str string) {
+	logger.Verbose("[MQTT] " + str)
+}
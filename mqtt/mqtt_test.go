@@ -0,0 +1,150 @@
+package mqtt_test
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/mqtt"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+/*
+Start an embedded broker on addr, returns a function that stops it.
+*/
+func startBroker(t *testing.T, addr string) func() {
+	broker := mochi.New(nil)
+	broker.AddHook(new(auth.AllowHook), nil)
+
+	if err := broker.AddListener(listeners.NewTCP("tcp", addr, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	go broker.Serve()
+
+	var once sync.Once
+	stop := func() { once.Do(func() { broker.Close() }) }
+	t.Cleanup(stop)
+
+	return stop
+}
+
+/*
+A client that remembers the last message on every topic.
+*/
+type observer struct {
+	mu     sync.Mutex
+	client paho.Client
+	topics map[string]string
+}
+
+func observe(t *testing.T, addr string) *observer {
+	o := &observer{topics: map[string]string{}}
+
+	opts := paho.NewClientOptions().AddBroker("tcp://" + addr).SetClientID("observer-" + t.Name())
+	o.client = paho.NewClient(opts)
+
+	if token := o.client.Connect(); token.WaitTimeout(time.Second) && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	o.client.Subscribe("#", 1, func(_ paho.Client, msg paho.Message) {
+		o.mu.Lock()
+		o.topics[msg.Topic()] = string(msg.Payload())
+		o.mu.Unlock()
+	}).WaitTimeout(time.Second)
+
+	t.Cleanup(func() { o.client.Disconnect(0) })
+
+	return o
+}
+
+func (o *observer) wait(t *testing.T, topic string, ok func(string) bool) {
+	t.Helper()
+
+	var last string
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		o.mu.Lock()
+		last = o.topics[topic]
+		o.mu.Unlock()
+
+		if ok(last) {
+			return
+		}
+	}
+
+	t.Fatalf("%s is %q", topic, last)
+}
+
+func equals(want string) func(string) bool {
+	return func(got string) bool { return got == want }
+}
+
+func TestMQTT(t *testing.T) {
+	s := spotifytest.Start(t)
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 208000})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 292000})
+	s.AddContext("spotify:playlist:test", "spotify:track:one", "spotify:track:two")
+
+	free, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := free.Addr().String()
+	free.Close()
+
+	stopBroker := startBroker(t, addr)
+
+	t.Setenv("AETHER_MQTT_BROKER", "tcp://"+addr)
+	utils.LoadConfig()
+
+	var state spotify.SpotifyPlayer
+	s.Listen(t, &state)
+
+	mqtt.Init(&state)
+	o := observe(t, addr)
+
+	o.wait(t, "aether/availability", equals("online"))
+	o.wait(t, "homeassistant/media_player/aether/config", func(config string) bool {
+		return strings.Contains(config, `"command_playmedia_topic":"aether/cmd/play"`)
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		o.client.Publish("aether/cmd/play", 1, false, "spotify:playlist:test")
+		o.wait(t, "aether/player/title", equals("Highway to Hell"))
+		o.wait(t, "aether/player/state", equals("playing"))
+
+		o.client.Publish("aether/cmd/pause", 1, false, "")
+		o.wait(t, "aether/player/state", equals("paused"))
+
+		o.client.Publish("aether/cmd/volume", 1, false, "0.5")
+		spotifytest.Eventually(t, "the volume to change", func() bool { return s.State().Volume == 32768 })
+		o.wait(t, "aether/player/volume", equals("0.500"))
+
+		o.client.Publish("aether/cmd/skip", 1, false, "")
+		o.wait(t, "aether/state", func(state string) bool {
+			return strings.Contains(state, `"title":"Thunderstruck"`) && strings.Contains(state, `"artist":"AC/DC"`)
+		})
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		// A new broker has none of the retained messages, aether has to send them again
+		stopBroker()
+		startBroker(t, addr)
+
+		o := observe(t, addr)
+		o.wait(t, "aether/availability", equals("online"))
+		o.wait(t, "aether/player/title", equals("Thunderstruck"))
+
+		o.client.Publish("aether/cmd/play", 1, false, "")
+		spotifytest.Eventually(t, "playback to resume", func() bool { return !s.State().Paused })
+	})
+
+	mqtt.Close()
+}
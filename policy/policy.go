@@ -0,0 +1,306 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+type Profile = utils.PolicyProfile
+
+/*
+What a track is judged on, from Spotify metadata or the local library.
+*/
+type Track struct {
+	URI      string   `json:"uri"`
+	Name     string   `json:"name"`
+	Artists  []string `json:"artists"`
+	Album    string   `json:"album"`
+	Genre    string   `json:"genre"`
+	Duration int      `json:"duration"` // ms
+	Explicit bool     `json:"explicit"`
+}
+
+/*
+Why a track may not be played.
+*/
+type Rejection struct {
+	URI string `json:"uri"`
+	// Empty when the track was rejected by the request queue instead of a profile
+	Profile string `json:"profile,omitempty"`
+	// explicit, artist, track, uri, duration, genre or keyword, or queued and recent from the request queue
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+func (r *Rejection) Error() string {
+	return r.Reason
+}
+
+// After this many tracks in a row are skipped playback is paused, the context has nothing that may be played
+const maxSkips = 20
+
+var mu sync.Mutex
+
+// The profile set on the admin page, it applies instead of the config until it is cleared
+var override string
+
+/*
+Skip tracks that are not allowed as they start playing, like tracks Spotify picks itself when a playlist ends.
+*/
+func Init() {
+	events, _ := live.Subscribe()
+
+	go func() {
+		var uri string
+		skips := 0
+
+		for event := range events {
+			data, _ := event.Data.(map[string]interface{})
+
+			switch event.Type {
+			case "trackChanged":
+				uri = fmt.Sprint(data["uri"])
+			case "metadataAvailable":
+				var track spotify.Track
+
+				raw, _ := json.Marshal(data["track"])
+				if json.Unmarshal(raw, &track) != nil || uri == "" {
+					continue
+				}
+
+				err := Check(FromSpotify(uri, track))
+				uri = ""
+
+				if err == nil {
+					skips = 0
+					continue
+				}
+
+				Log(fmt.Sprintf("Skipping %s: %s", track.Name, err))
+				live.Publish("trackBlocked", err)
+
+				if skips++; skips >= maxSkips {
+					Log(fmt.Sprintf("Skipped %d tracks in a row, pausing", skips))
+					skips = 0
+					spotify.Pause()
+					continue
+				}
+
+				if ok, err := spotify.Next(); !ok {
+					logger.Err("[Policy] Could not skip "+track.Name, err)
+				}
+			}
+		}
+	}()
+}
+
+/*
+Returns the name and rules of the profile that applies right now, ok is false when nothing is filtered.
+*/
+func Active() (string, Profile, bool) {
+	mu.Lock()
+	name := override
+	mu.Unlock()
+
+	cfg := utils.Cfg().Policy
+
+	if name == "" {
+		name = cfg.Profile
+		now := time.Now()
+
+		for _, w := range cfg.Schedule {
+			if utils.InWindow(w.From, w.To, now) {
+				name = w.Profile
+			}
+		}
+	}
+
+	profile, ok := cfg.Profiles[name]
+
+	return name, profile, ok
+}
+
+/*
+Returns the profile set on the admin page, empty when the config decides.
+*/
+func Override() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return override
+}
+
+/*
+Apply a profile instead of the config until it is cleared with an empty name.
+*/
+func SetOverride(name string) error {
+	// The profiles come from viper, which lowercases map keys
+	name = strings.ToLower(name)
+
+	if _, ok := utils.Cfg().Policy.Profiles[name]; name != "" && !ok {
+		return fmt.Errorf("there is no profile %q", name)
+	}
+
+	mu.Lock()
+	override = name
+	mu.Unlock()
+
+	if name == "" {
+		Log("Following the schedule again")
+	} else {
+		Log("Applying profile " + name)
+	}
+
+	return nil
+}
+
+/*
+Returns a *Rejection when t may not be played right now.
+*/
+func Check(t Track) error {
+	name, p, ok := Active()
+
+	if !ok {
+		return nil
+	}
+
+	reject := func(rule string, format string, args ...any) error {
+		return &Rejection{URI: t.URI, Profile: name, Rule: rule, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if slices.Contains(p.URIs, t.URI) {
+		return reject("uri", "%s is not allowed", t.URI)
+	}
+
+	if p.BlockExplicit && t.Explicit {
+		return reject("explicit", "Explicit tracks are not allowed right now")
+	}
+
+	for _, artist := range t.Artists {
+		if containsFold(p.Artists, artist) {
+			return reject("artist", "Tracks by %s are not allowed", artist)
+		}
+	}
+
+	if containsFold(p.Tracks, t.Name) {
+		return reject("track", "%s is not allowed", t.Name)
+	}
+
+	if max := p.MaxDuration.Duration; max > 0 && time.Duration(t.Duration)*time.Millisecond > max {
+		return reject("duration", "%s is %s long, the limit is %s", t.Name, clock(t.Duration), clock(int(max.Milliseconds())))
+	}
+
+	for _, genre := range p.Genres {
+		if t.Genre != "" && strings.Contains(strings.ToLower(t.Genre), strings.ToLower(genre)) {
+			return reject("genre", "%s is not allowed", t.Genre)
+		}
+	}
+
+	fields := strings.ToLower(strings.Join(append([]string{t.Name, t.Album}, t.Artists...), "\n"))
+
+	for _, keyword := range p.Keywords {
+		if keyword != "" && strings.Contains(fields, strings.ToLower(keyword)) {
+			return reject("keyword", "%s contains %q, which is not allowed", t.Name, keyword)
+		}
+	}
+
+	return nil
+}
+
+/*
+Check a Spotify or local uri, looking up its metadata when the active profile needs it.
+*/
+func CheckURI(uri string) error {
+	if _, p, ok := Active(); !ok || (!needsMetadata(p) && !slices.Contains(p.URIs, uri)) {
+		return nil
+	}
+
+	if strings.HasPrefix(uri, "local:") {
+		track, ok := library.Lookup(uri)
+
+		if !ok {
+			return fmt.Errorf("%s is not in the library", uri)
+		}
+
+		return Check(FromLibrary(*track))
+	}
+
+	// Episodes have no track metadata, only their uri can be blocked
+	if !strings.HasPrefix(uri, "spotify:track:") {
+		return Check(Track{URI: uri, Name: uri})
+	}
+
+	track, err := spotify.TrackMetadata(uri)
+
+	if err != nil {
+		return fmt.Errorf("could not check %s: %w", uri, err)
+	}
+
+	return Check(FromSpotify(uri, *track))
+}
+
+/*
+Returns the first local track that may not be played.
+*/
+func CheckLibrary(tracks []library.Track) error {
+	for _, track := range tracks {
+		if err := Check(FromLibrary(track)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func FromSpotify(uri string, track spotify.Track) Track {
+	t := Track{URI: uri, Name: track.Name, Album: track.Album.Name, Duration: track.Duration, Explicit: track.Explicit}
+
+	for _, artist := range track.Artist {
+		t.Artists = append(t.Artists, artist.Name)
+	}
+
+	return t
+}
+
+func FromLibrary(track library.Track) Track {
+	t := Track{URI: track.URI, Name: track.Title, Album: track.Album, Genre: track.Genre, Duration: track.Duration}
+
+	for _, artist := range []string{track.Artist, track.AlbumArtist} {
+		if artist != "" && !slices.Contains(t.Artists, artist) {
+			t.Artists = append(t.Artists, artist)
+		}
+	}
+
+	return t
+}
+
+func needsMetadata(p Profile) bool {
+	return p.BlockExplicit || p.MaxDuration.Duration > 0 || len(p.Artists) > 0 || len(p.Tracks) > 0 || len(p.Genres) > 0 || len(p.Keywords) > 0
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(item string) bool {
+		return strings.EqualFold(item, s)
+	})
+}
+
+/*
+Returns ms as m:ss.
+*/
+func clock(ms int) string {
+	return fmt.Sprintf("%d:%02d", ms/60000, ms/1000%60)
+}
+
+func Log(str string) {
+	logger.Verbose("[Policy] " + str)
+}
@@ -0,0 +1,171 @@
+package policy_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/policy"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+var state spotify.SpotifyPlayer
+
+func TestMain(m *testing.M) {
+	viper.Set("policy.profiles", map[string]any{
+		"openday": map[string]any{
+			"blockexplicit": true,
+			"maxduration":   "10m",
+			"artists":       []string{"Rammstein"},
+			"uris":          []string{"spotify:track:banned"},
+			"genres":        []string{"metal"},
+			"keywords":      []string{"remix"},
+		},
+		"party": map[string]any{"maxduration": "30m"},
+	})
+	viper.Set("policy.profile", "openday")
+	utils.LoadConfig()
+
+	queue.Init()
+	policy.Init()
+
+	os.Exit(m.Run())
+}
+
+func TestCheck(t *testing.T) {
+	for _, test := range []struct {
+		track policy.Track
+		rule  string
+	}{
+		{policy.Track{URI: "spotify:track:a", Name: "Highway to Hell", Artists: []string{"AC/DC"}, Duration: 208000}, ""},
+		{policy.Track{URI: "spotify:track:b", Name: "Thunderstruck", Artists: []string{"AC/DC"}, Explicit: true}, "explicit"},
+		{policy.Track{URI: "spotify:track:c", Name: "Du Hast", Artists: []string{"Till", "rammstein"}}, "artist"},
+		{policy.Track{URI: "spotify:track:banned", Name: "Africa"}, "uri"},
+		{policy.Track{URI: "spotify:track:d", Name: "Echoes", Duration: 23*60000 + 31000}, "duration"},
+		{policy.Track{URI: "local:track:e", Name: "Master of Puppets", Genre: "Thrash Metal"}, "genre"},
+		{policy.Track{URI: "spotify:track:f", Name: "Africa (Club Remix)"}, "keyword"},
+	} {
+		err := policy.Check(test.track)
+		var rejection *policy.Rejection
+
+		switch {
+		case test.rule == "" && err != nil:
+			t.Errorf("%s was rejected: %v", test.track.Name, err)
+		case test.rule != "" && !errors.As(err, &rejection):
+			t.Errorf("%s was not rejected", test.track.Name)
+		case test.rule != "" && (rejection.Rule != test.rule || rejection.Profile != "openday"):
+			t.Errorf("%s was rejected by %s of %s: %s", test.track.Name, rejection.Rule, rejection.Profile, rejection.Reason)
+		}
+	}
+
+	err := policy.Check(policy.Track{URI: "spotify:track:d", Name: "Echoes", Duration: 23*60000 + 31000})
+
+	if err.Error() != "Echoes is 23:31 long, the limit is 10:00" {
+		t.Errorf("reason %q", err)
+	}
+
+	if err := policy.CheckLibrary([]library.Track{{URI: "local:track:g", Title: "Enter Sandman", Artist: "Metallica", Genre: "Heavy Metal"}}); err == nil {
+		t.Error("a metal library track was not rejected")
+	}
+}
+
+func TestOverride(t *testing.T) {
+	t.Cleanup(func() { policy.SetOverride("") })
+
+	if err := policy.SetOverride("nope"); err == nil {
+		t.Error("set an unknown profile")
+	}
+
+	// Profile names are case insensitive, like every key viper reads
+	if err := policy.SetOverride("Party"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := policy.Check(policy.Track{Name: "Thunderstruck", Explicit: true}); err != nil {
+		t.Errorf("rejected at the party: %v", err)
+	}
+
+	policy.SetOverride("")
+
+	if name, _, _ := policy.Active(); name != "openday" {
+		t.Errorf("active profile %s after clearing the override", name)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour).Format("15:04"), time.Now().Add(time.Hour).Format("15:04")
+
+	viper.Set("policy.schedule", []map[string]any{{"from": from, "to": to, "profile": "party"}})
+	utils.LoadConfig()
+
+	t.Cleanup(func() {
+		viper.Set("policy.schedule", []any{})
+		utils.LoadConfig()
+	})
+
+	if name, _, _ := policy.Active(); name != "party" {
+		t.Errorf("active profile %s during the party", name)
+	}
+
+	// Single digit hours are compared as times, not as text
+	now := time.Now()
+	from, to = fmt.Sprintf("%d:00", now.Hour()/3), now.Add(time.Hour).Format("15:04")
+
+	viper.Set("policy.schedule", []map[string]any{{"from": from, "to": to, "profile": "party"}})
+	utils.LoadConfig()
+
+	if name, _, _ := policy.Active(); name != "party" {
+		t.Errorf("active profile %s during a party from %s to %s", name, from, to)
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	s := spotifytest.Start(t)
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 208000, Explicit: true})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 292000})
+	s.AddTrack("spotify:track:long", spotify.Track{Name: "Echoes", Artist: []spotify.Artist{{Name: "Pink Floyd"}}, Duration: 1411000})
+	s.AddContext("spotify:playlist:test", "spotify:track:one", "spotify:track:two")
+
+	s.Listen(t, &state)
+
+	var rejection *policy.Rejection
+
+	if _, err := queue.Add("spotify:track:long", "Jan"); !errors.As(err, &rejection) || rejection.Rule != "duration" {
+		t.Errorf("queued a track that is too long: %v", err)
+	}
+
+	if _, err := queue.Add("spotify:track:two", "Jan"); err != nil {
+		t.Errorf("could not queue an allowed track: %v", err)
+	}
+
+	events, unsubscribe := live.Subscribe()
+	defer unsubscribe()
+
+	// The explicit first track is skipped as soon as it starts
+	spotify.Load("spotify:playlist:test", true, false)
+
+	timeout := time.After(2 * time.Second)
+
+	for blocked := false; !blocked; {
+		select {
+		case e := <-events:
+			blocked = e.Type == "trackBlocked"
+		case <-timeout:
+			t.Fatal("the explicit track was not blocked")
+		}
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); state.Snapshot().URI != "spotify:track:two"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("playing %s instead of skipping to the next track", state.Snapshot().URI)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/history"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/policy"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+type Request struct {
+	ID          int       `json:"id"`
+	URI         string    `json:"uri"`
+	ISRC        string    `json:"isrc,omitempty"`
+	Requester   string    `json:"requester"`
+	RequestedAt time.Time `json:"requestedAt"`
+	// Who asked for the same track while it was queued
+	Merged []string `json:"merged,omitempty"`
+}
+
+/*
+Returns whether r is uri, or the same recording on another album or single.
+*/
+func (r Request) Same(uri string, isrc string) bool {
+	return r.URI == uri || (isrc != "" && r.ISRC == isrc)
+}
+
+// Requests are added one at a time, so the same track requested twice at once is still a duplicate
+var adding sync.Mutex
+
+var mu sync.Mutex
+var requests []Request
+var playing *Request
+var lastID int
+
+/*
+Start following the player, so requests leave the queue once they start playing.
+*/
+func Init() {
+	Log("Initializing request queue")
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		for event := range events {
+			if event.Type != "trackChanged" {
+				continue
+			}
+
+			if data, ok := event.Data.(map[string]interface{}); ok {
+				trackChanged(fmt.Sprint(data["uri"]))
+			}
+		}
+	}()
+}
+
+/*
+Queue a Spotify track on behalf of requester. Depending on queue.duplicates a track that is queued already is
+rejected or returned with requester added to it, and tracks played in the last queue.recent are rejected.
+*/
+func Add(uri string, requester string) (*Request, error) {
+	if !strings.HasPrefix(uri, "spotify:track:") && !strings.HasPrefix(uri, "spotify:episode:") {
+		return nil, errors.New("only spotify tracks and episodes can be requested")
+	}
+
+	adding.Lock()
+	defer adding.Unlock()
+
+	var isrc string
+
+	// The ISRC recognizes the same song on an album and a single, a request goes through without it
+	if strings.HasPrefix(uri, "spotify:track:") {
+		track, err := spotify.TrackMetadata(uri)
+
+		if err != nil {
+			logger.Err("[Queue] Could not look up "+uri, err)
+		} else {
+			isrc = track.ISRC()
+		}
+
+		if err == nil {
+			err = policy.Check(policy.FromSpotify(uri, *track))
+		} else {
+			err = policy.CheckURI(uri)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	} else if err := policy.CheckURI(uri); err != nil {
+		return nil, err
+	}
+
+	if err := recent(uri, isrc); err != nil {
+		return nil, err
+	}
+
+	if request, err := duplicate(uri, isrc, requester); request != nil || err != nil {
+		return request, err
+	}
+
+	if ok, err := spotify.AddToQueue(uri); !ok {
+		return nil, err
+	}
+
+	mu.Lock()
+	lastID++
+	request := Request{ID: lastID, URI: uri, ISRC: isrc, Requester: requester, RequestedAt: time.Now()}
+	requests = append(requests, request)
+	mu.Unlock()
+
+	Log(fmt.Sprintf("%s requested %s", requester, uri))
+	live.Publish("requestQueued", request)
+
+	return &request, nil
+}
+
+/*
+Reject a track that was played in the last queue.recent. Tracks that were skipped early do not count.
+*/
+func recent(uri string, isrc string) error {
+	window := utils.Cfg().Queue.Recent.Duration
+
+	if window == 0 {
+		return nil
+	}
+
+	for i, entry := range history.Since(time.Now().Add(-window)) {
+		if entry.URI != uri && (isrc == "" || entry.ISRC != isrc) || !entry.Listened() {
+			continue
+		}
+
+		ago := time.Since(entry.PlayedAt)
+
+		if i == 0 && ago < time.Duration(entry.Duration)*time.Millisecond {
+			return &policy.Rejection{URI: uri, Rule: "recent", Reason: entry.Name + " is playing right now"}
+		}
+
+		return &policy.Rejection{URI: uri, Rule: "recent", Reason: fmt.Sprintf("%s was played %s ago, try again in %s", entry.Name, minutes(ago), minutes(window-ago))}
+	}
+
+	return nil
+}
+
+/*
+Look for a queued request for the same track. With queue.duplicates on merge the requester joins it and it is
+returned, on reject the track is refused.
+*/
+func duplicate(uri string, isrc string, requester string) (*Request, error) {
+	mode := utils.Cfg().Queue.Duplicates
+
+	if mode == "allow" {
+		return nil, nil
+	}
+
+	mu.Lock()
+
+	for i := range requests {
+		if !requests[i].Same(uri, isrc) {
+			continue
+		}
+
+		position := i + 1
+
+		if mode == "reject" || requests[i].Requester == requester || slices.Contains(requests[i].Merged, requester) {
+			mu.Unlock()
+
+			return nil, &policy.Rejection{URI: uri, Rule: "queued", Reason: fmt.Sprintf("That track is already in the queue at #%d", position)}
+		}
+
+		requests[i].Merged = append(requests[i].Merged, requester)
+		request := requests[i]
+		request.Merged = slices.Clone(request.Merged)
+		mu.Unlock()
+
+		Log(fmt.Sprintf("%s also requested %s", requester, request.URI))
+		live.Publish("requestMerged", request)
+
+		return &request, nil
+	}
+
+	mu.Unlock()
+
+	return nil, nil
+}
+
+/*
+Returns d in whole minutes.
+*/
+func minutes(d time.Duration) string {
+	switch n := int(d.Round(time.Minute).Minutes()); {
+	case d < time.Minute:
+		return "less than a minute"
+	case n == 1:
+		return "1 minute"
+	default:
+		return fmt.Sprintf("%d minutes", n)
+	}
+}
+
+/*
+Remove a request from the queue by its id.
+*/
+func Remove(id int) error {
+	mu.Lock()
+
+	for i, request := range requests {
+		if request.ID != id {
+			continue
+		}
+
+		requests = append(requests[:i], requests[i+1:]...)
+		mu.Unlock()
+
+		if ok, err := spotify.RemoveFromQueue(request.URI); !ok {
+			return err
+		}
+
+		live.Publish("requestRemoved", request)
+
+		return nil
+	}
+
+	mu.Unlock()
+
+	return fmt.Errorf("request %d is not in the queue", id)
+}
+
+/*
+Returns the waiting requests in the order they will be played.
+*/
+func List() []Request {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]Request{}, requests...)
+}
+
+/*
+Returns the request that is playing right now, nil when the current track was not requested.
+*/
+func Playing() *Request {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if playing == nil {
+		return nil
+	}
+
+	request := *playing
+
+	return &request
+}
+
+/*
+Put back requests from an earlier session. When requeue is set they are added to the Spotify queue again,
+otherwise librespot is expected to still have them.
+*/
+func Restore(restored []Request, requeue bool) error {
+	var errs []error
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, request := range restored {
+		if requeue {
+			if ok, err := spotify.AddToQueue(request.URI); !ok {
+				errs = append(errs, fmt.Errorf("could not queue %s: %w", request.URI, err))
+				continue
+			}
+		}
+
+		requests = append(requests, request)
+		lastID = max(lastID, request.ID)
+		live.Publish("requestQueued", request)
+	}
+
+	Log(fmt.Sprintf("Restored %d requests", len(restored)-len(errs)))
+
+	return errors.Join(errs...)
+}
+
+/*
+Add the requests to the Spotify queue again after librespot lost it, like when its session was closed. With again
+the request that is playing goes in front, to be played again.
+*/
+func Requeue(again bool) error {
+	var errs []error
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if again && playing != nil {
+		requests = append([]Request{*playing}, requests...)
+		playing = nil
+	}
+
+	for _, request := range requests {
+		if ok, err := spotify.AddToQueue(request.URI); !ok {
+			errs = append(errs, fmt.Errorf("could not queue %s: %w", request.URI, err))
+		}
+	}
+
+	Log(fmt.Sprintf("Queued %d requests again", len(requests)-len(errs)))
+
+	return errors.Join(errs...)
+}
+
+func trackChanged(uri string) {
+	mu.Lock()
+
+	playing = nil
+
+	for i, request := range requests {
+		if request.URI == uri {
+			playing = &request
+			requests = append(requests[:i], requests[i+1:]...)
+			break
+		}
+	}
+
+	request := playing
+	mu.Unlock()
+
+	if request != nil {
+		Log(fmt.Sprintf("Now playing %s requested by %s", request.URI, request.Requester))
+		live.Publish("requestPlaying", *request)
+	}
+}
+
+func Log(str string) {
+	logger.Verbose("[Queue] " + str)
+}
@@ -0,0 +1,136 @@
+package queue_test
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/history"
+	"github.com/ODDInvictus/aether/policy"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+var state spotify.SpotifyPlayer
+
+func TestMain(m *testing.M) {
+	os.Setenv("AETHER_QUEUE_DUPLICATES", "merge")
+	os.Setenv("AETHER_QUEUE_RECENT", "30m")
+	utils.LoadConfig()
+
+	queue.Init()
+	history.Init()
+
+	os.Exit(m.Run())
+}
+
+/*
+Start a fake librespot with Thunderstruck on an album and a single, and empty the queue when the test ends.
+*/
+func fake(t *testing.T) *spotifytest.Server {
+	s := spotifytest.Start(t)
+
+	isrc := []spotify.ExternalID{{Type: "isrc", ID: "AUAP09000014"}}
+	s.AddTrack("spotify:track:album", spotify.Track{Name: "Thunderstruck", Duration: 292000, ExternalID: isrc})
+	s.AddTrack("spotify:track:single", spotify.Track{Name: "Thunderstruck", Duration: 292000, ExternalID: isrc})
+	s.AddTrack("spotify:track:other", spotify.Track{Name: "Highway to Hell", Duration: 208000})
+
+	s.Listen(t, &state)
+
+	t.Cleanup(func() {
+		for _, request := range queue.List() {
+			queue.Remove(request.ID)
+		}
+	})
+
+	return s
+}
+
+func rule(err error) string {
+	var rejection *policy.Rejection
+
+	if errors.As(err, &rejection) {
+		return rejection.Rule
+	}
+
+	return ""
+}
+
+func TestDuplicates(t *testing.T) {
+	s := fake(t)
+
+	// Thunderstruck may have been played by TestRecent
+	viper.Set("queue.recent", "0")
+	utils.LoadConfig()
+
+	t.Cleanup(func() {
+		viper.Set("queue.recent", "30m")
+		viper.Set("queue.duplicates", "merge")
+		utils.LoadConfig()
+	})
+
+	first, err := queue.Add("spotify:track:album", "Jan")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The single is the same recording, Piet joins the request of Jan
+	merged, err := queue.Add("spotify:track:single", "Piet")
+
+	if err != nil || merged.ID != first.ID || !slices.Equal(merged.Merged, []string{"Piet"}) {
+		t.Errorf("merged into %+v, %v", merged, err)
+	}
+
+	if _, err := queue.Add("spotify:track:album", "Jan"); rule(err) != "queued" {
+		t.Errorf("Jan requested the same track twice: %v", err)
+	}
+
+	viper.Set("queue.duplicates", "reject")
+	utils.LoadConfig()
+
+	if _, err := queue.Add("spotify:track:single", "Klaas"); rule(err) != "queued" {
+		t.Errorf("a duplicate was not rejected: %v", err)
+	}
+
+	if len(queue.List()) != 1 || len(s.State().Queue) != 1 {
+		t.Errorf("queued %+v, librespot has %v", queue.List(), s.State().Queue)
+	}
+}
+
+func TestRecent(t *testing.T) {
+	fake(t)
+
+	spotify.Load("spotify:track:album", true, false)
+
+	for deadline := time.Now().Add(2 * time.Second); len(history.List(1)) == 0 || history.List(1)[0].URI != "spotify:track:album"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the track was not recorded in the history")
+		}
+	}
+
+	if _, err := queue.Add("spotify:track:single", "Jan"); rule(err) != "recent" || err.Error() != "Thunderstruck is playing right now" {
+		t.Errorf("requested the playing track: %v", err)
+	}
+
+	if _, err := queue.Add("spotify:track:other", "Jan"); err != nil {
+		t.Errorf("could not request a track that was not played: %v", err)
+	}
+	// Thunderstruck is skipped right away, so it was not really heard
+	spotify.Load("spotify:track:other", true, false)
+
+	for deadline := time.Now().Add(2 * time.Second); history.List(1)[0].URI != "spotify:track:other"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the next track was not recorded in the history")
+		}
+	}
+
+	if _, err := queue.Add("spotify:track:single", "Jan"); err != nil {
+		t.Errorf("could not request a track that was skipped: %v", err)
+	}
+}
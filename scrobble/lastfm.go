@@ -0,0 +1,129 @@
+package scrobble
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+Scrobbles to Last.fm, see https://www.last.fm/api/scrobbling
+*/
+type lastFM struct {
+	cfg utils.LastFMConfig
+}
+
+// Last.fm errors that go away by themselves: operation failed, service offline, temporarily unavailable and
+// rate limit exceeded. Everything else is a problem with the account or the request.
+var lastFMTemporary = map[int]bool{8: true, 11: true, 16: true, 29: true}
+
+func (l *lastFM) Name() string {
+	return "lastfm"
+}
+
+func (l *lastFM) NowPlaying(listen Listen) error {
+	params := url.Values{"method": {"track.updateNowPlaying"}}
+	addListen(params, listen, "")
+
+	return l.call(params)
+}
+
+func (l *lastFM) Scrobble(listens []Listen) error {
+	params := url.Values{"method": {"track.scrobble"}}
+
+	for i, listen := range listens {
+		suffix := "[" + strconv.Itoa(i) + "]"
+
+		addListen(params, listen, suffix)
+		params.Set("timestamp"+suffix, strconv.FormatInt(listen.StartedAt.Unix(), 10))
+	}
+
+	return l.call(params)
+}
+
+func addListen(params url.Values, listen Listen, suffix string) {
+	// Last.fm knows tracks by their main artist
+	if len(listen.Artists) > 0 {
+		params.Set("artist"+suffix, listen.Artists[0])
+	}
+
+	params.Set("track"+suffix, listen.Track)
+	params.Set("duration"+suffix, strconv.Itoa(listen.Duration/1000))
+
+	if listen.Album != "" {
+		params.Set("album"+suffix, listen.Album)
+	}
+}
+
+/*
+Sign and POST a method call.
+*/
+func (l *lastFM) call(params url.Values) error {
+	params.Set("api_key", l.cfg.Key)
+	params.Set("sk", l.cfg.Session)
+	params.Set("api_sig", Sign(params, l.cfg.Secret))
+	params.Set("format", "json")
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.PostForm(l.cfg.URL, params)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	var result struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	switch {
+	case result.Error != 0 && !lastFMTemporary[result.Error]:
+		return rejected{fmt.Errorf("error %d: %s", result.Error, result.Message)}
+	case result.Error != 0:
+		return fmt.Errorf("error %d: %s", result.Error, result.Message)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+/*
+Returns the api_sig of a Last.fm call: the md5 of all parameters sorted by name, followed by the secret.
+*/
+func Sign(params url.Values, secret string) string {
+	names := make([]string, 0, len(params))
+
+	for name := range params {
+		if name != "format" && name != "callback" && name != "api_sig" {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	var b strings.Builder
+
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteString(params.Get(name))
+	}
+
+	b.WriteString(secret)
+	sum := md5.Sum([]byte(b.String()))
+
+	return hex.EncodeToString(sum[:])
+}
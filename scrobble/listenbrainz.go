@@ -0,0 +1,119 @@
+package scrobble
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+Submits listens to ListenBrainz, see https://listenbrainz.readthedocs.io/en/latest/users/api/core.html
+*/
+type listenBrainz struct {
+	cfg utils.ListenBrainzConfig
+}
+
+type listenBrainzListen struct {
+	ListenedAt int64 `json:"listened_at,omitempty"`
+	Metadata   struct {
+		Artist string         `json:"artist_name"`
+		Track  string         `json:"track_name"`
+		Album  string         `json:"release_name,omitempty"`
+		Info   map[string]any `json:"additional_info"`
+	} `json:"track_metadata"`
+}
+
+func (l *listenBrainz) Name() string {
+	return "listenbrainz"
+}
+
+func (l *listenBrainz) NowPlaying(listen Listen) error {
+	return l.submit("playing_now", []Listen{listen})
+}
+
+func (l *listenBrainz) Scrobble(listens []Listen) error {
+	if len(listens) == 1 {
+		return l.submit("single", listens)
+	}
+
+	return l.submit("import", listens)
+}
+
+func (l *listenBrainz) submit(listenType string, listens []Listen) error {
+	payload := make([]listenBrainzListen, len(listens))
+
+	for i, listen := range listens {
+		if listenType != "playing_now" {
+			payload[i].ListenedAt = listen.StartedAt.Unix()
+		}
+
+		payload[i].Metadata.Artist = strings.Join(listen.Artists, ", ")
+		payload[i].Metadata.Track = listen.Track
+		payload[i].Metadata.Album = listen.Album
+		payload[i].Metadata.Info = map[string]any{
+			"duration_ms":       listen.Duration,
+			"media_player":      "aether",
+			"submission_client": "aether",
+		}
+
+		if listen.ISRC != "" {
+			payload[i].Metadata.Info["isrc"] = listen.ISRC
+		}
+
+		if id, ok := strings.CutPrefix(listen.URI, "spotify:track:"); ok {
+			payload[i].Metadata.Info["music_service"] = "spotify.com"
+			payload[i].Metadata.Info["spotify_id"] = "https://open.spotify.com/track/" + id
+			payload[i].Metadata.Info["origin_url"] = "https://open.spotify.com/track/" + id
+		}
+	}
+
+	body, err := json.Marshal(map[string]any{"listen_type": listenType, "payload": payload})
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(l.cfg.URL, "/")+"/1/submit-listens", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Token "+l.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+
+	var result struct {
+		Error string `json:"error"`
+	}
+
+	raw, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(raw, &result)
+
+	err = fmt.Errorf("responded with %s: %s", resp.Status, result.Error)
+
+	// Bad requests and tokens stay bad, rate limits and server trouble pass
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return rejected{err}
+	}
+
+	return err
+}
@@ -0,0 +1,251 @@
+package scrobble
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+A track that was listened to.
+*/
+type Listen struct {
+	URI       string    `json:"uri"`
+	Track     string    `json:"track"`
+	Artists   []string  `json:"artists"`
+	Album     string    `json:"album"`
+	Duration  int       `json:"duration"` // ms
+	ISRC      string    `json:"isrc,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+/*
+A site that keeps the listening history, like Last.fm.
+*/
+type Service interface {
+	Name() string
+	NowPlaying(l Listen) error
+	// Submit finished listens, at most 50 at a time
+	Scrobble(listens []Listen) error
+}
+
+/*
+An error that will not go away by trying again, the listens are dropped instead of retried.
+*/
+type rejected struct {
+	error
+}
+
+// Tracks shorter than this are never scrobbled
+const minDuration = 30 * time.Second
+
+// A track counts once it was played for half its duration or this long, whichever comes first
+const maxListen = 4 * time.Minute
+
+/*
+Returns the services that are configured right now.
+*/
+func services() []Service {
+	cfg := utils.Cfg().Scrobble
+	var list []Service
+
+	if cfg.LastFM.Key != "" && cfg.LastFM.Secret != "" && cfg.LastFM.Session != "" {
+		list = append(list, &lastFM{cfg: cfg.LastFM})
+	}
+
+	if cfg.ListenBrainz.Token != "" {
+		list = append(list, &listenBrainz{cfg: cfg.ListenBrainz})
+	}
+
+	return list
+}
+
+func service(name string) (Service, bool) {
+	for _, s := range services() {
+		if s.Name() == name {
+			return s, true
+		}
+	}
+
+	return nil, false
+}
+
+/*
+Pick up the scrobbles left by the last run and start following what is played.
+*/
+func Init() {
+	Log("Initializing scrobbler")
+
+	load()
+
+	events, _ := live.Subscribe()
+
+	go follow(events)
+	go send()
+}
+
+/*
+The track that is playing and how much of it was heard. Listening is counted in stretches from a resume to the
+next pause, seek or end.
+*/
+type playing struct {
+	listen    Listen
+	known     bool // the metadata arrived
+	scrobbled bool
+
+	played  time.Duration // before the current stretch
+	running bool
+	since   time.Time // start of the current stretch
+	from    int64     // position at the start of the current stretch, ms
+}
+
+/*
+End the current stretch. The position is only known when pausing, seeks and endings count the time that passed.
+*/
+func (p *playing) stop(at time.Time, pos int64) {
+	if !p.running {
+		return
+	}
+
+	heard := at.Sub(p.since)
+
+	if pos >= p.from {
+		heard = time.Duration(pos-p.from) * time.Millisecond
+	}
+
+	p.played += max(heard, 0)
+	p.running = false
+}
+
+func (p *playing) start(at time.Time, pos int64) {
+	p.running = true
+	p.since = at
+	p.from = pos
+}
+
+func (p *playing) heard(now time.Time) time.Duration {
+	if p.running {
+		return p.played + now.Sub(p.since)
+	}
+
+	return p.played
+}
+
+func (p *playing) needed() time.Duration {
+	return min(time.Duration(p.listen.Duration)*time.Millisecond/2, maxListen)
+}
+
+/*
+Follow the player events and scrobble every track that was heard long enough.
+*/
+func follow(events <-chan live.Event) {
+	var current *playing
+
+	// Fires when the current track will have been heard long enough, if it keeps playing
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			current = update(current, event)
+		case <-timer.C:
+		}
+
+		if current == nil || current.scrobbled || !current.known {
+			continue
+		}
+
+		if time.Duration(current.listen.Duration)*time.Millisecond < minDuration {
+			continue
+		}
+
+		now := time.Now()
+
+		if left := current.needed() - current.heard(now); left <= 0 {
+			current.scrobbled = true
+			enqueue(current.listen)
+		} else if current.running {
+			timer.Reset(left)
+		}
+	}
+}
+
+func update(current *playing, event live.Event) *playing {
+	data, _ := event.Data.(map[string]interface{})
+	pos, hasPos := data["trackTime"].(float64)
+
+	if !hasPos {
+		pos = -1
+	}
+
+	if event.Type == "trackChanged" {
+		// librespot starts playing a new track from the start
+		current = &playing{listen: Listen{URI: fmt.Sprint(data["uri"]), StartedAt: event.Time}}
+		current.start(event.Time, 0)
+
+		return current
+	}
+
+	if current == nil {
+		return nil
+	}
+
+	switch event.Type {
+	case "metadataAvailable":
+		var track spotify.Track
+
+		raw, _ := json.Marshal(data["track"])
+
+		if current.known || json.Unmarshal(raw, &track) != nil {
+			break
+		}
+
+		current.known = true
+		current.listen.Track = track.Name
+		current.listen.Album = track.Album.Name
+		current.listen.Duration = track.Duration
+		current.listen.ISRC = track.ISRC()
+
+		for _, artist := range track.Artist {
+			current.listen.Artists = append(current.listen.Artists, artist.Name)
+		}
+
+		if current.running {
+			nowPlaying(current.listen)
+		}
+	case "playbackPaused":
+		current.stop(event.Time, int64(pos))
+	case "playbackResumed":
+		current.stop(event.Time, -1)
+		current.start(event.Time, int64(pos))
+	case "trackSeeked":
+		if current.running {
+			current.stop(event.Time, -1)
+			current.start(event.Time, int64(pos))
+		}
+	case "playbackEnded", "playbackFailed":
+		current.stop(event.Time, -1)
+	}
+
+	return current
+}
+
+func nowPlaying(l Listen) {
+	for _, s := range services() {
+		go func(s Service) {
+			if err := s.NowPlaying(l); err != nil {
+				logger.Err("[Scrobble] Could not send now playing to "+s.Name(), err)
+			}
+		}(s)
+	}
+}
+
+func Log(str string) {
+	logger.Verbose("[Scrobble] " + str)
+}
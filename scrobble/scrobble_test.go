@@ -0,0 +1,212 @@
+package scrobble_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/scrobble"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+var file string
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "scrobble")
+	file = filepath.Join(dir, "scrobbles.json")
+
+	os.Setenv("AETHER_SCROBBLE_FILE", file)
+	os.Setenv("AETHER_SCROBBLE_BACKOFF", "10ms")
+	utils.LoadConfig()
+
+	scrobble.Init()
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+/*
+Remembers the calls a mock service received.
+*/
+type calls struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (c *calls) add(call string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.list = append(c.list, call)
+
+	return len(c.list)
+}
+
+func (c *calls) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.list...)
+}
+
+func (c *calls) count(prefix string) int {
+	n := 0
+
+	for _, call := range c.get() {
+		if strings.HasPrefix(call, prefix) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func track(name string, duration int) map[string]any {
+	return map[string]any{
+		"track": map[string]any{
+			"name":     name,
+			"duration": duration,
+			"artist":   []map[string]any{{"name": "AC/DC"}},
+			"album":    map[string]any{"name": "Highway to Hell"},
+		},
+	}
+}
+
+func TestScrobble(t *testing.T) {
+	var lastfm, listenbrainz calls
+
+	// Calls are recorded as "method artist - track"
+	lastfmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		if sig := r.PostForm.Get("api_sig"); sig != scrobble.Sign(r.PostForm, "secret") {
+			t.Errorf("api_sig %s does not match", sig)
+		}
+
+		artist, track := r.PostForm.Get("artist"), r.PostForm.Get("track")
+
+		if r.PostForm.Get("method") == "track.scrobble" {
+			artist, track = r.PostForm.Get("artist[0]"), r.PostForm.Get("track[0]")
+		}
+
+		lastfm.add(r.PostForm.Get("method") + " " + artist + " - " + track)
+		w.Write([]byte(`{}`))
+	}))
+	defer lastfmServer.Close()
+
+	// ListenBrainz is down for the first scrobble
+	listenbrainzServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Type    string `json:"listen_type"`
+			Payload []struct {
+				ListenedAt int64 `json:"listened_at"`
+				Metadata   struct {
+					Artist string `json:"artist_name"`
+					Track  string `json:"track_name"`
+				} `json:"track_metadata"`
+			} `json:"payload"`
+		}
+
+		raw, _ := io.ReadAll(r.Body)
+		json.Unmarshal(raw, &body)
+
+		if r.Header.Get("Authorization") != "Token token" || len(body.Payload) != 1 {
+			t.Errorf("submitted %s with %q", raw, r.Header.Get("Authorization"))
+		}
+
+		n := listenbrainz.add(body.Type + " " + body.Payload[0].Metadata.Artist + " - " + body.Payload[0].Metadata.Track)
+
+		if body.Type == "single" && listenbrainz.count("single") == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if body.Type == "single" && body.Payload[0].ListenedAt == 0 {
+			t.Errorf("call %d has no listened_at", n)
+		}
+	}))
+	defer listenbrainzServer.Close()
+
+	viper.Set("scrobble.lastfm", map[string]any{"key": "key", "secret": "secret", "session": "session", "url": lastfmServer.URL})
+	viper.Set("scrobble.listenbrainz", map[string]any{"token": "token", "url": listenbrainzServer.URL})
+	utils.LoadConfig()
+
+	t.Cleanup(func() {
+		viper.Set("scrobble.lastfm", map[string]any{})
+		viper.Set("scrobble.listenbrainz", map[string]any{})
+		utils.LoadConfig()
+	})
+
+	// Heard for 150 of 200 seconds
+	live.Publish("trackChanged", map[string]any{"uri": "spotify:track:one"})
+	live.Publish("metadataAvailable", track("Highway to Hell", 200000))
+	live.Publish("playbackResumed", map[string]any{"trackTime": 0.0})
+	live.Publish("playbackPaused", map[string]any{"trackTime": 150000.0})
+
+	spotifytest.Eventually(t, "the scrobbles", func() bool {
+		return lastfm.count("track.scrobble") == 1 && listenbrainz.count("single") == 2
+	})
+
+	// Now playing is sent on the side, it may arrive after the scrobble
+	spotifytest.Eventually(t, "now playing", func() bool {
+		return lastfm.count("track.updateNowPlaying AC/DC - Highway to Hell") == 1 &&
+			listenbrainz.count("playing_now AC/DC - Highway to Hell") == 1
+	})
+
+	if got := lastfm.get(); !slices.Contains(got, "track.scrobble AC/DC - Highway to Hell") {
+		t.Errorf("Last.fm got %v", got)
+	}
+
+	// Skipping to the end is not listening
+	live.Publish("trackChanged", map[string]any{"uri": "spotify:track:two"})
+	live.Publish("metadataAvailable", track("Touch Too Much", 200000))
+	live.Publish("trackSeeked", map[string]any{"trackTime": 190000.0})
+	live.Publish("playbackPaused", map[string]any{"trackTime": 195000.0})
+
+	// Too short to scrobble at all
+	live.Publish("trackChanged", map[string]any{"uri": "spotify:track:three"})
+	live.Publish("metadataAvailable", track("Intro", 20000))
+	live.Publish("playbackPaused", map[string]any{"trackTime": 20000.0})
+
+	live.Publish("trackChanged", map[string]any{"uri": "spotify:track:four"})
+	live.Publish("metadataAvailable", track("Shot Down in Flames", 200000))
+
+	spotifytest.Eventually(t, "now playing for the last track", func() bool {
+		return lastfm.count("track.updateNowPlaying AC/DC - Shot Down in Flames") == 1
+	})
+
+	if n := lastfm.count("track.scrobble"); n != 1 {
+		t.Errorf("scrobbled %d tracks to Last.fm: %v", n, lastfm.get())
+	}
+
+	if len(scrobble.Backlog()) != 0 {
+		t.Errorf("scrobbles still pending: %+v", scrobble.Backlog())
+	}
+
+	// The sender saves right after submitting
+	spotifytest.Eventually(t, "the backlog to be saved", func() bool {
+		saved, _ := os.ReadFile(file)
+		return strings.TrimSpace(string(saved)) == "[]"
+	})
+}
+
+func TestSign(t *testing.T) {
+	params := url.Values{"method": {"track.love"}, "api_key": {"key"}, "format": {"json"}}
+
+	// md5("api_keykeymethodtrack.lovesecret")
+	if sig := scrobble.Sign(params, "secret"); sig != "c29b6f5da8bf45803aa5038b168adae6" {
+		t.Errorf("signature %s", sig)
+	}
+}
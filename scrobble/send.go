@@ -0,0 +1,121 @@
+package scrobble
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+A listen on its way to a single service. Scrobbles stay in scrobble.file until the service accepted them, so
+nothing is lost while the internet or the service is down.
+*/
+type Pending struct {
+	utils.Retry
+	Service string `json:"service"`
+	Listen  Listen `json:"listen"`
+}
+
+// Services take at most this many scrobbles in one request
+const batchSize = 50
+
+var pending = utils.NewRetryQueue("the pending scrobbles", func() string { return utils.Cfg().Scrobble.File },
+	func(p *Pending) *utils.Retry { return &p.Retry })
+
+/*
+Returns the scrobbles that were not accepted yet.
+*/
+func Backlog() []Pending {
+	return pending.List()
+}
+
+func load() {
+	if err := pending.Load(); err != nil {
+		logger.Err("Could not read the pending scrobbles", err)
+	}
+
+	if n := len(pending.List()); n > 0 {
+		Log(fmt.Sprintf("Resuming %d pending scrobbles", n))
+	}
+}
+
+/*
+Queue l for every configured service.
+*/
+func enqueue(l Listen) {
+	list := services()
+
+	if len(list) == 0 {
+		return
+	}
+
+	Log("Scrobbling " + l.Track)
+
+	scrobbles := make([]Pending, len(list))
+
+	for i, s := range list {
+		scrobbles[i] = Pending{Retry: utils.Retry{NextAt: time.Now()}, Service: s.Name(), Listen: l}
+	}
+
+	pending.Add(scrobbles...)
+}
+
+/*
+Submit everything that is due, in batches per service, then sleep until the next retry or a new scrobble.
+*/
+func send() {
+	pending.Run(func(due []Pending) {
+		batches := map[string][]Pending{}
+
+		for _, p := range due {
+			if len(batches[p.Service]) < batchSize {
+				batches[p.Service] = append(batches[p.Service], p)
+			}
+		}
+
+		for name, batch := range batches {
+			submit(name, batch)
+		}
+	})
+}
+
+/*
+Submit a batch to a service and either forget it or schedule the next try.
+*/
+func submit(name string, batch []Pending) {
+	listens := make([]Listen, len(batch))
+
+	for i, p := range batch {
+		listens[i] = p.Listen
+	}
+
+	var err error
+
+	if s, ok := service(name); ok {
+		err = s.Scrobble(listens)
+	} else {
+		err = rejected{errors.New(name + " is no longer configured")}
+	}
+
+	var r rejected
+
+	switch {
+	case err == nil:
+		Log(fmt.Sprintf("Scrobbled %d tracks to %s", len(batch), name))
+	case errors.As(err, &r):
+		logger.Warn(fmt.Sprintf("[Scrobble] %s rejected %d tracks: %s", name, len(batch), err))
+	default:
+		logger.Err("[Scrobble] Could not scrobble to "+name+", trying again later", err)
+	}
+
+	for _, p := range batch {
+		if err == nil || errors.As(err, &r) {
+			pending.Done(p.ID)
+		} else {
+			pending.Retry(p.ID, utils.Cfg().Scrobble.Backoff.Duration)
+		}
+	}
+}
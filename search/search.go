@@ -0,0 +1,302 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/library"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+Search Spotify and the local library at the same time. types limits the kinds of results (all kinds when empty),
+results are ranked, deduplicated by ISRC and then paginated with offset and limit.
+*/
+func Search(query string, types []string, offset int, limit int) *Page {
+	page := &Page{Query: query, Offset: offset, Limit: limit, Results: []Result{}}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var results []Result
+
+	collect := func(source string, search func() ([]Result, error)) {
+		defer wg.Done()
+
+		found, err := search()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			logger.Err("Search in "+source+" failed", err)
+
+			if page.Errors == nil {
+				page.Errors = map[string]string{}
+			}
+
+			page.Errors[source] = fmt.Sprint(err)
+		}
+
+		results = append(results, found...)
+	}
+
+	wg.Add(2)
+	go collect(SourceSpotify, func() ([]Result, error) { return searchSpotify(query) })
+	go collect(SourceLocal, func() ([]Result, error) { return searchLocal(query), nil })
+	wg.Wait()
+
+	results = filter(results, types)
+	rank(query, results)
+	results = dedupe(results)
+
+	page.Total = len(results)
+
+	if offset < len(results) {
+		results = results[offset:]
+
+		if len(results) > limit {
+			results = results[:limit]
+		}
+
+		page.Results = results
+	}
+
+	return page
+}
+
+func searchSpotify(query string) ([]Result, error) {
+	res, err := spotify.Search(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+
+	for _, hit := range res.Results.Tracks.Hits {
+		results = append(results, Result{
+			Type:       TypeTrack,
+			Source:     SourceSpotify,
+			URI:        hit.URI,
+			Name:       hit.Name,
+			Artists:    refNames(hit.Artists),
+			Album:      hit.Album.Name,
+			Image:      hit.Image,
+			Duration:   hit.Duration,
+			popularity: hit.Popularity,
+		})
+	}
+
+	for _, hit := range res.Results.Albums.Hits {
+		results = append(results, Result{
+			Type:    TypeAlbum,
+			Source:  SourceSpotify,
+			URI:     hit.URI,
+			Name:    hit.Name,
+			Artists: refNames(hit.Artists),
+			Image:   hit.Image,
+		})
+	}
+
+	for _, hit := range res.Results.Artists.Hits {
+		results = append(results, Result{
+			Type:   TypeArtist,
+			Source: SourceSpotify,
+			URI:    hit.URI,
+			Name:   hit.Name,
+			Image:  hit.Image,
+		})
+	}
+
+	for _, hit := range res.Results.Playlists.Hits {
+		results = append(results, Result{
+			Type:   TypePlaylist,
+			Source: SourceSpotify,
+			URI:    hit.URI,
+			Name:   hit.Name,
+			Image:  hit.Image,
+			Owner:  hit.Author,
+		})
+	}
+
+	lookupISRCs(results)
+
+	return results, nil
+}
+
+/*
+Search hits do not contain an ISRC, so look up the metadata of the first few tracks to be able to deduplicate them.
+*/
+func lookupISRCs(results []Result) {
+	var wg sync.WaitGroup
+	lookups := utils.Cfg().Search.ISRCLookups
+
+	for i := range results {
+		if lookups == 0 {
+			break
+		}
+
+		if results[i].Type != TypeTrack {
+			continue
+		}
+
+		lookups--
+		wg.Add(1)
+
+		go func(r *Result) {
+			defer wg.Done()
+
+			if track, err := spotify.TrackMetadata(r.URI); err == nil {
+				r.ISRC = track.ISRC()
+			}
+		}(&results[i])
+	}
+
+	wg.Wait()
+}
+
+func searchLocal(query string) []Result {
+	res := library.Search(query, utils.Cfg().Search.LocalLimit)
+
+	var results []Result
+
+	for _, hit := range res.Results.Tracks.Hits {
+		r := Result{
+			Type:     TypeTrack,
+			Source:   SourceLocal,
+			URI:      hit.URI,
+			Name:     hit.Name,
+			Album:    hit.Album.Name,
+			Duration: hit.Duration,
+		}
+
+		for _, artist := range hit.Artists {
+			r.Artists = append(r.Artists, artist.Name)
+		}
+
+		if track, ok := library.Lookup(hit.URI); ok {
+			r.ISRC = track.ISRC
+		}
+
+		results = append(results, r)
+	}
+
+	for _, hit := range res.Results.Albums.Hits {
+		r := Result{Type: TypeAlbum, Source: SourceLocal, URI: hit.URI, Name: hit.Name}
+
+		for _, artist := range hit.Artists {
+			r.Artists = append(r.Artists, artist.Name)
+		}
+
+		results = append(results, r)
+	}
+
+	for _, hit := range res.Results.Artists.Hits {
+		results = append(results, Result{Type: TypeArtist, Source: SourceLocal, URI: hit.URI, Name: hit.Name})
+	}
+
+	return results
+}
+
+func filter(results []Result, types []string) []Result {
+	if len(types) == 0 {
+		return results
+	}
+
+	var kept []Result
+
+	for _, r := range results {
+		for _, t := range types {
+			if r.Type == t {
+				kept = append(kept, r)
+				break
+			}
+		}
+	}
+
+	return kept
+}
+
+/*
+Score every result on how well its name and artists match the query, Spotify popularity is used to break ties.
+*/
+func rank(query string, results []Result) {
+	words := strings.Fields(strings.ToLower(query))
+
+	for i := range results {
+		r := &results[i]
+		name := strings.ToLower(r.Name)
+		artists := strings.ToLower(strings.Join(r.Artists, " "))
+
+		if name == strings.Join(words, " ") {
+			r.Score += 5
+		}
+
+		for _, word := range words {
+			switch {
+			case strings.HasPrefix(name, word):
+				r.Score += 2
+			case strings.Contains(name, word):
+				r.Score += 1
+			case strings.Contains(artists, word):
+				r.Score += 1
+			}
+		}
+
+		// Depending on the librespot version popularity is either a fraction or a percentage
+		if r.popularity > 1 {
+			r.Score += r.popularity / 100
+		} else {
+			r.Score += r.popularity
+		}
+
+		if r.Source == utils.Cfg().Search.Prefer {
+			r.Score += 0.5
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+}
+
+/*
+Merge tracks with the same ISRC into the best ranked one, results must already be ranked.
+*/
+func dedupe(results []Result) []Result {
+	var kept []Result
+	seen := map[string]int{}
+
+	for _, r := range results {
+		if r.Type != TypeTrack || r.ISRC == "" {
+			kept = append(kept, r)
+			continue
+		}
+
+		isrc := strings.ToUpper(r.ISRC)
+
+		if i, ok := seen[isrc]; ok {
+			kept[i].Alternatives = append(kept[i].Alternatives, r.URI)
+			continue
+		}
+
+		seen[isrc] = len(kept)
+		kept = append(kept, r)
+	}
+
+	return kept
+}
+
+func refNames(refs []spotify.SearchRef) []string {
+	var names []string
+
+	for _, ref := range refs {
+		names = append(names, ref.Name)
+	}
+
+	return names
+}
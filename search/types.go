@@ -0,0 +1,38 @@
+package search
+
+const (
+	TypeTrack    = "track"
+	TypeAlbum    = "album"
+	TypeArtist   = "artist"
+	TypePlaylist = "playlist"
+
+	SourceSpotify = "spotify"
+	SourceLocal   = "local"
+)
+
+type Result struct {
+	Type     string   `json:"type"`
+	Source   string   `json:"source"`
+	URI      string   `json:"uri"`
+	Name     string   `json:"name"`
+	Artists  []string `json:"artists,omitempty"`
+	Album    string   `json:"album,omitempty"`
+	Image    string   `json:"image,omitempty"`
+	Duration int      `json:"duration,omitempty"` // ms
+	ISRC     string   `json:"isrc,omitempty"`
+	Owner    string   `json:"owner,omitempty"`
+	// URIs of the same recording from other sources or releases, merged by ISRC.
+	Alternatives []string `json:"alternatives,omitempty"`
+	Score        float64  `json:"score"`
+	popularity   float64
+}
+
+type Page struct {
+	Query   string   `json:"query"`
+	Results []Result `json:"results"`
+	Total   int      `json:"total"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+	// Sources that failed, the results of the other sources are still returned.
+	Errors map[string]string `json:"errors,omitempty"`
+}
@@ -0,0 +1,275 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/queue"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/volume"
+)
+
+/*
+Everything needed to pick up playback where it was, written to session.file every session.interval.
+*/
+type Checkpoint struct {
+	ContextURI string          `json:"contextUri"`
+	TrackURI   string          `json:"trackUri"`
+	TrackName  string          `json:"trackName"`
+	Position   int64           `json:"position"` // ms
+	Paused     bool            `json:"paused"`
+	Shuffle    bool            `json:"shuffle"`
+	Repeat     string          `json:"repeat"`
+	Volume     int             `json:"volume"`
+	HasVolume  bool            `json:"hasVolume"`
+	Playing    *queue.Request  `json:"playing"`
+	Queue      []queue.Request `json:"queue"`
+	SavedAt    time.Time       `json:"savedAt"`
+}
+
+// How often to skip forward looking for the checkpointed track after loading its context
+const maxSkips = 100
+
+var player *spotify.SpotifyPlayer
+
+var mu sync.Mutex
+var pending *Checkpoint
+
+/*
+Read the last checkpoint and resume it when session.resume says so, then keep writing checkpoints.
+*/
+func Init(state *spotify.SpotifyPlayer) {
+	player = state
+
+	var saved Checkpoint
+
+	if err := utils.ReadJSON(utils.Cfg().Session.File, &saved); err != nil {
+		logger.Err("Could not read the last session", err)
+	}
+
+	maxAge := utils.Cfg().Session.MaxAge.Duration
+
+	if saved.TrackURI != "" && (maxAge == 0 || time.Since(saved.SavedAt) < maxAge) {
+		Log(fmt.Sprintf("Found a session from %s playing %s", saved.SavedAt.Format(time.RFC1123), saved.TrackURI))
+
+		if utils.Cfg().Session.Resume != "never" {
+			pending = &saved
+		}
+
+		go reconcile(saved)
+	}
+
+	go func() {
+		for {
+			time.Sleep(utils.Cfg().Session.Interval.Duration)
+
+			if err := Save(); err != nil {
+				logger.Err("Could not save the session", err)
+			}
+		}
+	}()
+}
+
+/*
+Write a checkpoint of what is playing now. Nothing is written while nothing is loaded,
+so the last session stays available until something else plays.
+*/
+func Save() error {
+	c, ok := Current()
+
+	if !ok {
+		return nil
+	}
+
+	return utils.WriteJSON(utils.Cfg().Session.File, c)
+}
+
+/*
+Returns a checkpoint of what is playing now, ok is false when nothing is loaded.
+*/
+func Current() (Checkpoint, bool) {
+	s := player.Snapshot()
+
+	if s.URI == "" {
+		return Checkpoint{}, false
+	}
+
+	shuffle, repeat := spotify.Modes()
+
+	return Checkpoint{
+		ContextURI: s.ContextURI,
+		TrackURI:   s.URI,
+		TrackName:  s.Track.Name,
+		Position:   s.Position(),
+		Paused:     s.Paused,
+		Shuffle:    shuffle,
+		Repeat:     repeat,
+		Volume:     s.Volume,
+		HasVolume:  s.HasVolume,
+		Playing:    queue.Playing(),
+		Queue:      queue.List(),
+		SavedAt:    time.Now(),
+	}, true
+}
+
+/*
+Returns the session that can be resumed, nil when there is none.
+*/
+func Pending() *Checkpoint {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if pending == nil {
+		return nil
+	}
+
+	c := *pending
+
+	return &c
+}
+
+/*
+Forget the session that could be resumed.
+*/
+func Discard() {
+	mu.Lock()
+	pending = nil
+	mu.Unlock()
+}
+
+/*
+Load the pending session into Spotify: its context, the track and position in it, the modes, volume and requests.
+*/
+func Resume() error {
+	mu.Lock()
+	c := pending
+	pending = nil
+	mu.Unlock()
+
+	if c == nil {
+		return errors.New("there is no session to resume")
+	}
+
+	Log("Resuming the session from " + c.SavedAt.Format(time.RFC1123))
+
+	if err := load(*c, true); err != nil {
+		return err
+	}
+
+	live.Publish("sessionResumed", c)
+
+	return nil
+}
+
+/*
+Load a checkpoint into a new librespot session. The requests are still known to aether, they are only queued in
+librespot again.
+*/
+func Reload(c Checkpoint) error {
+	Log(fmt.Sprintf("Reloading %s at %s", c.TrackURI, c.SavedAt.Format(time.RFC1123)))
+
+	return load(c, false)
+}
+
+/*
+Load c into Spotify: its context, the track and position in it, the modes and volume. With restore the requests of
+c are put back, otherwise the ones aether has are queued in librespot again.
+*/
+func load(c Checkpoint, restore bool) error {
+	requeue := func(again bool) error {
+		if !restore {
+			return queue.Requeue(again)
+		}
+
+		if again {
+			return queue.Restore(append([]queue.Request{*c.Playing}, c.Queue...), true)
+		}
+
+		return queue.Restore(c.Queue, true)
+	}
+
+	if c.HasVolume {
+		spotify.SetVolume(volume.Clamp(c.Volume), 0)
+	}
+
+	if c.Repeat != "" {
+		spotify.Repeat(c.Repeat)
+	}
+
+	context := c.ContextURI
+
+	if context == "" {
+		context = c.TrackURI
+	}
+
+	if ok, err := spotify.Load(context, false, c.Shuffle); !ok {
+		return fmt.Errorf("could not load %s: %w", context, err)
+	}
+
+	if c.Playing != nil && c.Playing.URI == c.TrackURI {
+		// A request was playing, it is not part of the context so it goes in front of the queue again
+		if err := requeue(true); err != nil {
+			logger.Err("Could not restore all requests", err)
+		}
+
+		spotify.Next()
+	} else {
+		if !spotify.SkipTo(c.TrackURI, maxSkips) {
+			Log("Could not find " + c.TrackURI + " in " + context + ", playing it on its own")
+			spotify.Load(c.TrackURI, false, false)
+		}
+
+		if err := requeue(false); err != nil {
+			logger.Err("Could not restore all requests", err)
+		}
+	}
+
+	spotify.Seek(int(c.Position))
+
+	if !c.Paused {
+		spotify.Resume()
+	}
+
+	return nil
+}
+
+/*
+Decide what to do with the last session once librespot is reachable. When librespot kept playing while aether
+restarted only the requests are put back, otherwise the session is resumed if session.resume is always.
+*/
+func reconcile(saved Checkpoint) {
+	for i := 0; !utils.CheckHealth().Spotify; i++ {
+		if i == 12 {
+			Log("Spotify is not reachable, not resuming the last session")
+			return
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+
+	if current, err := spotify.Current(); err == nil && current.Current == saved.TrackURI {
+		Log("Spotify is still playing the last session, restoring the requests")
+		Discard()
+
+		if err := queue.Restore(saved.Queue, false); err != nil {
+			logger.Err("Could not restore all requests", err)
+		}
+
+		return
+	}
+
+	if utils.Cfg().Session.Resume == "always" {
+		if err := Resume(); err != nil {
+			logger.Err("Could not resume the last session", err)
+		}
+	}
+}
+
+func Log(str string) {
+	logger.Verbose("[Session] " + str)
+}
@@ -0,0 +1,222 @@
+package soundboard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/announce"
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/fsnotify/fsnotify"
+)
+
+type Clip struct {
+	Name     string  `json:"name"`
+	File     string  `json:"file"`
+	Cooldown float64 `json:"cooldown"` // seconds
+	path     string
+}
+
+// Returned by Trigger for a clip that is not on the soundboard
+var ErrNotFound = errors.New("clip does not exist")
+
+/*
+Returned by Trigger when a clip or user is still cooling down.
+*/
+type CooldownError struct {
+	Reason    string
+	Remaining time.Duration
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", e.Reason, e.Remaining.Round(time.Second))
+}
+
+var mu sync.Mutex
+var clips = map[string]*Clip{}
+var clipPlayed = map[string]time.Time{}
+var userPlayed = map[string]time.Time{}
+
+/*
+Index the soundboard directory and keep watching it for added or removed clips.
+*/
+func Init() {
+	dir := utils.Cfg().Soundboard.Dir
+	Log("Indexing soundboard clips in " + dir)
+
+	index()
+
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		logger.Err("Could not watch the soundboard directory", err)
+		return
+	}
+
+	if err := watcher.Add(dir); err != nil {
+		logger.Err("Could not watch the soundboard directory", err)
+		watcher.Close()
+		return
+	}
+
+	utils.OnConfigChange(func(old utils.Config, new utils.Config) {
+		if old.Soundboard.Dir == new.Soundboard.Dir {
+			return
+		}
+
+		Log("Soundboard directory changed to " + new.Soundboard.Dir)
+		watcher.Remove(old.Soundboard.Dir)
+
+		if err := watcher.Add(new.Soundboard.Dir); err != nil {
+			logger.Err("Could not watch the soundboard directory", err)
+		}
+
+		index()
+	})
+
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if event.Has(fsnotify.Create) || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+					index()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				logger.Err("Soundboard watcher failed", err)
+			}
+		}
+	}()
+}
+
+/*
+Returns all clips on the soundboard, sorted by name.
+*/
+func Clips() []Clip {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]Clip, 0, len(clips))
+
+	for _, clip := range clips {
+		c := *clip
+		c.Cooldown = cooldown(c.Name).Seconds()
+		list = append(list, c)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+/*
+Play a clip on behalf of user. Depending on soundboard.mode the clip is mixed over the music or ducks it like an announcement.
+The cooldowns only start once the clip is playing or queued.
+*/
+func Trigger(name string, user string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	clip, ok := clips[name]
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	now := time.Now()
+
+	// Read now and not while indexing, so changes to the cooldowns in the config apply right away
+	if remaining := clipPlayed[name].Add(cooldown(name)).Sub(now); remaining > 0 {
+		return &CooldownError{Reason: fmt.Sprintf("%s was played recently", name), Remaining: remaining}
+	}
+
+	if remaining := userPlayed[user].Add(utils.Cfg().Soundboard.UserCooldown.Duration).Sub(now); remaining > 0 {
+		return &CooldownError{Reason: fmt.Sprintf("%s played a clip recently", user), Remaining: remaining}
+	}
+
+	// Both only open the file and hand it off, so the lock is not held while the clip plays
+	if utils.Cfg().Soundboard.Mode == "duck" {
+		if !audio.Ready() {
+			return audio.ErrUnavailable
+		}
+
+		announce.EnqueuePath(clip.path, utils.Cfg().Soundboard.Priority, false)
+	} else if _, err := audio.Play(clip.path); err != nil {
+		return err
+	}
+
+	Log(fmt.Sprintf("%s triggered %s", user, name))
+
+	clipPlayed[name] = now
+	userPlayed[user] = now
+
+	return nil
+}
+
+func index() {
+	dir := utils.Cfg().Soundboard.Dir
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		logger.Err("Could not read the soundboard directory", err)
+		return
+	}
+
+	found := map[string]*Clip{}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !audio.Supported(entry.Name()) {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+
+		found[name] = &Clip{
+			Name: name,
+			File: entry.Name(),
+			path: filepath.Join(dir, entry.Name()),
+		}
+	}
+
+	mu.Lock()
+	clips = found
+	mu.Unlock()
+
+	Log(fmt.Sprintf("Indexed %d clips", len(found)))
+}
+
+/*
+Clips can get their own cooldown in the soundboard.cooldowns table, otherwise soundboard.cooldown is used.
+*/
+func cooldown(name string) time.Duration {
+	cfg := utils.Cfg().Soundboard
+
+	// Keys in the config are lowercased
+	if cooldown, ok := cfg.Cooldowns[strings.ToLower(name)]; ok {
+		return cooldown.Duration
+	}
+
+	return cfg.Cooldown.Duration
+}
+
+func Log(str string) {
+	logger.Verbose("[Soundboard] " + str)
+}
@@ -0,0 +1,77 @@
+package soundboard_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ODDInvictus/aether/audio"
+	"github.com/ODDInvictus/aether/soundboard"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "aether-soundboard")
+	os.WriteFile(filepath.Join(dir, "airhorn.wav"), nil, 0o644)
+
+	os.Setenv("AETHER_SOUNDBOARD_DIR", dir)
+	utils.LoadConfig()
+
+	// audio.Init is not called, there is no output to play on
+	soundboard.Init()
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestTrigger(t *testing.T) {
+	if err := soundboard.Trigger("foghorn", "Jan"); !errors.Is(err, soundboard.ErrNotFound) {
+		t.Errorf("triggering a missing clip returned %v", err)
+	}
+
+	if err := soundboard.Trigger("airhorn", "Jan"); !errors.Is(err, audio.ErrUnavailable) {
+		t.Fatalf("triggering without an output returned %v", err)
+	}
+
+	// The clip never played, so it is not cooling down
+	if err := soundboard.Trigger("airhorn", "Jan"); !errors.Is(err, audio.ErrUnavailable) {
+		t.Errorf("triggering again returned %v", err)
+	}
+}
+
+func TestCooldownReload(t *testing.T) {
+	cooldown := func() float64 {
+		for _, clip := range soundboard.Clips() {
+			if clip.Name == "airhorn" {
+				return clip.Cooldown
+			}
+		}
+
+		t.Fatal("airhorn is not on the soundboard")
+		return 0
+	}
+
+	defer func() {
+		viper.Set("soundboard.cooldown", "30s")
+		viper.Set("soundboard.cooldowns", map[string]any{})
+		utils.LoadConfig()
+	}()
+
+	viper.Set("soundboard.cooldown", "1m")
+	utils.LoadConfig()
+
+	if got := cooldown(); got != 60 {
+		t.Errorf("after changing soundboard.cooldown the cooldown is %vs", got)
+	}
+
+	viper.Set("soundboard.cooldowns", map[string]any{"airhorn": "5m"})
+	utils.LoadConfig()
+
+	if got := cooldown(); got != 300 {
+		t.Errorf("after changing soundboard.cooldowns the cooldown is %vs", got)
+	}
+}
@@ -0,0 +1,267 @@
+package spotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+A librespot-java instance. The package functions control Main, which follows the spotify section of the config,
+every zone has a Client of its own.
+*/
+type Client struct {
+	// The name of the zone, empty for Main
+	Zone string
+	// The librespot API and the host of its event websocket, Main reads them from the config
+	URL string
+	WS  string
+
+	// While skipping through a context, the track being skipped to and until when the tracks before it are kept quiet
+	quietMu    sync.Mutex
+	quietFor   string
+	quietUntil time.Time
+}
+
+// How long SkipTo keeps skipped tracks quiet at most, in case the track it skipped to is never announced
+const quietTimeout = 10 * time.Second
+
+var Main = &Client{}
+
+func NewClient(zone string, url string, ws string) *Client {
+	return &Client{Zone: zone, URL: url, WS: ws}
+}
+
+func (c *Client) url() string {
+	if c == Main {
+		return utils.Cfg().Spotify.URL
+	}
+
+	return c.URL
+}
+
+func (c *Client) ws() string {
+	if c == Main {
+		return utils.Cfg().Spotify.WS
+	}
+
+	return c.WS
+}
+
+/*
+Load a context or track, see Load.
+*/
+func (c *Client) Load(uri string, startPlaying bool, shuffle bool) (bool, error) {
+	url := fmt.Sprintf("/player/load?uri=%s&play=%t&shuffle=%t", neturl.QueryEscape(uri), startPlaying, shuffle)
+
+	c.log(url)
+
+	return c.emptyPost(url)
+}
+
+func (c *Client) PlayPause() (bool, error) {
+	return c.emptyPost("/player/play-pause")
+}
+
+func (c *Client) Pause() (bool, error) {
+	return c.emptyPost("/player/pause")
+}
+
+func (c *Client) Resume() (bool, error) {
+	return c.emptyPost("/player/resume")
+}
+
+func (c *Client) Next() (bool, error) {
+	return c.emptyPost("/player/next")
+}
+
+/*
+Skip forward until uri is the current track, at most max times. The trackChanged and metadataAvailable events of
+the tracks that are skipped past are not published, so they are not recorded as played.
+*/
+func (c *Client) SkipTo(uri string, max int) bool {
+	for skips := 0; ; skips++ {
+		current, err := c.Current()
+
+		if err != nil {
+			break
+		}
+
+		// The events of uri itself end the quiet, they may still be on their way
+		if current.Current == uri {
+			return true
+		}
+
+		if skips == max {
+			break
+		}
+
+		c.quiet(uri)
+
+		if ok, _ := c.Next(); !ok {
+			break
+		}
+	}
+
+	c.quiet("")
+
+	return false
+}
+
+func (c *Client) Prev() (bool, error) {
+	return c.emptyPost("/player/prev")
+}
+
+func (c *Client) Seek(pos int) (bool, error) {
+	return c.emptyPost("/player/seek?pos=" + fmt.Sprint(pos))
+}
+
+/*
+Set the volume or change it by step, see SetVolume.
+*/
+func (c *Client) SetVolume(volume int, step int) (bool, error) {
+	if (volume < 0 && step == 0) {
+		return false, errors.New("invalid parameters, volume is negative and step is not set")
+	}
+
+	if (volume < 0) {
+		return c.emptyPost("/player/set-volume?step=" + fmt.Sprint(step))
+	}
+
+	if (volume < 0 || volume > 65536) {
+		return false, errors.New("invalid parameters, volume should be between 0 and 65536")
+	}
+
+	return c.emptyPost("/player/set-volume?volume=" + strconv.Itoa(volume))
+}
+
+/*
+Gradually change the volume from one value to another over d.
+*/
+func (c *Client) FadeVolume(from int, to int, d time.Duration) {
+	const steps = 10
+
+	for i := 1; i <= steps; i++ {
+		c.SetVolume(from+(to-from)*i/steps, 0)
+		time.Sleep(d / steps)
+	}
+}
+
+func (c *Client) Current() (*PlaybackState, error) {
+	var state PlaybackState
+
+	_, err := c.postWithReturn("/player/current", &state)
+
+	return &state, err
+}
+
+func (c *Client) AddToQueue(uri string) (bool, error) {
+	return c.emptyPost("/player/addToQueue?uri=" + neturl.QueryEscape(uri))
+}
+
+func (c *Client) log(str string) {
+	if c.Zone != "" {
+		str = "[" + c.Zone + "] " + str
+	}
+
+	Log(str)
+}
+
+func (c *Client) fail(str string) {
+	if c.Zone != "" {
+		str = "[" + c.Zone + "] " + str
+	}
+
+	fail(str)
+}
+
+func (c *Client) emptyPost(url string) (bool, error) {
+	return c.call(http.MethodPost, url, nil)
+}
+
+func (c *Client) postWithReturn(url string, v any) (bool, error) {
+	return c.call(http.MethodPost, url, v)
+}
+
+func (c *Client) getWithReturn(url string, v any) (bool, error) {
+	return c.call(http.MethodGet, url, v)
+}
+
+/*
+Call librespot and decode the response into v when it is not nil. Anything but a 2xx is an error.
+Every call to Main ends up in the recording, when one is running.
+*/
+func (c *Client) call(method string, url string, v any) (bool, error) {
+	start := time.Now()
+	status, err := c.request(method, url, v)
+
+	r := Recorded{Time: start, Kind: "command", Method: method, URL: url, Status: status, Duration: time.Since(start).Milliseconds()}
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	if c == Main {
+		record(r)
+	}
+
+	return err == nil, err
+}
+
+/*
+Make a call to librespot, returns the status code when there was a response.
+*/
+func (c *Client) request(method string, url string, v any) (int, error) {
+	c.log("Calling " + url)
+
+	req, err := http.NewRequest(method, c.url() + url, bytes.NewBufferString(""))
+
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		c.fail(fmt.Sprintf("Call to %s failed", url))
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		c.fail(fmt.Sprintf("Call to %s failed", url))
+		return 0, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		c.fail(fmt.Sprintf("Call to %s failed with %s", url, resp.Status))
+		return resp.StatusCode, fmt.Errorf("librespot responded with %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+
+	if v != nil {
+		if resp.StatusCode == http.StatusNoContent {
+			return resp.StatusCode, errors.New("librespot has nothing to report")
+		}
+
+		if err := json.Unmarshal(respBody, v); err != nil {
+			c.fail(fmt.Sprintf("Unmarshal failed for %s", url))
+			return resp.StatusCode, err
+		}
+	}
+
+	c.log(fmt.Sprintf("Call to %s successful", url))
+
+	return resp.StatusCode, nil
+}
@@ -1,96 +1,63 @@
 package spotify
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
-	"os"
-	"os/signal"
 	"strconv"
 	"time"
 
-	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
 	"github.com/gorilla/websocket"
-	"github.com/spf13/viper"
 )
 
-func ListenToEvents(state *SpotifyPlayer) {
-	Log("Listening to player events")
+/*
+Follow the librespot event stream until ctx is cancelled, then close the websocket cleanly.
+Returns an error when the connection could not be made or broke down.
+*/
+func ListenToEvents(ctx context.Context, state *SpotifyPlayer) error {
+	return Main.ListenToEvents(ctx, state)
+}
 
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+/*
+Follow the event stream of the librespot instance of c, see ListenToEvents.
+*/
+func (c *Client) ListenToEvents(ctx context.Context, state *SpotifyPlayer) error {
+	c.log("Listening to player events")
 
-	u := url.URL{Scheme: "ws", Host: viper.GetString("spotify.ws"), Path: "/events"}
+	u := url.URL{Scheme: "ws", Host: c.ws(), Path: "/events"}
 
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
 
 	if err != nil {
-		logger.Err("failed to connect to websocket", err)
+		return fmt.Errorf("failed to connect to websocket: %w", err)
 	}
 
 	defer conn.Close()
 
 	done := make(chan struct{})
+	var readErr error
 
 	go func() {
 		defer close(done)
 		for {
 			_, message, err := conn.ReadMessage()
 			if err != nil {
-				panic("failed to read msg")
+				readErr = err
+				return
 			}
 
-			var res map[string]interface{}
-
-			err = json.Unmarshal(message, &res)
-
-			if err != nil {
-				continue
+			if c == Main {
+				record(Recorded{Kind: "event", Event: message})
 			}
 
-			Log(fmt.Sprint(res["event"]))
-
-			switch res["event"] {
-			case "contextChanged":
-				state.contextUri = fmt.Sprint(res["uri"])
-			case "trackChanged":
-				state.uri = fmt.Sprint(res["uri"])
-			case "playbackEnded":
-				state.paused = true
-				state.trackTime = 0
-			case "playbackPaused":
-				state.paused = true
-				state.trackTime = int64(res["trackTime"].(float64))
-			case "playbackResumed":
-				state.paused = false
-				state.trackTime = int64(res["trackTime"].(float64))
-			case "playbackFailed":
-				state.paused = true
-				state.trackTime = 0
-			case "trackSeeked":
-				state.trackTime = int64(res["trackTime"].(float64))
-			case "metadataAvailable":
-				track := Track{}
-
-				jsonString, _ := json.Marshal(res["track"])
-				json.Unmarshal(jsonString, &track)
-
-				state.metadata = track
-			case "playbackHaltStateChanged":
-				x, err := strconv.ParseBool(fmt.Sprint(res["halted"]))
-				
-				if err != nil {
-					state.paused = true
-				} else {
-					state.paused = x
-				}
-				
-				state.trackTime = int64(res["trackTime"].(float64))
-			case "panic":
-				Log("Spotify failed, restarting song")
-				PlayPause()
-				PlayPause()
-				// Load(viper.GetString("fallback.playlist"), true, true)
+			if event := c.handleEvent(state, message); event == "panic" {
+				c.log("Spotify failed, restarting song")
+				c.publish("failover", map[string]string{"reason": "panic", "action": "restartTrack"})
+				c.PlayPause()
+				c.PlayPause()
+				// Load(utils.Cfg().Fallback.Playlist, true, true)
 			}
 		}
 	}()
@@ -101,28 +68,145 @@ func ListenToEvents(state *SpotifyPlayer) {
 	for {
 		select {
 		case <-done:
-			return
+			return fmt.Errorf("websocket closed: %w", readErr)
 		case t := <-ticker.C:
 			err := conn.WriteMessage(websocket.TextMessage, []byte(t.String()))
 			if err != nil {
-				logger.Err("write:", err)
-				return
+				return fmt.Errorf("write: %w", err)
 			}
-		case <-interrupt:
-			Log("closing connection...")
+		case <-ctx.Done():
+			c.log("closing connection...")
 
 			// Cleanly close the connection by sending a close message and then
 			// waiting (with timeout) for the server to close the connection.
 			err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 			if err != nil {
-				logger.Err("write close:", err)
-				return
+				return fmt.Errorf("write close: %w", err)
 			}
 			select {
 			case <-done:
 			case <-time.After(time.Second):
 			}
-			return
+			return nil
+		}
+	}
+}
+
+/*
+Apply a single librespot event to state and publish it. Returns the name of the event, or an empty string
+when the message was not an event.
+*/
+func (c *Client) handleEvent(state *SpotifyPlayer, message []byte) string {
+	var res map[string]interface{}
+
+	if err := json.Unmarshal(message, &res); err != nil {
+		return ""
+	}
+
+	c.log(fmt.Sprint(res["event"]))
+
+	state.mu.Lock()
+
+	switch res["event"] {
+	case "contextChanged":
+		state.contextUri = fmt.Sprint(res["uri"])
+	case "trackChanged":
+		state.uri = fmt.Sprint(res["uri"])
+	case "playbackEnded":
+		state.paused = true
+		state.trackTime = 0
+	case "playbackPaused":
+		state.paused = true
+		state.trackTime = int64(res["trackTime"].(float64))
+	case "playbackResumed":
+		state.paused = false
+		state.trackTime = int64(res["trackTime"].(float64))
+	case "playbackFailed":
+		state.paused = true
+		state.trackTime = 0
+	case "trackSeeked":
+		state.trackTime = int64(res["trackTime"].(float64))
+	case "metadataAvailable":
+		track := Track{}
+
+		jsonString, _ := json.Marshal(res["track"])
+		json.Unmarshal(jsonString, &track)
+
+		state.metadata = track
+	case "playbackHaltStateChanged":
+		x, err := strconv.ParseBool(fmt.Sprint(res["halted"]))
+
+		if err != nil {
+			state.paused = true
+		} else {
+			state.paused = x
+		}
+
+		state.trackTime = int64(res["trackTime"].(float64))
+	case "volumeChanged":
+		if value, ok := res["value"].(float64); ok {
+			state.volume = int(value * 65536)
+			state.hasVolume = true
 		}
 	}
-}
\ No newline at end of file
+
+	state.updated = time.Now()
+	state.mu.Unlock()
+
+	if !c.skipped(fmt.Sprint(res["event"]), res) {
+		c.publish(fmt.Sprint(res["event"]), res)
+	}
+
+	return fmt.Sprint(res["event"])
+}
+
+/*
+Keep the tracks before the one SkipTo is skipping to quiet, until that track changes.
+*/
+func (c *Client) quiet(uri string) {
+	c.quietMu.Lock()
+	defer c.quietMu.Unlock()
+
+	c.quietFor = uri
+	c.quietUntil = time.Now().Add(quietTimeout)
+}
+
+/*
+Returns whether event belongs to a track that SkipTo skipped past.
+*/
+func (c *Client) skipped(event string, res map[string]interface{}) bool {
+	c.quietMu.Lock()
+	defer c.quietMu.Unlock()
+
+	if c.quietFor == "" || time.Now().After(c.quietUntil) {
+		c.quietFor = ""
+		return false
+	}
+
+	switch event {
+	case "trackChanged":
+		if fmt.Sprint(res["uri"]) == c.quietFor {
+			c.quietFor = ""
+			return false
+		}
+
+		return true
+	case "metadataAvailable":
+		return true
+	}
+
+	return false
+}
+
+/*
+Publish an event of Main as is. Events of other zones are wrapped in a zoneEvent, so everything that follows
+the main player can keep ignoring them.
+*/
+func (c *Client) publish(event string, data any) {
+	if c == Main {
+		live.Publish(event, data)
+		return
+	}
+
+	live.Publish("zoneEvent", map[string]interface{}{"zone": c.Zone, "event": event, "data": data})
+}
@@ -0,0 +1,169 @@
+package spotify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+A line in a recording, either an event from librespot or a command aether sent to it.
+*/
+type Recorded struct {
+	Time time.Time `json:"time"`
+	// event or command
+	Kind  string          `json:"kind"`
+	Event json.RawMessage `json:"event,omitempty"`
+
+	Method   string `json:"method,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration,omitempty"` // ms
+}
+
+var recordMu sync.Mutex
+var recording *utils.RotatingFile
+
+/*
+Start writing the events and commands to path, rotating it every maxSize bytes.
+A recording that is already running is stopped first.
+*/
+func StartRecording(path string, maxSize int64, keep int) error {
+	file, err := utils.OpenRotating(path, maxSize, keep)
+
+	if err != nil {
+		return err
+	}
+
+	StopRecording()
+
+	recordMu.Lock()
+	recording = file
+	recordMu.Unlock()
+
+	Log("Recording events to " + path)
+
+	return nil
+}
+
+func StopRecording() error {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	if recording == nil {
+		return nil
+	}
+
+	err := recording.Close()
+	recording = nil
+
+	return err
+}
+
+/*
+Follow record.* in the config, recording can be switched on and off while aether runs.
+*/
+func initRecording() {
+	apply := func(c utils.RecordConfig) {
+		if !c.Enabled {
+			StopRecording()
+			return
+		}
+
+		if err := StartRecording(c.File, int64(c.MaxSize)<<20, c.Keep); err != nil {
+			logger.Err("Could not start recording", err)
+		}
+	}
+
+	apply(utils.Cfg().Record)
+
+	utils.OnConfigChange(func(old utils.Config, new utils.Config) {
+		if old.Record != new.Record {
+			apply(new.Record)
+		}
+	})
+}
+
+func record(r Recorded) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	if recording == nil {
+		return
+	}
+
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	line, err := json.Marshal(r)
+
+	if err != nil {
+		fail(fmt.Sprintf("Could not record %s: %s", r.Kind, err))
+		return
+	}
+
+	if _, err := recording.Write(append(line, '\n')); err != nil {
+		fail(fmt.Sprintf("Could not record %s: %s", r.Kind, err))
+	}
+}
+
+/*
+Feed a recording through the event handling into state, with the original pauses between the lines divided by
+speed. A speed of 0 replays as fast as possible. Commands are not sent to librespot, they are only passed to fn
+together with the events and the state right after each line.
+*/
+func Replay(ctx context.Context, state *SpotifyPlayer, path string, speed float64, fn func(r Recorded, s PlayerState)) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	var last time.Time
+
+	for line := 1; scanner.Scan(); line++ {
+		var r Recorded
+
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		if speed > 0 && !last.IsZero() && r.Time.After(last) {
+			select {
+			case <-time.After(time.Duration(float64(r.Time.Sub(last)) / speed)):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		last = r.Time
+
+		if r.Kind == "event" {
+			Main.handleEvent(state, r.Event)
+		}
+
+		if fn != nil {
+			fn(r, state.Snapshot())
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
@@ -1,26 +1,29 @@
 package spotify
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"net/http"
+	neturl "net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/KokopelliMusic/go-lib/logger"
-	"github.com/spf13/viper"
+	"github.com/ODDInvictus/aether/utils"
 )
 
-var apiUrl string
+var modeMu sync.Mutex
+var shuffling bool
+var repeating = "none"
 
 func Init(startPlaying bool) {
 	logger.Log("Initializing spotify player")
-	apiUrl = viper.GetString("spotify.url")
+
+	initRecording()
 
 	if startPlaying {
-		Load(viper.GetString("playlist.fallback"), true, true)
+		Load(utils.Cfg().Fallback.Playlist, true, true)
 	}
 }
 
@@ -28,59 +31,75 @@ func Init(startPlaying bool) {
 Load a track from a given URI uri, can specify to start playing with play and to shuffle with shuffle.
 */
 func Load(uri string, startPlaying bool, shuffle bool) (bool, error) {
-	url := fmt.Sprintf("/player/load?uri=%s&play=%t&shuffle=%t", uri, startPlaying, shuffle)
+	ok, err := Main.Load(uri, startPlaying, shuffle)
+
+	if ok {
+		setModes(&shuffle, nil)
+	}
 
-	Log(url)
-	return emptyPost(url)
+	return ok, err
 }
 
 /*
 Toggle play/pause status. Useful when using a remote.
 */
 func PlayPause() (bool, error) {
-	return emptyPost("/player/play-pause")
+	return Main.PlayPause()
 }
 
 /*
 Pause playback.
 */
 func Pause() (bool, error) {
-	return emptyPost("/player/pause")
+	return Main.Pause()
 }
 
 /*
 Resume playback.
 */
 func Resume() (bool, error) {
-	return emptyPost("/player/resume")
+	return Main.Resume()
 }
 
 /*
 Skip to next track.
 */
 func Next() (bool, error) {
-	return emptyPost("/player/next")
+	return Main.Next()
+}
+
+/*
+Skip forward until uri is playing, at most max tracks. The tracks in between are not published as played.
+*/
+func SkipTo(uri string, max int) bool {
+	return Main.SkipTo(uri, max)
 }
 
 /*
 Skip to previous track.
 */
 func Prev() (bool, error) {
-	return emptyPost("/player/prev")
+	return Main.Prev()
 }
 
 /*
 Seek to a given position in ms specified by pos.
 */
 func Seek(pos int) (bool, error) {
-	return emptyPost("/player/seek?pos=" + fmt.Sprint(pos))
+	return Main.Seek(pos)
 }
 
 /*
 Set shuffle enabled or disabled accordingly to val.
 */
 func Shuffle(shuffle bool) (bool, error) {
-	return emptyPost("/player/shuffle?val=" + strconv.FormatBool(shuffle))
+	ok, err := Main.emptyPost("/player/shuffle?val=" + strconv.FormatBool(shuffle))
+
+	if ok {
+		setModes(&shuffle, nil)
+	}
+
+	return ok, err
 }
 
 /*
@@ -91,54 +110,74 @@ func Repeat(val string) (bool, error) {
 		return false, errors.New("invalid context mode, possible options: none, track, context")
 	}
 
-	return emptyPost("/player/repeat?val=" + val)
+	ok, err := Main.emptyPost("/player/repeat?val=" + val)
+
+	if ok {
+		setModes(nil, &val)
+	}
+
+	return ok, err
 }
 
 /*
-Either set volume to a given volume value (from 0 to 65536), or change it by a step count (positive or negative).
-
-Will use step if volume is negative
+Returns the shuffle and repeat modes that were last set through aether. librespot has no events for these,
+so changes made from a Spotify app are not known.
 */
-func SetVolume(volume int, step int) (bool, error) {
-	if (volume < 0 && step == 0) {
-		return false, errors.New("invalid parameters, volume is negative and step is not set")
-	}
+func Modes() (shuffle bool, repeat string) {
+	modeMu.Lock()
+	defer modeMu.Unlock()
+
+	return shuffling, repeating
+}
 
-	if (volume < 0) {
-		return emptyPost("/player/set-volume?step=" + fmt.Sprint(step))
+func setModes(shuffle *bool, repeat *string) {
+	modeMu.Lock()
+	defer modeMu.Unlock()
+
+	if shuffle != nil {
+		shuffling = *shuffle
 	}
 
-	if (volume < 0 || volume > 65536) {
-		return false, errors.New("invalid parameters, volume should be between 0 and 65536")
+	if repeat != nil {
+		repeating = *repeat
 	}
+}
+
+/*
+Either set volume to a given volume value (from 0 to 65536), or change it by a step count (positive or negative).
+
+Will use step if volume is negative
+*/
+func SetVolume(volume int, step int) (bool, error) {
+	return Main.SetVolume(volume, step)
+}
 
-	return emptyPost("/player/set-volume?volume=" + fmt.Sprint(volume))
+/*
+Gradually change the volume from one value to another over d.
+*/
+func FadeVolume(from int, to int, d time.Duration) {
+	Main.FadeVolume(from, to, d)
 }
 
 /*
 Up the volume a little bit.
 */
 func VolumeUp() (bool, error) {
-	return emptyPost("/player/volume-up")
+	return Main.emptyPost("/player/volume-up")
 }
 
 /*
 Lower the volume a little bit.
 */
 func VolumeDown() (bool, error) {
-	return emptyPost("/player/volume-down")
+	return Main.emptyPost("/player/volume-down")
 }
 
 /*
 Retrieve information about the current track (metadata and time).
 */
 func Current() (*PlaybackState, error) {
-	url := "/player/current"
-	var state PlaybackState
-
-	_, err := postWithReturn(url, &state)
-
-	return &state, err
+	return Main.Current()
 }
 
 /*
@@ -153,7 +192,7 @@ func Tracks(withQueue bool) (*TracksState, error) {
 
 	var state TracksState
 
-	_, err := postWithReturn(url, &state)
+	_, err := Main.postWithReturn(url, &state)
 
 	return &state, err
 }
@@ -162,14 +201,14 @@ func Tracks(withQueue bool) (*TracksState, error) {
 Add a track to the queue, specified by uri.
 */
 func AddToQueue(uri string) (bool, error) {
-	return emptyPost("/player/addToQueue?uri=" + uri)
+	return Main.AddToQueue(uri)
 }
 
 /*
 Remove a track from the queue, specified by uri.
 */
 func RemoveFromQueue(uri string) (bool, error) {
-	return emptyPost("/player/removeFromQueue?uri=" + uri)
+	return Main.emptyPost("/player/removeFromQueue?uri=" + neturl.QueryEscape(uri))
 }
 
 /*
@@ -186,15 +225,28 @@ func MetadataPerUri(uri string) (bool, error) {
 	return false, errors.New("metadata per uri is not implemented")
 }
 
+/*
+Retrieve the metadata of a single track, uri is the standard Spotify uri.
+*/
+func TrackMetadata(uri string) (*Track, error) {
+	url := "/metadata/track/" + uri
+
+	var track Track
+
+	_, err := Main.postWithReturn(url, &track)
+
+	return &track, err
+}
+
 /*
 Make a search.
 */
 func Search(query string) (*SearchResult, error) {
-	url := "/search/" + query
+	url := "/search/" + neturl.PathEscape(query)
 
 	var state SearchResult
 
-	_, err := postWithReturn(url, &state)
+	_, err := Main.postWithReturn(url, &state)
 
 	return &state, err
 }
@@ -228,7 +280,7 @@ func Instance() (*InstanceData, error) {
 
 	var state InstanceData
 
-	_, err := getWithReturn(url, &state)
+	_, err := Main.getWithReturn(url, &state)
 
 	return &state, err
 }
@@ -237,14 +289,14 @@ func Instance() (*InstanceData, error) {
 Terminates the API server.
 */
 func TerminateServer() (bool, error) {
-	return emptyPost("/instance/terminate")
+	return Main.emptyPost("/instance/terminate")
 }
 
 /*
 Closes the current session (and player).
 */
 func CloseSession() (bool, error) {
-	return emptyPost("/instance/close")
+	return Main.emptyPost("/instance/close")
 }
 
 /*
@@ -260,88 +312,46 @@ the request will be made to the API with the correct Authorization header and th
 The method, body, and content type headers will pass through. 
 Additionally, you can specify an X-Spotify-Scope header to override the requested scope, by default all will be requested.
 */
-func WebApiPassthrough() (bool, error) {
-	return false, errors.New("api passthrough is not implemented")
-}
-
-
-func Log(str string) {
-	logger.Verbose("[Spotify] " + str)
-}
-
-func fail(str string) {
-	logger.Warn("[Spotify] " + str)
-}
-
-func emptyPost(url string) (bool, error) {
-	Log("Calling " + url)
-
-	_, err := http.Post(apiUrl + url, "", bytes.NewBufferString(""))
-
-	if err != nil {
-		fail(fmt.Sprintf("Call to %s failed", url))
-		return false, err
-	}
-
-	Log(fmt.Sprintf("Call to %s successful", url))
-
-	return true, nil
+func WebApiPassthrough(method string, endpoint string, v any) (bool, error) {
+	return Main.call(method, "/web-api/"+strings.TrimPrefix(endpoint, "/"), v)
 }
 
-func postWithReturn(url string, v any) (bool, error) {
-	Log("Calling " + url)
-
-	resp, err := http.Post(apiUrl + url, "", bytes.NewBufferString(""))
-
-	if err != nil {
-		fail(fmt.Sprintf("Call to %s failed", url))
-		return false, err
-	}
-
-	respBody, err := io.ReadAll(resp.Body)
+/*
+Returns up to limit tracks like the seed tracks (at most 5 spotify:track: uris), through the Web API.
+*/
+func Recommendations(seeds []string, limit int) ([]WebTrack, error) {
+	ids := make([]string, len(seeds))
 
-	if err != nil {
-		fail(fmt.Sprintf("Call to %s failed", url))
-		return false, err
+	for i, seed := range seeds {
+		ids[i] = strings.TrimPrefix(seed, "spotify:track:")
 	}
 
-	err = json.Unmarshal(respBody, &v)
+	query := neturl.Values{"seed_tracks": {strings.Join(ids, ",")}, "limit": {strconv.Itoa(limit)}}
 
-	if err != nil {
-		fail("Unmarschal failed for Current()")
-		return false, err
+	var result struct {
+		Tracks []WebTrack `json:"tracks"`
 	}
 
-	Log(fmt.Sprintf("Call to %s successful", url))
+	_, err := WebApiPassthrough(http.MethodGet, "v1/recommendations?"+query.Encode(), &result)
 
-	return false, nil
+	return result.Tracks, err
 }
 
-func getWithReturn(url string, v any) (bool, error) {
-	Log("Calling " + url)
-
-	resp, err := http.Get(apiUrl + url)
-
-	if err != nil {
-		fail(fmt.Sprintf("Call to %s failed", url))
-		return false, err
-	}
-
-	respBody, err := io.ReadAll(resp.Body)
-
-	if err != nil {
-		fail(fmt.Sprintf("Call to %s failed", url))
-		return false, err
-	}
+/*
+Returns the account librespot is logged in with, through the Web API.
+*/
+func Me() (*WebUser, error) {
+	var user WebUser
 
-	err = json.Unmarshal(respBody, &v)
+	_, err := WebApiPassthrough(http.MethodGet, "v1/me", &user)
 
-	if err != nil {
-		fail("Unmarschal failed for Current()")
-		return false, err
-	}
+	return &user, err
+}
 
-	Log(fmt.Sprintf("Call to %s successful", url))
+func Log(str string) {
+	logger.Verbose("[Spotify] " + str)
+}
 
-	return false, nil
-}
\ No newline at end of file
+func fail(str string) {
+	logger.Warn("[Spotify] " + str)
+}
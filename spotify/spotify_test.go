@@ -0,0 +1,364 @@
+package spotify_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+)
+
+func fake(t *testing.T) *spotifytest.Server {
+	s := spotifytest.Start(t)
+
+	s.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 208000})
+	s.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Artist: []spotify.Artist{{Name: "AC/DC"}}, Duration: 292000})
+	s.AddTrack("spotify:track:three", spotify.Track{Name: "Africa", Artist: []spotify.Artist{{Name: "Toto"}}, Duration: 295000})
+	s.AddContext("spotify:playlist:test", "spotify:track:one", "spotify:track:two", "spotify:track:three")
+
+	return s
+}
+
+/*
+Start following the events of s, the listener is stopped when the test ends.
+*/
+func listen(t *testing.T, s *spotifytest.Server) (*spotify.SpotifyPlayer, <-chan error) {
+	var state spotify.SpotifyPlayer
+
+	return &state, s.Listen(t, &state)
+}
+
+func TestPlayerCommands(t *testing.T) {
+	s := fake(t)
+
+	if ok, err := spotify.Load("spotify:playlist:test", true, true); !ok {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	state := s.State()
+
+	if state.Track != "spotify:track:one" || state.Paused || !state.Shuffle {
+		t.Fatalf("after Load the state is %+v", state)
+	}
+
+	if shuffle, _ := spotify.Modes(); !shuffle {
+		t.Error("Modes does not report shuffle after loading with shuffle")
+	}
+
+	spotify.Next()
+	spotify.Pause()
+	spotify.Seek(30000)
+	spotify.Repeat("context")
+	spotify.AddToQueue("spotify:track:three")
+
+	state = s.State()
+
+	if state.Track != "spotify:track:two" || !state.Paused || state.Position != 30000 || state.Repeat != "context" {
+		t.Fatalf("after the commands the state is %+v", state)
+	}
+
+	if !slices.Equal(state.Queue, []string{"spotify:track:three"}) {
+		t.Fatalf("queue is %v", state.Queue)
+	}
+
+	current, err := spotify.Current()
+
+	if err != nil || current.Current != "spotify:track:two" || current.Track.Name != "Thunderstruck" {
+		t.Fatalf("Current returned %+v, %v", current, err)
+	}
+
+	tracks, err := spotify.Tracks(false)
+
+	if err != nil || tracks.Current.URI != "spotify:track:two" || len(tracks.Next) != 1 {
+		t.Fatalf("Tracks returned %+v, %v", tracks, err)
+	}
+
+	if _, err := spotify.Repeat("sometimes"); err == nil {
+		t.Error("Repeat accepted an invalid mode")
+	}
+}
+
+func TestEscapedURIs(t *testing.T) {
+	s := fake(t)
+
+	// Local files keep the spaces of their names as + and can contain an &
+	uri := "spotify:local:Simon+&+Garfunkel:Bookends:Mrs.+Robinson:244"
+	s.AddTrack(uri, spotify.Track{Name: "Mrs. Robinson"})
+
+	if ok, err := spotify.Load(uri, true, false); !ok {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	spotify.AddToQueue(uri)
+
+	if state := s.State(); state.Track != uri || !slices.Equal(state.Queue, []string{uri}) {
+		t.Fatalf("after loading and queueing %s the state is %+v", uri, state)
+	}
+
+	spotify.RemoveFromQueue(uri)
+
+	if state := s.State(); len(state.Queue) != 0 {
+		t.Fatalf("queue is %v after removing %s", state.Queue, uri)
+	}
+}
+
+func TestVolume(t *testing.T) {
+	s := fake(t)
+
+	spotify.SetVolume(32768, 0)
+	spotify.VolumeUp()
+	spotify.SetVolume(-1, -2)
+
+	if volume := s.State().Volume; volume != 32768-1024 {
+		t.Fatalf("volume is %d", volume)
+	}
+
+	if ok, _ := spotify.SetVolume(70000, 0); ok {
+		t.Error("SetVolume accepted a volume above 65536")
+	}
+}
+
+func TestNothingPlaying(t *testing.T) {
+	fake(t)
+
+	if _, err := spotify.Current(); err == nil {
+		t.Fatal("Current did not fail while nothing is loaded")
+	}
+}
+
+func TestFaults(t *testing.T) {
+	s := fake(t)
+
+	s.Fail("/player/pause", spotifytest.Fault{Status: http.StatusInternalServerError, Times: 1})
+
+	if ok, err := spotify.Pause(); ok || err == nil {
+		t.Fatalf("Pause succeeded during a 500: %v, %v", ok, err)
+	}
+
+	if ok, err := spotify.Pause(); !ok {
+		t.Fatalf("Pause failed after the fault: %v", err)
+	}
+
+	s.Fail("/player/next", spotifytest.Fault{Latency: 100 * time.Millisecond})
+	start := time.Now()
+	spotify.Next()
+
+	if took := time.Since(start); took < 100*time.Millisecond {
+		t.Errorf("Next took %s, expected the injected latency", took)
+	}
+
+	s.ClearFaults()
+
+	if _, err := spotify.Load("spotify:playlist:unknown", false, false); err == nil {
+		t.Error("loading an unknown context did not fail")
+	}
+}
+
+func TestMetadataAndSearch(t *testing.T) {
+	fake(t)
+
+	track, err := spotify.TrackMetadata("spotify:track:three")
+
+	if err != nil || track.Name != "Africa" {
+		t.Fatalf("TrackMetadata returned %+v, %v", track, err)
+	}
+
+	if _, err := spotify.TrackMetadata("spotify:track:missing"); err == nil {
+		t.Error("TrackMetadata found a track that does not exist")
+	}
+
+	result, err := spotify.Search("AC/DC")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hits := result.Results.Tracks.Hits; len(hits) != 2 {
+		t.Fatalf("searching AC/DC found %+v", hits)
+	}
+}
+
+func TestInstance(t *testing.T) {
+	s := fake(t)
+	s.SetInstance(spotify.InstanceData{DeviceName: "kitchen"})
+
+	instance, err := spotify.Instance()
+
+	if err != nil || instance.DeviceName != "kitchen" {
+		t.Fatalf("Instance returned %+v, %v", instance, err)
+	}
+}
+
+func TestListenToEvents(t *testing.T) {
+	s := fake(t)
+	state, _ := listen(t, s)
+
+	spotify.Load("spotify:playlist:test", true, false)
+
+	spotifytest.Eventually(t, "the track to load", func() bool {
+		snapshot := state.Snapshot()
+		return snapshot.URI == "spotify:track:one" && snapshot.ContextURI == "spotify:playlist:test" &&
+			snapshot.Track.Name == "Highway to Hell" && !snapshot.Paused
+	})
+
+	spotify.Pause()
+	spotify.SetVolume(16384, 0)
+
+	spotifytest.Eventually(t, "the pause and volume", func() bool {
+		volume, ok := state.Volume()
+		return state.Paused() && ok && volume == 16384
+	})
+}
+
+func TestDroppedSocket(t *testing.T) {
+	s := fake(t)
+	_, errs := listen(t, s)
+
+	s.DropSockets()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("a dropped socket was not reported")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ListenToEvents kept running after the socket dropped")
+	}
+}
+
+func TestStopListening(t *testing.T) {
+	s := fake(t)
+
+	var state spotify.SpotifyPlayer
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- spotify.ListenToEvents(ctx, &state)
+	}()
+
+	if !s.WaitForListener(time.Second) {
+		t.Fatal("ListenToEvents did not connect")
+	}
+
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("stopping returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ListenToEvents did not stop")
+	}
+}
+
+func TestRefusedSocket(t *testing.T) {
+	s := fake(t)
+	s.Fail("/events", spotifytest.Fault{Status: http.StatusServiceUnavailable})
+
+	var state spotify.SpotifyPlayer
+
+	if err := spotify.ListenToEvents(context.Background(), &state); err == nil {
+		t.Fatal("ListenToEvents connected to a refused socket")
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	s := fake(t)
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+
+	if err := spotify.StartRecording(path, 1<<20, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { spotify.StopRecording() })
+
+	live, _ := listen(t, s)
+
+	spotify.Load("spotify:playlist:test", true, false)
+	spotify.Next()
+	spotify.SetVolume(20000, 0)
+	spotify.Pause()
+
+	spotifytest.Eventually(t, "the events to arrive", func() bool {
+		volume, _ := live.Volume()
+		return live.Paused() && volume == 20000
+	})
+
+	spotify.StopRecording()
+
+	var replayed spotify.SpotifyPlayer
+	var commands []string
+
+	err := spotify.Replay(context.Background(), &replayed, path, 0, func(r spotify.Recorded, _ spotify.PlayerState) {
+		if r.Kind == "command" {
+			commands = append(commands, r.URL)
+		}
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(commands) != 4 {
+		t.Errorf("recorded commands %v", commands)
+	}
+
+	got, want := replayed.Snapshot(), live.Snapshot()
+
+	if got.URI != want.URI || got.ContextURI != want.ContextURI || got.Paused != want.Paused || got.Volume != want.Volume || got.Track.Name != want.Track.Name {
+		t.Fatalf("replaying ended in %+v, the live state was %+v", got, want)
+	}
+}
+
+func TestSkipTo(t *testing.T) {
+	s := fake(t)
+	state, _ := listen(t, s)
+
+	spotify.Load("spotify:playlist:test", false, false)
+
+	spotifytest.Eventually(t, "the track to load", func() bool {
+		return state.Snapshot().Track.Name == "Highway to Hell"
+	})
+
+	events, unsubscribe := live.Subscribe()
+	defer unsubscribe()
+
+	if spotify.SkipTo("spotify:track:three", 1) {
+		t.Fatal("SkipTo went past its maximum")
+	}
+
+	if !spotify.SkipTo("spotify:track:three", 5) {
+		t.Fatal("SkipTo did not find the track")
+	}
+
+	var changed []string
+
+	for timeout := time.After(time.Second); len(changed) == 0 || changed[len(changed)-1] != "spotify:track:three"; {
+		select {
+		case e := <-events:
+			switch e.Type {
+			case "trackChanged":
+				changed = append(changed, fmt.Sprint(e.Data.(map[string]interface{})["uri"]))
+			case "metadataAvailable":
+				if track := fmt.Sprint(e.Data.(map[string]interface{})["track"]); strings.Contains(track, "Thunderstruck") {
+					t.Errorf("metadata of a skipped track was published: %s", track)
+				}
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for the track, saw %v", changed)
+		}
+	}
+
+	if !slices.Equal(changed, []string{"spotify:track:three"}) {
+		t.Fatalf("published track changes %v", changed)
+	}
+}
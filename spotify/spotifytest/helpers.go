@@ -0,0 +1,52 @@
+package spotifytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/spotify"
+)
+
+/*
+Follow the events of the fake into state until the test ends, like aether follows librespot. Fails the test when
+ListenToEvents does not connect, the returned channel receives what it returned.
+*/
+func (s *Server) Listen(t testing.TB, state *spotify.SpotifyPlayer) <-chan error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		errs <- spotify.ListenToEvents(ctx, state)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	if !s.WaitForListener(time.Second) {
+		t.Fatal("ListenToEvents did not connect")
+	}
+
+	return errs
+}
+
+/*
+Wait for ok to become true, events arrive asynchronously. Fails the test after two seconds.
+*/
+func Eventually(t testing.TB, what string, ok func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if ok() {
+			return
+		}
+	}
+
+	t.Fatalf("timed out waiting for %s", what)
+}
@@ -0,0 +1,641 @@
+/*
+Package spotifytest runs a fake librespot-java in process, so the spotify package and everything built on it can be
+tested without Spotify. It keeps a small player model, sends the same events as librespot over /events and can be
+told to misbehave.
+*/
+package spotifytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/gorilla/websocket"
+)
+
+// librespot-java changes the volume in 64 steps
+const volumeStep = 65536 / 64
+
+/*
+Make requests to a path fail or slow down. Times limits how many requests are affected, 0 means all of them.
+*/
+type Fault struct {
+	Status  int
+	Latency time.Duration
+	Times   int
+}
+
+/*
+The state of the fake player, as returned by Server.State.
+*/
+type State struct {
+	Context  string
+	Track    string
+	Paused   bool
+	Position int // ms
+	Volume   int
+	Shuffle  bool
+	Repeat   string
+	Queue    []string
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	tracks   map[string]spotify.Track
+	contexts map[string][]string
+	search   *spotify.SearchResult
+	similar  []string
+	instance spotify.InstanceData
+	faults   map[string]*Fault
+	calls    []string
+	conns    map[*websocket.Conn]bool
+
+	context  string
+	order    []string
+	index    int
+	current  string
+	paused   bool
+	position int
+	since    time.Time
+	volume   int
+	shuffle  bool
+	repeat   string
+	queue    []string
+}
+
+var upgrader = websocket.Upgrader{}
+
+/*
+Start a fake librespot. Call Close when done, or use Start in tests.
+*/
+func NewServer() *Server {
+	s := &Server{
+		tracks:   map[string]spotify.Track{},
+		contexts: map[string][]string{},
+		faults:   map[string]*Fault{},
+		conns:    map[*websocket.Conn]bool{},
+		instance: spotify.InstanceData{
+			DeviceID:        "0123456789abcdef",
+			DeviceName:      "aether-test",
+			DeviceType:      "COMPUTER",
+			CountryCode:     "NL",
+			PreferredLocale: "en",
+		},
+		paused: true,
+		volume: 65536 / 2,
+		repeat: "none",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", s.events)
+	mux.HandleFunc("/player/", s.player)
+	mux.HandleFunc("/metadata/track/", s.metadata)
+	mux.HandleFunc("/search/", s.searchHandler)
+	mux.HandleFunc("/web-api/v1/recommendations", s.recommendations)
+	mux.HandleFunc("/web-api/v1/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, spotify.WebUser{ID: "aether", DisplayName: "Aether", Country: "NL", Product: "premium"})
+	})
+	mux.HandleFunc("/instance", s.instanceHandler)
+	mux.HandleFunc("/instance/", s.instanceHandler)
+	// aether checks the health of librespot by calling its root
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+		}
+	})
+
+	s.Server = httptest.NewServer(s.faulty(mux))
+
+	return s
+}
+
+/*
+Start a fake librespot for the duration of the test and point the config of aether at it.
+*/
+func Start(t testing.TB) *Server {
+	s := NewServer()
+	t.Cleanup(s.Close)
+
+	t.Setenv("AETHER_SPOTIFY_URL", s.URL)
+	t.Setenv("AETHER_SPOTIFY_WS", s.Listener.Addr().String())
+	utils.LoadConfig()
+
+	return s
+}
+
+/*
+Close the event sockets and stop the server.
+*/
+func (s *Server) Close() {
+	s.DropSockets()
+	s.Server.Close()
+}
+
+/*
+Add a track that can be loaded, queued and found. The URI and name of track are filled in when they are missing.
+*/
+func (s *Server) AddTrack(uri string, track spotify.Track) {
+	if track.Name == "" {
+		track.Name = uri
+	}
+
+	s.mu.Lock()
+	s.tracks[uri] = track
+	s.mu.Unlock()
+}
+
+/*
+Add a playlist or album that plays the given tracks in order.
+*/
+func (s *Server) AddContext(uri string, tracks ...string) {
+	s.mu.Lock()
+	s.contexts[uri] = tracks
+	s.mu.Unlock()
+}
+
+/*
+Answer every search with result, instead of searching the added tracks.
+*/
+func (s *Server) SetSearch(result spotify.SearchResult) {
+	s.mu.Lock()
+	s.search = &result
+	s.mu.Unlock()
+}
+
+/*
+Recommend the added tracks with these uris through the Web API, whatever the seeds are.
+*/
+func (s *Server) SetRecommendations(uris ...string) {
+	s.mu.Lock()
+	s.similar = uris
+	s.mu.Unlock()
+}
+
+func (s *Server) SetInstance(instance spotify.InstanceData) {
+	s.mu.Lock()
+	s.instance = instance
+	s.mu.Unlock()
+}
+
+/*
+Inject a fault for requests to path, like /player/pause or /events.
+*/
+func (s *Server) Fail(path string, fault Fault) {
+	s.mu.Lock()
+	s.faults[path] = &fault
+	s.mu.Unlock()
+}
+
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	s.faults = map[string]*Fault{}
+	s.mu.Unlock()
+}
+
+/*
+Close all event sockets without a close message, like a crashing librespot.
+*/
+func (s *Server) DropSockets() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.UnderlyingConn().Close()
+		delete(s.conns, conn)
+	}
+}
+
+/*
+Wait until at least one client follows the events.
+*/
+func (s *Server) WaitForListener(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+
+		if n > 0 {
+			return true
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return false
+}
+
+/*
+Send an event to all listeners, fields are added next to the event name.
+*/
+func (s *Server) Emit(event string, fields map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.emit(event, fields)
+}
+
+/*
+Returns every request made so far, like "POST /player/pause".
+*/
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.calls...)
+}
+
+func (s *Server) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return State{
+		Context:  s.context,
+		Track:    s.current,
+		Paused:   s.paused,
+		Position: s.trackTime(),
+		Volume:   s.volume,
+		Shuffle:  s.shuffle,
+		Repeat:   s.repeat,
+		Queue:    append([]string{}, s.queue...),
+	}
+}
+
+/*
+Record requests and apply the faults for their path.
+*/
+func (s *Server) faulty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.calls = append(s.calls, r.Method+" "+r.URL.RequestURI())
+
+		var fault Fault
+		if f, ok := s.faults[r.URL.Path]; ok {
+			fault = *f
+
+			if f.Times > 0 {
+				if f.Times--; f.Times == 0 {
+					delete(s.faults, r.URL.Path)
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		time.Sleep(fault.Latency)
+
+		if fault.Status != 0 {
+			http.Error(w, "injected fault", fault.Status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+
+	// aether sends keep alive messages, these are ignored
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *Server) player(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.TrimPrefix(r.URL.Path, "/player/") {
+	case "load":
+		uri := q.Get("uri")
+		order, ok := s.contexts[uri]
+
+		if _, track := s.tracks[uri]; track {
+			order, ok = []string{uri}, true
+		}
+
+		if !ok {
+			http.Error(w, "unknown context "+uri, http.StatusInternalServerError)
+			return
+		}
+
+		s.context, s.order, s.index = uri, order, 0
+		s.shuffle = q.Get("shuffle") == "true"
+		s.emit("contextChanged", map[string]any{"uri": uri})
+		s.play(order[0])
+
+		if q.Get("play") == "true" {
+			s.setPaused(false)
+		} else {
+			s.setPaused(true)
+		}
+	case "play-pause":
+		s.setPaused(!s.paused)
+	case "pause":
+		s.setPaused(true)
+	case "resume":
+		s.setPaused(false)
+	case "next":
+		s.next()
+	case "prev":
+		if s.index > 0 {
+			s.index--
+		}
+
+		if len(s.order) > 0 {
+			s.play(s.order[s.index])
+		}
+	case "seek":
+		pos, err := strconv.Atoi(q.Get("pos"))
+
+		if err != nil {
+			http.Error(w, "invalid pos", http.StatusBadRequest)
+			return
+		}
+
+		s.position, s.since = pos, time.Now()
+		s.emit("trackSeeked", map[string]any{"trackTime": pos})
+	case "shuffle":
+		s.shuffle = q.Get("val") == "true"
+	case "repeat":
+		s.repeat = q.Get("val")
+	case "set-volume":
+		if q.Has("volume") {
+			volume, err := strconv.Atoi(q.Get("volume"))
+
+			if err != nil {
+				http.Error(w, "invalid volume", http.StatusBadRequest)
+				return
+			}
+
+			s.setVolume(volume)
+		} else {
+			step, _ := strconv.Atoi(q.Get("step"))
+			s.setVolume(s.volume + step*volumeStep)
+		}
+	case "volume-up":
+		s.setVolume(s.volume + volumeStep)
+	case "volume-down":
+		s.setVolume(s.volume - volumeStep)
+	case "current":
+		if s.current == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, spotify.PlaybackState{Current: s.current, TrackTime: s.trackTime(), Track: s.tracks[s.current]})
+	case "tracks":
+		next := []map[string]any{}
+
+		if s.index+1 < len(s.order) {
+			for _, uri := range s.order[s.index+1:] {
+				next = append(next, map[string]any{"uri": uri})
+			}
+		}
+
+		writeJSON(w, map[string]any{"current": map[string]any{"uri": s.current}, "next": next, "prev": []any{}})
+	case "addToQueue":
+		s.queue = append(s.queue, q.Get("uri"))
+	case "removeFromQueue":
+		for i, uri := range s.queue {
+			if uri == q.Get("uri") {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				break
+			}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+}
+
+func (s *Server) metadata(w http.ResponseWriter, r *http.Request) {
+	uri := strings.TrimPrefix(r.URL.Path, "/metadata/track/")
+
+	s.mu.Lock()
+	track, ok := s.tracks[uri]
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "unknown track "+uri, http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, track)
+}
+
+/*
+Search the added tracks by name and artist, unless SetSearch gave a fixed result.
+*/
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/search/"))
+
+	if err != nil {
+		http.Error(w, "invalid query", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.search != nil {
+		writeJSON(w, s.search)
+		return
+	}
+
+	var result spotify.SearchResult
+
+	for uri, track := range s.tracks {
+		var artists []spotify.SearchRef
+		text := track.Name
+
+		for _, artist := range track.Artist {
+			artists = append(artists, spotify.SearchRef{Name: artist.Name})
+			text += " " + artist.Name
+		}
+
+		if !strings.Contains(strings.ToLower(text), strings.ToLower(query)) {
+			continue
+		}
+
+		result.Results.Tracks.Hits = append(result.Results.Tracks.Hits, spotify.SearchTrackHit{
+			Name:     track.Name,
+			URI:      uri,
+			Artists:  artists,
+			Album:    spotify.SearchRef{Name: track.Album.Name},
+			Duration: track.Duration,
+		})
+	}
+
+	result.Results.Tracks.Total = len(result.Results.Tracks.Hits)
+	writeJSON(w, result)
+}
+
+func (s *Server) recommendations(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("seed_tracks") == "" {
+		http.Error(w, `{"error":{"status":400,"message":"No seeds"}}`, http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tracks := []map[string]any{}
+
+	for _, uri := range s.similar {
+		track := s.tracks[uri]
+		artists := []map[string]any{}
+
+		for _, artist := range track.Artist {
+			artists = append(artists, map[string]any{"name": artist.Name})
+		}
+
+		tracks = append(tracks, map[string]any{
+			"uri":          uri,
+			"name":         track.Name,
+			"duration_ms":  track.Duration,
+			"explicit":     track.Explicit,
+			"artists":      artists,
+			"album":        map[string]any{"name": track.Album.Name},
+			"external_ids": map[string]any{"isrc": track.ISRC()},
+		})
+	}
+
+	writeJSON(w, map[string]any{"tracks": tracks})
+}
+
+func (s *Server) instanceHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/instance":
+		writeJSON(w, s.instance)
+	case "/instance/close":
+		s.current, s.context, s.order, s.queue = "", "", nil, nil
+		s.emit("sessionCleared", nil)
+	case "/instance/terminate":
+		for conn := range s.conns {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// The helpers below are called with s.mu held.
+
+func (s *Server) emit(event string, fields map[string]any) {
+	msg := map[string]any{"event": event}
+
+	for key, value := range fields {
+		msg[key] = value
+	}
+
+	data, _ := json.Marshal(msg)
+
+	for conn := range s.conns {
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			delete(s.conns, conn)
+		}
+	}
+}
+
+func (s *Server) play(uri string) {
+	s.current, s.position, s.since = uri, 0, time.Now()
+	s.emit("trackChanged", map[string]any{"uri": uri})
+
+	if track, ok := s.tracks[uri]; ok {
+		s.emit("metadataAvailable", map[string]any{"track": track})
+	}
+}
+
+func (s *Server) next() {
+	if len(s.queue) > 0 {
+		uri := s.queue[0]
+		s.queue = s.queue[1:]
+		s.play(uri)
+		return
+	}
+
+	if s.index+1 < len(s.order) {
+		s.index++
+		s.play(s.order[s.index])
+		return
+	}
+
+	if s.repeat == "context" && len(s.order) > 0 {
+		s.index = 0
+		s.play(s.order[0])
+		return
+	}
+
+	s.paused = true
+	s.emit("playbackEnded", nil)
+}
+
+func (s *Server) setPaused(paused bool) {
+	s.position, s.since = s.trackTime(), time.Now()
+	s.paused = paused
+
+	if paused {
+		s.emit("playbackPaused", map[string]any{"trackTime": s.position})
+	} else {
+		s.emit("playbackResumed", map[string]any{"trackTime": s.position})
+	}
+}
+
+func (s *Server) setVolume(volume int) {
+	s.volume = min(max(volume, 0), 65536)
+	s.emit("volumeChanged", map[string]any{"value": float64(s.volume) / 65536})
+}
+
+func (s *Server) trackTime() int {
+	if s.paused || s.since.IsZero() {
+		return s.position
+	}
+
+	return s.position + int(time.Since(s.since).Milliseconds())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+	}
+}
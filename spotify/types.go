@@ -1,11 +1,92 @@
 package spotify
 
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
 type SpotifyPlayer struct {
+	mu sync.RWMutex
 	metadata Track
 	paused bool
 	trackTime int64
 	uri string
 	contextUri string
+	volume int
+	hasVolume bool
+	updated time.Time
+}
+
+/*
+A copy of the player state that is safe to hand out. TrackTime was measured at UpdatedAt,
+so clients add the time since then while playing.
+*/
+type PlayerState struct {
+	URI        string    `json:"uri"`
+	ContextURI string    `json:"contextUri"`
+	Paused     bool      `json:"paused"`
+	TrackTime  int64     `json:"trackTime"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Volume     int       `json:"volume"`
+	HasVolume  bool      `json:"hasVolume"`
+	Track      Track     `json:"track"`
+}
+
+/*
+Returns a copy of the current player state.
+*/
+func (p *SpotifyPlayer) Snapshot() PlayerState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return PlayerState{
+		URI:        p.uri,
+		ContextURI: p.contextUri,
+		Paused:     p.paused,
+		TrackTime:  p.trackTime,
+		UpdatedAt:  p.updated,
+		Volume:     p.volume,
+		HasVolume:  p.hasVolume,
+		Track:      p.metadata,
+	}
+}
+
+/*
+Returns the position in the track in ms, TrackTime is only sent on changes so the time since then is added while playing.
+*/
+func (s PlayerState) Position() int64 {
+	pos := s.TrackTime
+
+	if !s.Paused && !s.UpdatedAt.IsZero() {
+		pos += time.Since(s.UpdatedAt).Milliseconds()
+	}
+
+	if s.Track.Duration > 0 && pos > int64(s.Track.Duration) {
+		pos = int64(s.Track.Duration)
+	}
+
+	return pos
+}
+
+/*
+Returns whether playback is paused.
+*/
+func (p *SpotifyPlayer) Paused() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.paused
+}
+
+/*
+Returns the last known volume (from 0 to 65536), ok is false when librespot did not report it yet.
+*/
+func (p *SpotifyPlayer) Volume() (volume int, ok bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.volume, p.hasVolume
 }
 
 type PlaybackState struct {
@@ -73,6 +154,7 @@ type Track struct {
 	DiscNumber            int          `json:"discNumber"`
 	Duration              int          `json:"duration"`
 	Popularity            int          `json:"popularity"`
+	Explicit              bool         `json:"explicit"`
 	ExternalID            []ExternalID `json:"externalId"`
 	File                  []File       `json:"file"`
 	Preview               []Preview    `json:"preview"`
@@ -81,6 +163,38 @@ type Track struct {
 	Licensor              Licensor     `json:"licensor"`
 }
 
+/*
+Returns the ISRC of the track, or an empty string if Spotify does not know it.
+*/
+func (t *Track) ISRC() string {
+	for _, id := range t.ExternalID {
+		if id.Type == "isrc" {
+			return id.ID
+		}
+	}
+
+	return ""
+}
+
+/*
+Returns the URL of the largest album cover, or an empty string when there is none.
+*/
+func (t *Track) Cover() string {
+	var best *Image
+
+	for i, image := range t.Album.CoverGroup.Image {
+		if best == nil || image.Width > best.Width {
+			best = &t.Album.CoverGroup.Image[i]
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+
+	return "https://i.scdn.co/image/" + strings.ToLower(best.FileID)
+}
+
 type TracksState struct {
 	Current struct {
 		URI      string `json:"uri"`
@@ -117,59 +231,110 @@ type TracksState struct {
 	Prev []any `json:"prev"`
 }
 
+/*
+A track as the public Web API describes it, which is not the same as the librespot metadata.
+*/
+type WebTrack struct {
+	URI      string `json:"uri"`
+	Name     string `json:"name"`
+	Duration int    `json:"duration_ms"`
+	Explicit bool   `json:"explicit"`
+	Artists  []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+	Album struct {
+		Name string `json:"name"`
+	} `json:"album"`
+	ExternalIDs struct {
+		ISRC string `json:"isrc"`
+	} `json:"external_ids"`
+}
+
+/*
+The account librespot is logged in with, as the public Web API describes it.
+*/
+type WebUser struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	Country     string `json:"country"`
+	// premium, free or open
+	Product string `json:"product"`
+}
+
+type SearchRef struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+type SearchTrackHit struct {
+	Name        string      `json:"name"`
+	URI         string      `json:"uri"`
+	Image       string      `json:"image"`
+	Artists     []SearchRef `json:"artists"`
+	Album       SearchRef   `json:"album"`
+	Duration    int         `json:"duration"`
+	Mogef19     bool        `json:"mogef19"`
+	Popularity  float64     `json:"popularity"`
+	LyricsMatch bool        `json:"lyricsMatch"`
+}
+
+type SearchAlbumHit struct {
+	Name    string      `json:"name"`
+	URI     string      `json:"uri"`
+	Image   string      `json:"image"`
+	Artists []SearchRef `json:"artists"`
+}
+
+type SearchArtistHit struct {
+	Name     string `json:"name"`
+	URI      string `json:"uri"`
+	Image    string `json:"image"`
+	Verified bool   `json:"verified"`
+}
+
+type SearchPlaylistHit struct {
+	Name           string `json:"name"`
+	URI            string `json:"uri"`
+	Image          string `json:"image"`
+	FollowersCount int    `json:"followersCount"`
+	Author         string `json:"author"`
+	Personalized   bool   `json:"personalized"`
+}
+
+type SearchShowHit struct {
+	Name         string `json:"name"`
+	URI          string `json:"uri"`
+	Image        string `json:"image"`
+	ShowType     string `json:"showType"`
+	MusicAndTalk bool   `json:"musicAndTalk"`
+}
+
+type SearchEpisodeHit struct {
+	Name         string `json:"name"`
+	URI          string `json:"uri"`
+	Image        string `json:"image"`
+	Explicit     bool   `json:"explicit"`
+	Duration     int    `json:"duration"`
+	MusicAndTalk bool   `json:"musicAndTalk"`
+}
+
 type SearchResult struct {
 	Results struct {
 		Tracks struct {
-			Hits []struct {
-				Name    string `json:"name"`
-				URI     string `json:"uri"`
-				Image   string `json:"image"`
-				Artists []struct {
-					Name string `json:"name"`
-					URI  string `json:"uri"`
-				} `json:"artists"`
-				Album struct {
-					Name string `json:"name"`
-					URI  string `json:"uri"`
-				} `json:"album"`
-				Duration    int     `json:"duration"`
-				Mogef19     bool    `json:"mogef19"`
-				Popularity  float64 `json:"popularity"`
-				LyricsMatch bool    `json:"lyricsMatch"`
-			} `json:"hits"`
-			Total int `json:"total"`
+			Hits  []SearchTrackHit `json:"hits"`
+			Total int              `json:"total"`
 		} `json:"tracks"`
 		Albums struct {
-			Hits []struct {
-				Name    string `json:"name"`
-				URI     string `json:"uri"`
-				Image   string `json:"image"`
-				Artists []struct {
-					Name string `json:"name"`
-					URI  string `json:"uri"`
-				} `json:"artists"`
-			} `json:"hits"`
-			Total int `json:"total"`
+			Hits  []SearchAlbumHit `json:"hits"`
+			Total int              `json:"total"`
 		} `json:"albums"`
 		Artists struct {
-			Hits []struct {
-				Name     string `json:"name"`
-				URI      string `json:"uri"`
-				Image    string `json:"image"`
-				Verified bool   `json:"verified"`
-			} `json:"hits"`
-			Total int `json:"total"`
+			Hits  []SearchArtistHit `json:"hits"`
+			Total int               `json:"total"`
 		} `json:"artists"`
 		Playlists struct {
-			Hits []struct {
-				Name           string `json:"name"`
-				URI            string `json:"uri"`
-				Image          string `json:"image"`
-				FollowersCount int    `json:"followersCount"`
-				Author         string `json:"author"`
-				Personalized   bool   `json:"personalized"`
-			} `json:"hits"`
-			Total int `json:"total"`
+			Hits  []SearchPlaylistHit `json:"hits"`
+			Total int                 `json:"total"`
 		} `json:"playlists"`
 		Profiles struct {
 			Hits  []interface{} `json:"hits"`
@@ -180,45 +345,16 @@ type SearchResult struct {
 			Total int           `json:"total"`
 		} `json:"genres"`
 		TopHit struct {
-			Hits []struct {
-				Name    string `json:"name"`
-				URI     string `json:"uri"`
-				Image   string `json:"image"`
-				Artists []struct {
-					Name string `json:"name"`
-					URI  string `json:"uri"`
-				} `json:"artists"`
-				Album struct {
-					Name string `json:"name"`
-					URI  string `json:"uri"`
-				} `json:"album"`
-				Duration    int     `json:"duration"`
-				Mogef19     bool    `json:"mogef19"`
-				Popularity  float64 `json:"popularity"`
-				LyricsMatch bool    `json:"lyricsMatch"`
-			} `json:"hits"`
-			Total int `json:"total"`
+			Hits  []SearchTrackHit `json:"hits"`
+			Total int              `json:"total"`
 		} `json:"topHit"`
 		Shows struct {
-			Hits []struct {
-				Name         string `json:"name"`
-				URI          string `json:"uri"`
-				Image        string `json:"image"`
-				ShowType     string `json:"showType"`
-				MusicAndTalk bool   `json:"musicAndTalk"`
-			} `json:"hits"`
-			Total int `json:"total"`
+			Hits  []SearchShowHit `json:"hits"`
+			Total int             `json:"total"`
 		} `json:"shows"`
 		Audioepisodes struct {
-			Hits []struct {
-				Name         string `json:"name"`
-				URI          string `json:"uri"`
-				Image        string `json:"image"`
-				Explicit     bool   `json:"explicit"`
-				Duration     int    `json:"duration"`
-				MusicAndTalk bool   `json:"musicAndTalk"`
-			} `json:"hits"`
-			Total int `json:"total"`
+			Hits  []SearchEpisodeHit `json:"hits"`
+			Total int                `json:"total"`
 		} `json:"audioepisodes"`
 	} `json:"results"`
 	RequestID       string   `json:"requestId"`
@@ -0,0 +1,381 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+// A librespot that ran this long was fine, the backoff starts over after it goes down
+const stable = time.Minute
+
+// How long librespot gets to stop after SIGTERM before it is killed
+const stopTimeout = 5 * time.Second
+
+// How often /instance is tried while waiting for librespot to come up
+const poll = 500 * time.Millisecond
+
+/*
+How the supervised librespot is doing.
+*/
+type Status struct {
+	// Whether aether runs librespot at all
+	Enabled bool `json:"enabled"`
+	// stopped, starting, ready or backoff
+	State    string    `json:"state"`
+	PID      int       `json:"pid,omitempty"`
+	Started  time.Time `json:"started,omitempty"`
+	Restarts int       `json:"restarts"`
+	// Why librespot was restarted last
+	LastReason string `json:"lastReason,omitempty"`
+}
+
+var mu sync.Mutex
+var status = Status{State: "stopped"}
+var lines []string
+
+// Closed once librespot answers, replaced when it goes down
+var ready = make(chan struct{})
+
+var restart = make(chan struct{}, 1)
+
+/*
+Supervise librespot when librespot.command is set, Run starts it.
+*/
+func Init() {
+	mu.Lock()
+	status.Enabled = len(utils.Cfg().Librespot.Command) > 0
+	mu.Unlock()
+
+	if Current().Enabled {
+		Log("Supervising librespot")
+	}
+}
+
+/*
+Keep librespot running until ctx is cancelled, then stop it. Returns right away when librespot is not supervised.
+*/
+func Run(ctx context.Context) {
+	if !Current().Enabled {
+		return
+	}
+
+	backoff := utils.Cfg().Librespot.Backoff.Duration
+
+	for {
+		started := time.Now()
+		reason := supervise(ctx)
+
+		if ctx.Err() != nil {
+			setState("stopped", 0)
+			return
+		}
+
+		cfg := utils.Cfg().Librespot
+
+		if time.Since(started) > stable || reason == "manual" {
+			backoff = cfg.Backoff.Duration
+		}
+
+		mu.Lock()
+		status.Restarts++
+		status.LastReason = reason
+		mu.Unlock()
+
+		Log(fmt.Sprintf("Restarting librespot in %s (%s)", backoff, reason))
+		live.Publish("failover", map[string]string{"reason": reason, "action": "restartLibrespot"})
+		setState("backoff", 0)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			setState("stopped", 0)
+			return
+		}
+
+		backoff = min(backoff*2, cfg.MaxBackoff.Duration)
+	}
+}
+
+/*
+Restart librespot right away, without a backoff.
+*/
+func Restart() bool {
+	if !Current().Enabled {
+		return false
+	}
+
+	select {
+	case restart <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+/*
+Returns how librespot is doing.
+*/
+func Current() Status {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return status
+}
+
+/*
+Returns the last lines librespot wrote, oldest first.
+*/
+func Lines() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]string{}, lines...)
+}
+
+/*
+Wait until librespot answers on /instance, returns false when ctx was cancelled first. Without a supervised
+librespot /instance is asked directly.
+*/
+func WaitReady(ctx context.Context) bool {
+	if !Current().Enabled {
+		for {
+			if _, err := probe(utils.Cfg().Librespot.Timeout.Duration); err == nil {
+				return true
+			}
+
+			select {
+			case <-time.After(poll):
+			case <-ctx.Done():
+				return false
+			}
+		}
+	}
+
+	mu.Lock()
+	ch := ready
+	mu.Unlock()
+
+	select {
+	case <-ch:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+/*
+Follow the events of the main player, connecting again whenever the stream breaks once librespot is back.
+Returns when ctx is cancelled.
+*/
+func Listen(ctx context.Context, state *spotify.SpotifyPlayer) {
+	for WaitReady(ctx) {
+		err := spotify.ListenToEvents(ctx, state)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.Err("[Supervisor] Lost the Spotify event stream", err)
+		live.Publish("failover", map[string]string{"reason": "eventsLost", "action": "reconnect"})
+
+		// The stream may break before librespot is seen to be down, give it a moment
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+/*
+Run librespot once, until it crashes, stops answering or ctx is cancelled. Returns why it stopped.
+*/
+func supervise(ctx context.Context) string {
+	cfg := utils.Cfg().Librespot
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(runCtx, cfg.Command[0], cfg.Command[1:]...)
+	cmd.Dir = cfg.Dir
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = stopTimeout
+
+	stdout, _ := cmd.StdoutPipe()
+	stderr, _ := cmd.StderrPipe()
+
+	if err := cmd.Start(); err != nil {
+		logger.Err("[Supervisor] Could not start librespot", err)
+		return "failed to start"
+	}
+
+	Log(fmt.Sprintf("Started librespot (pid %d)", cmd.Process.Pid))
+	setState("starting", cmd.Process.Pid)
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go capture(stdout, &output)
+	go capture(stderr, &output)
+
+	exited := make(chan error, 1)
+
+	go func() {
+		// Wait closes the pipes, the output has to be read before
+		output.Wait()
+		exited <- cmd.Wait()
+	}()
+
+	reason := watch(runCtx, cfg, exited)
+
+	mu.Lock()
+	if status.State == "ready" {
+		ready = make(chan struct{})
+	}
+	mu.Unlock()
+
+	if reason != "crashed" {
+		cancel()
+		<-exited
+	}
+
+	return reason
+}
+
+/*
+Wait for librespot to come up and check on it until something is wrong. Returns crashed, unresponsive, manual
+or an empty string when ctx was cancelled.
+*/
+func watch(ctx context.Context, cfg utils.LibrespotConfig, exited chan error) string {
+	startup := time.After(cfg.Startup.Duration)
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	up := false
+	failures := 0
+
+	for {
+		select {
+		case err := <-exited:
+			logger.Err("[Supervisor] librespot exited", err)
+			return "crashed"
+		case <-restart:
+			return "manual"
+		case <-ctx.Done():
+			return ""
+		case <-startup:
+			if !up {
+				fail(fmt.Sprintf("librespot did not come up within %s", cfg.Startup.Duration))
+				return "unresponsive"
+			}
+		case <-ticker.C:
+			instance, err := probe(cfg.Timeout.Duration)
+
+			if !up {
+				if err == nil {
+					up = true
+					ticker.Reset(cfg.Interval.Duration)
+
+					Log(fmt.Sprintf("librespot is ready as %s", instance.DeviceName))
+					setState("ready", 0)
+				}
+
+				continue
+			}
+
+			if err == nil {
+				failures = 0
+				continue
+			}
+
+			failures++
+			fail(fmt.Sprintf("librespot did not answer (%d of %d): %s", failures, cfg.Failures, err))
+
+			if failures >= cfg.Failures {
+				return "unresponsive"
+			}
+		}
+	}
+}
+
+/*
+Ask librespot for /instance, failing when that takes longer than timeout.
+*/
+func probe(timeout time.Duration) (*spotify.InstanceData, error) {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(utils.Cfg().Spotify.URL + "/instance")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("librespot responded with %s", resp.Status)
+	}
+
+	var instance spotify.InstanceData
+
+	return &instance, json.NewDecoder(resp.Body).Decode(&instance)
+}
+
+func capture(r io.Reader, done *sync.WaitGroup) {
+	defer done.Done()
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		logger.Verbose("[Librespot] " + line)
+
+		mu.Lock()
+		lines = append(lines, line)
+
+		if keep := utils.Cfg().Librespot.Lines; len(lines) > keep {
+			lines = lines[len(lines)-keep:]
+		}
+		mu.Unlock()
+	}
+}
+
+/*
+Set the state of librespot, when it is ready everything waiting for it is let through.
+*/
+func setState(state string, pid int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	status.State = state
+
+	switch state {
+	case "starting":
+		status.PID = pid
+		status.Started = time.Now()
+	case "ready":
+		close(ready)
+	case "stopped", "backoff":
+		status.PID = 0
+		status.Started = time.Time{}
+	}
+}
+
+func Log(str string) {
+	logger.Verbose("[Supervisor] " + str)
+}
+
+func fail(str string) {
+	logger.Warn("[Supervisor] " + str)
+}
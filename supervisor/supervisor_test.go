@@ -0,0 +1,148 @@
+package supervisor_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/supervisor"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/spf13/viper"
+)
+
+// librespot hangs while this file exists
+var hang = filepath.Join(os.TempDir(), fmt.Sprintf("aether-librespot-hang-%d", os.Getpid()))
+
+func TestMain(m *testing.M) {
+	// The test binary doubles as librespot
+	if addr := os.Getenv("AETHER_TEST_LIBRESPOT"); addr != "" {
+		fakeLibrespot(addr, os.Getenv("AETHER_TEST_HANG"))
+		return
+	}
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	os.Setenv("AETHER_TEST_LIBRESPOT", addr)
+	os.Setenv("AETHER_TEST_HANG", hang)
+
+	viper.Set("spotify.url", "http://"+addr)
+	viper.Set("spotify.ws", addr)
+	viper.Set("librespot.command", []string{os.Args[0]})
+	viper.Set("librespot.startup", "5s")
+	viper.Set("librespot.interval", "50ms")
+	viper.Set("librespot.timeout", "500ms")
+	viper.Set("librespot.failures", 2)
+	viper.Set("librespot.backoff", "10ms")
+	viper.Set("librespot.maxbackoff", "50ms")
+	utils.LoadConfig()
+
+	supervisor.Init()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		supervisor.Run(ctx)
+	}()
+
+	code := m.Run()
+
+	cancel()
+	<-stopped
+	os.Remove(hang)
+	os.Exit(code)
+}
+
+func fakeLibrespot(addr string, hang string) {
+	fmt.Println("librespot listening on " + addr)
+
+	http.HandleFunc("/instance", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := os.Stat(hang); err == nil {
+			time.Sleep(time.Hour)
+		}
+
+		fmt.Fprint(w, `{"device_id":"0123456789abcdef","device_name":"aether-test"}`)
+	})
+
+	http.ListenAndServe(addr, nil)
+}
+
+func ready(t *testing.T) supervisor.Status {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !supervisor.WaitReady(ctx) {
+		t.Fatalf("librespot did not come up: %+v", supervisor.Current())
+	}
+
+	return supervisor.Current()
+}
+
+/*
+Wait until librespot was restarted after before, and is ready again.
+*/
+func restarted(t *testing.T, before supervisor.Status, reason string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(10 * time.Second); supervisor.Current().Restarts == before.Restarts; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("librespot was not restarted: %+v", supervisor.Current())
+		}
+	}
+
+	after := ready(t)
+
+	if after.LastReason != reason || after.PID == before.PID || after.State != "ready" {
+		t.Errorf("restarted %+v, was %+v", after, before)
+	}
+}
+
+func TestCrash(t *testing.T) {
+	before := ready(t)
+
+	if !slices.Contains(supervisor.Lines(), "librespot listening on "+os.Getenv("AETHER_TEST_LIBRESPOT")) {
+		t.Errorf("the output of librespot was not captured: %q", supervisor.Lines())
+	}
+
+	syscall.Kill(before.PID, syscall.SIGKILL)
+
+	restarted(t, before, "crashed")
+}
+
+func TestUnresponsive(t *testing.T) {
+	before := ready(t)
+
+	os.WriteFile(hang, nil, 0o644)
+
+	for deadline := time.Now().Add(10 * time.Second); supervisor.Current().Restarts == before.Restarts; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("a hanging librespot was not restarted: %+v", supervisor.Current())
+		}
+	}
+
+	os.Remove(hang)
+
+	restarted(t, before, "unresponsive")
+}
+
+func TestRestart(t *testing.T) {
+	before := ready(t)
+
+	if !supervisor.Restart() {
+		t.Fatal("librespot is not supervised")
+	}
+
+	restarted(t, before, "manual")
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"time"
+)
+
+/*
+Parse a time of day written as HH:MM into minutes after midnight. Single digit hours like 7:00 are accepted too.
+*/
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+/*
+Returns whether now falls between from and to (HH:MM, local time), to itself is not included. Windows may wrap
+around midnight, like 23:00 to 07:00. A window with a time that does not parse never matches.
+*/
+func InWindow(from string, to string, now time.Time) bool {
+	start, fromErr := ParseClock(from)
+	end, toErr := ParseClock(to)
+
+	if fromErr != nil || toErr != nil {
+		return false
+	}
+
+	minutes := now.Hour()*60 + now.Minute()
+
+	if start <= end {
+		return minutes >= start && minutes < end
+	}
+
+	return minutes >= start || minutes < end
+}
@@ -0,0 +1,48 @@
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/utils"
+)
+
+func TestParseClock(t *testing.T) {
+	for s, want := range map[string]int{"00:00": 0, "07:00": 420, "7:00": 420, "23:59": 1439} {
+		if got, err := utils.ParseClock(s); err != nil || got != want {
+			t.Errorf("%s parsed as %d, %v", s, got, err)
+		}
+	}
+
+	for _, s := range []string{"24:00", "7", "07:60", ""} {
+		if _, err := utils.ParseClock(s); err == nil {
+			t.Errorf("%s parsed", s)
+		}
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	at := func(hour int, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	cases := []struct {
+		from, to string
+		now      time.Time
+		want     bool
+	}{
+		{"7:00", "9:30", at(8, 0), true},
+		{"07:00", "09:30", at(9, 30), false},
+		{"7:00", "17:00", at(12, 0), true},
+		{"23:00", "7:00", at(23, 30), true},
+		{"23:00", "7:00", at(3, 0), true},
+		{"23:00", "7:00", at(12, 0), false},
+		{"7:00", "nope", at(8, 0), false},
+	}
+
+	for _, c := range cases {
+		if got := utils.InWindow(c.from, c.to, c.now); got != c.want {
+			t.Errorf("%s in %s-%s returned %v", c.now.Format("15:04"), c.from, c.to, got)
+		}
+	}
+}
@@ -1,28 +1,279 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/fsnotify/fsnotify"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
+var configMu sync.RWMutex
+var config Config
+var reloadHooks []func(old Config, new Config)
+
 func LoadConfig() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("toml")
 	viper.AddConfigPath("/etc/aether/")
 	viper.AddConfigPath(".")
 	viper.SetEnvPrefix("AETHER")
+	// AETHER_SPOTIFY_URL sets spotify.url
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
-		// No file was found, no worries, we write default file
+		// No file was found, no worries, we run on the defaults
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
 			// Oops, something else went wrong
 			panic(fmt.Errorf("fatal error config file: %w", err))
 		}
+
+		logger.Warn("No config file found, using the defaults")
 	}
 
 	viper.SetDefault("spotify.url", "http://localhost:24879")
+	viper.SetDefault("spotify.ws", "localhost:24879")
+
+	viper.SetDefault("fallback.playlist", "")
+
+	viper.SetDefault("history.size", 1000)
+	viper.SetDefault("history.file", "history.json")
+
+	viper.SetDefault("session.file", "session.json")
+	viper.SetDefault("session.interval", "15s")
+	viper.SetDefault("session.resume", "ask")
+	viper.SetDefault("session.maxage", "12h")
+
+	viper.SetDefault("audio.samplerate", 44100)
+	viper.SetDefault("audio.buffer", "100ms")
+
+	viper.SetDefault("announce.dir", "announcements")
+	viper.SetDefault("announce.mode", "duck")
+	viper.SetDefault("announce.duck", 0.2)
+	viper.SetDefault("announce.fade", "500ms")
+	viper.SetDefault("announce.url", "")
+
+	viper.SetDefault("soundboard.dir", "soundboard")
+	viper.SetDefault("soundboard.mode", "mix")
+	viper.SetDefault("soundboard.priority", 0)
+	viper.SetDefault("soundboard.cooldown", "30s")
+	viper.SetDefault("soundboard.usercooldown", "10s")
+
+	viper.SetDefault("library.dirs", []string{})
+	viper.SetDefault("library.index", "library.json")
+
+	viper.SetDefault("local.crossfade", "6s")
+	viper.SetDefault("local.gapless", true)
+	viper.SetDefault("local.preload", "2s")
+	viper.SetDefault("local.replaygain", "track")
+	viper.SetDefault("local.preamp", 0.0)
+
+	viper.SetDefault("search.prefer", "spotify")
+	viper.SetDefault("search.isrclookups", 10)
+	viper.SetDefault("search.locallimit", 50)
+
+	viper.SetDefault("volume.max", 0)
+
+	viper.SetDefault("admin.token", "")
+
+	viper.SetDefault("http.addr", ":8080")
+
+	viper.SetDefault("shutdown.timeout", "5s")
+	viper.SetDefault("shutdown.pause", false)
+	viper.SetDefault("shutdown.fade", "0s")
+
+	viper.SetDefault("record.enabled", false)
+	viper.SetDefault("record.file", "recording.jsonl")
+	viper.SetDefault("record.maxsize", 10)
+	viper.SetDefault("record.keep", 3)
+
+	viper.SetDefault("webhooks.hooks", []any{})
+	viper.SetDefault("webhooks.file", "webhooks.json")
+	viper.SetDefault("webhooks.timeout", "5s")
+	viper.SetDefault("webhooks.retries", 5)
+	viper.SetDefault("webhooks.backoff", "2s")
+	viper.SetDefault("webhooks.log", 100)
+
+	viper.SetDefault("mqtt.broker", "")
+	viper.SetDefault("mqtt.clientid", "aether")
+	viper.SetDefault("mqtt.username", "")
+	viper.SetDefault("mqtt.password", "")
+	viper.SetDefault("mqtt.prefix", "aether")
+	viper.SetDefault("mqtt.discovery", true)
+	viper.SetDefault("mqtt.discoveryprefix", "homeassistant")
+	viper.SetDefault("mqtt.interval", "30s")
+
+	viper.SetDefault("mpd.addr", "")
+	viper.SetDefault("mpd.password", "")
+
+	viper.SetDefault("lyrics.enabled", true)
+	viper.SetDefault("lyrics.url", "https://lrclib.net")
+	viper.SetDefault("lyrics.lead", "0s")
+
+	viper.SetDefault("queue.duplicates", "merge")
+	viper.SetDefault("queue.recent", "30m")
+
+	viper.SetDefault("autoplay.enabled", true)
+	viper.SetDefault("autoplay.source", "both")
+	viper.SetDefault("autoplay.seeds", 5)
+
+	viper.SetDefault("zones", []any{})
+
+	viper.SetDefault("librespot.command", []string{})
+	viper.SetDefault("librespot.dir", "")
+	viper.SetDefault("librespot.startup", "60s")
+	viper.SetDefault("librespot.interval", "10s")
+	viper.SetDefault("librespot.timeout", "5s")
+	viper.SetDefault("librespot.failures", 3)
+	viper.SetDefault("librespot.backoff", "1s")
+	viper.SetDefault("librespot.maxbackoff", "5m")
+	viper.SetDefault("librespot.lines", 200)
+
+	viper.SetDefault("recovery.enabled", true)
+	viper.SetDefault("recovery.grace", "10s")
+	viper.SetDefault("recovery.wait", "5s")
+	viper.SetDefault("recovery.timeout", "60s")
+
+	viper.SetDefault("policy.profile", "")
+	viper.SetDefault("policy.profiles", map[string]any{})
+	viper.SetDefault("policy.schedule", []any{})
+
+	viper.SetDefault("scrobble.file", "scrobbles.json")
+	viper.SetDefault("scrobble.backoff", "30s")
+	viper.SetDefault("scrobble.lastfm.key", "")
+	viper.SetDefault("scrobble.lastfm.secret", "")
+	viper.SetDefault("scrobble.lastfm.session", "")
+	viper.SetDefault("scrobble.lastfm.url", "https://ws.audioscrobbler.com/2.0/")
+	viper.SetDefault("scrobble.listenbrainz.token", "")
+	viper.SetDefault("scrobble.listenbrainz.url", "https://api.listenbrainz.org")
+
+	viper.SetDefault("chat.prefix", "!")
+	viper.SetDefault("chat.skipvotes", 3)
+	viper.SetDefault("chat.users", []any{})
+	viper.SetDefault("chat.local", false)
+	viper.SetDefault("chat.discord.token", "")
+	viper.SetDefault("chat.discord.channels", []string{})
+	viper.SetDefault("chat.discord.announce", "")
+
+	cfg, err := decodeConfig()
+
+	if err != nil {
+		logger.Err("Invalid config "+viper.ConfigFileUsed(), err)
+		os.Exit(1)
+	}
+
+	configMu.Lock()
+	config = cfg
+	configMu.Unlock()
+
+	if viper.ConfigFileUsed() != "" {
+		viper.OnConfigChange(reloadConfig)
+		viper.WatchConfig()
+	}
+}
+
+/*
+Returns the current config. The maps and slices in it are shared, don't modify them.
+*/
+func Cfg() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return config
+}
+
+/*
+Run fn after the config file changed and the new config is valid. Subsystems that copy config values at
+startup use this to pick up the changes.
+*/
+func OnConfigChange(fn func(old Config, new Config)) {
+	configMu.Lock()
+	reloadHooks = append(reloadHooks, fn)
+	configMu.Unlock()
+}
+
+/*
+Read the viper settings into a Config, rejecting unknown keys and invalid values.
+*/
+func decodeConfig() (Config, error) {
+	var cfg Config
+	var meta mapstructure.Metadata
+
+	err := viper.Unmarshal(&cfg, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		durationHook,
+		mapstructure.StringToSliceHookFunc(","),
+	)), func(dc *mapstructure.DecoderConfig) {
+		dc.Metadata = &meta
+	})
+
+	if err != nil {
+		return cfg, err
+	}
+
+	var errs []error
+	known := configKeys(reflect.TypeOf(cfg), "")
+
+	for _, key := range viper.AllKeys() {
+		for _, unused := range meta.Unused {
+			if key == unused || strings.HasPrefix(key, unused+".") {
+				errs = append(errs, unknownKey(key, known))
+				break
+			}
+		}
+	}
 
-	viper.SafeWriteConfig()
-}
\ No newline at end of file
+	return cfg, errors.Join(append(errs, cfg.Validate())...)
+}
+
+func durationHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(Duration{}) || from.Kind() != reflect.String {
+		return data, nil
+	}
+
+	d, err := time.ParseDuration(data.(string))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Duration{d}, nil
+}
+
+/*
+Apply a changed config file, an invalid file is ignored and the running config stays in place.
+*/
+func reloadConfig(e fsnotify.Event) {
+	cfg, err := decodeConfig()
+
+	if err != nil {
+		logger.Err("Ignoring changes to "+e.Name, err)
+		return
+	}
+
+	configMu.Lock()
+	old := config
+	config = cfg
+	hooks := append([]func(Config, Config){}, reloadHooks...)
+	configMu.Unlock()
+
+	logger.Log("Reloaded " + e.Name)
+
+	// These are only read when aether starts
+	if old.Spotify.WS != cfg.Spotify.WS || old.Audio != cfg.Audio || old.Library.Index != cfg.Library.Index || old.HTTP != cfg.HTTP || old.MQTT != cfg.MQTT || old.MPD.Addr != cfg.MPD.Addr ||
+		old.Chat.Local != cfg.Chat.Local || old.Chat.Discord.Token != cfg.Chat.Discord.Token || !slices.Equal(old.Zones, cfg.Zones) {
+		logger.Warn("spotify.ws, audio, library.index, http, mqtt, mpd.addr, chat.local, chat.discord.token and zones only change after a restart")
+	}
+
+	for _, hook := range hooks {
+		hook(old, cfg)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"net/http"
 
 	"github.com/KokopelliMusic/go-lib/logger"
-	"github.com/spf13/viper"
 )
 
 type ConnectionStatus struct {
@@ -12,6 +11,11 @@ type ConnectionStatus struct {
 	mp3 bool
 }
 
+type Health struct {
+	Spotify bool `json:"spotify"`
+	MP3     bool `json:"mp3"`
+}
+
 func InitConnectionStatus() *ConnectionStatus {
 	var s ConnectionStatus
 
@@ -23,9 +27,21 @@ func InitConnectionStatus() *ConnectionStatus {
 	return &s
 }
 
+/*
+Test all connections again and report the results.
+*/
+func CheckHealth() Health {
+	var s ConnectionStatus
+
+	return Health{
+		Spotify: s.TestSpotifyConnection(),
+		MP3:     s.TestMP3Connection(),
+	}
+}
+
 func (s *ConnectionStatus) TestSpotifyConnection() bool {
 
-	url := viper.GetString("spotify.url")
+	url := Cfg().Spotify.URL
 
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 
@@ -34,13 +50,16 @@ func (s *ConnectionStatus) TestSpotifyConnection() bool {
 		return false
 	}
 
-	_, err = http.DefaultClient.Do(req)
+	resp, err := http.DefaultClient.Do(req)
 
 	if err != nil {
 		logger.Err("Spotify down?", err)
 		s.spotify = false
+		return false
 	}
 
+	resp.Body.Close()
+
 	return true
 }
 
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+)
+
+/*
+Read a JSON file into v, a missing file leaves v untouched.
+*/
+func ReadJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+/*
+Write v to a JSON file. It is written next to the file first, so a crash halfway never leaves a broken file behind.
+*/
+func WriteJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+)
+
+/*
+How often an item in a RetryQueue was tried and when it is due again, embed it in the type that is queued.
+*/
+type Retry struct {
+	ID       int       `json:"id"`
+	Attempts int       `json:"attempts"`
+	NextAt   time.Time `json:"nextAt"`
+}
+
+// Retries back off to at most this long between tries
+const maxBackoff = time.Hour
+
+/*
+Items that are tried until they succeed or are given up on. The queue is kept in a JSON file, so nothing is lost
+while aether restarts or the other end is down.
+*/
+type RetryQueue[T any] struct {
+	// What is queued, like the pending webhook deliveries, for the log
+	name  string
+	file  func() string
+	retry func(*T) *Retry
+
+	mu     sync.Mutex
+	items  []T
+	lastID int
+
+	// Adding and running both save, one at a time
+	saveMu sync.Mutex
+	// Wakes Run when an item was added
+	wake chan struct{}
+}
+
+/*
+Returns an empty queue stored in the file that file returns, retry returns the Retry embedded in an item.
+*/
+func NewRetryQueue[T any](name string, file func() string, retry func(*T) *Retry) *RetryQueue[T] {
+	return &RetryQueue[T]{name: name, file: file, retry: retry, wake: make(chan struct{}, 1)}
+}
+
+/*
+Read the items that were left in the file by the last run.
+*/
+func (q *RetryQueue[T]) Load() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := ReadJSON(q.file(), &q.items); err != nil {
+		return err
+	}
+
+	for i := range q.items {
+		q.lastID = max(q.lastID, q.retry(&q.items[i]).ID)
+	}
+
+	return nil
+}
+
+/*
+Returns the items that are waiting for their first or next try.
+*/
+func (q *RetryQueue[T]) List() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]T{}, q.items...)
+}
+
+/*
+Queue items, each gets an id of its own. They are tried once their NextAt has passed.
+*/
+func (q *RetryQueue[T]) Add(items ...T) {
+	q.mu.Lock()
+
+	for _, item := range items {
+		q.lastID++
+		q.retry(&item).ID = q.lastID
+		q.items = append(q.items, item)
+	}
+
+	q.mu.Unlock()
+
+	q.save()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+/*
+Hand everything that is due to try, then sleep until the next item is due or one is added. try reports back with
+Done and Retry, items it leaves alone are handed to it again right away. Never returns.
+*/
+func (q *RetryQueue[T]) Run(try func(due []T)) {
+	for {
+		var due []T
+		next := maxBackoff
+
+		q.mu.Lock()
+		for i := range q.items {
+			if wait := time.Until(q.retry(&q.items[i]).NextAt); wait <= 0 {
+				due = append(due, q.items[i])
+			} else {
+				next = min(next, wait)
+			}
+		}
+		q.mu.Unlock()
+
+		if len(due) > 0 {
+			try(due)
+			q.save()
+			continue
+		}
+
+		select {
+		case <-q.wake:
+		case <-time.After(next):
+		}
+	}
+}
+
+/*
+Forget the item with id, it succeeded or never will.
+*/
+func (q *RetryQueue[T]) Done(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.items {
+		if q.retry(&q.items[i]).ID == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return
+		}
+	}
+}
+
+/*
+Try the item with id again later. The wait starts at backoff and doubles with every attempt, up to an hour.
+*/
+func (q *RetryQueue[T]) Retry(id int, backoff time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.items {
+		if r := q.retry(&q.items[i]); r.ID == id {
+			r.Attempts++
+			r.NextAt = time.Now().Add(min(backoff<<min(r.Attempts-1, 16), maxBackoff))
+			return
+		}
+	}
+}
+
+func (q *RetryQueue[T]) save() {
+	q.saveMu.Lock()
+	defer q.saveMu.Unlock()
+
+	items := q.List()
+
+	if err := WriteJSON(q.file(), items); err != nil {
+		logger.Err("Could not save "+q.name, err)
+	}
+}
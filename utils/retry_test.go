@@ -0,0 +1,84 @@
+package utils_test
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/utils"
+)
+
+type message struct {
+	utils.Retry
+	Text string `json:"text"`
+}
+
+func newQueue(file string) *utils.RetryQueue[message] {
+	return utils.NewRetryQueue("the test messages", func() string { return file }, func(m *message) *utils.Retry { return &m.Retry })
+}
+
+func TestRetryQueue(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "queue.json")
+	q := newQueue(file)
+
+	tried := make(chan []string, 10)
+
+	go q.Run(func(due []message) {
+		var texts []string
+
+		for _, m := range due {
+			texts = append(texts, m.Text)
+
+			if m.Text == "hello" {
+				q.Done(m.ID)
+			} else {
+				q.Retry(m.ID, time.Hour)
+			}
+		}
+
+		tried <- texts
+	})
+
+	q.Add(message{Text: "hello"}, message{Text: "world"})
+
+	select {
+	case texts := <-tried:
+		if !slices.Equal(texts, []string{"hello", "world"}) {
+			t.Fatalf("tried %v", texts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the messages were not tried")
+	}
+
+	// The retry is an hour away, so nothing else is tried
+	select {
+	case texts := <-tried:
+		t.Fatalf("tried %v again right away", texts)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A new queue picks up what is left from the file, which is saved after the try
+	var restored *utils.RetryQueue[message]
+	var list []message
+
+	for deadline := time.Now().Add(time.Second); len(list) != 1 && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		restored = newQueue(file)
+
+		if err := restored.Load(); err != nil {
+			t.Fatal(err)
+		}
+
+		list = restored.List()
+	}
+
+	if len(list) != 1 || list[0].Text != "world" || list[0].ID != 2 || list[0].Attempts != 1 || time.Until(list[0].NextAt) < 59*time.Minute {
+		t.Fatalf("restored %+v", list)
+	}
+
+	restored.Add(message{Text: "again"})
+
+	if list := restored.List(); list[1].ID != 3 {
+		t.Errorf("a new message after a restart got id %d", list[1].ID)
+	}
+}
@@ -0,0 +1,185 @@
+package volume
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+const Max = 65536
+
+/*
+A volume cap that applies between From and To (HH:MM, local time). Windows may wrap around midnight.
+*/
+type Window = utils.VolumeWindow
+
+var player *spotify.SpotifyPlayer
+
+var mu sync.Mutex
+var limit int
+var schedule []Window
+
+/*
+Load the volume cap and quiet hours from the config and keep Spotify below them, also when the volume is changed
+from a phone through Spotify Connect.
+*/
+func Init(state *spotify.SpotifyPlayer) {
+	player = state
+	limit = utils.Cfg().Volume.Max
+	schedule = utils.Cfg().Volume.Schedule
+
+	// Changes made on the admin page stay until the config file changes the same setting
+	utils.OnConfigChange(func(old utils.Config, new utils.Config) {
+		if old.Volume.Max != new.Volume.Max {
+			SetLimit(new.Volume.Max)
+		}
+
+		if !slices.Equal(old.Volume.Schedule, new.Volume.Schedule) {
+			SetSchedule(new.Volume.Schedule)
+		}
+	})
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		for event := range events {
+			if event.Type != "volumeChanged" {
+				continue
+			}
+
+			data, _ := event.Data.(map[string]interface{})
+			value, ok := data["value"].(float64)
+
+			if !ok {
+				continue
+			}
+
+			if highest := Cap(); int(value*Max) > highest {
+				Log(fmt.Sprintf("Volume %d is above the cap, lowering it to %d", int(value*Max), highest))
+				spotify.SetVolume(highest, 0)
+			}
+		}
+	}()
+
+	// Schedule windows start while nobody touches the volume
+	go func() {
+		for range time.Tick(time.Minute) {
+			enforce()
+		}
+	}()
+}
+
+/*
+Returns the highest volume allowed right now, taking the active schedule window into account.
+*/
+func Cap() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	highest := Max
+
+	if limit > 0 {
+		highest = limit
+	}
+
+	now := time.Now()
+
+	for _, w := range schedule {
+		if utils.InWindow(w.From, w.To, now) {
+			highest = min(highest, w.Max)
+		}
+	}
+
+	return highest
+}
+
+/*
+Returns the volume cap that applies outside of the schedule, 0 means no cap.
+*/
+func Limit() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return limit
+}
+
+func SetLimit(max int) error {
+	if max < 0 || max > Max {
+		return fmt.Errorf("volume cap should be between 0 and %d", Max)
+	}
+
+	mu.Lock()
+	limit = max
+	mu.Unlock()
+
+	Log(fmt.Sprintf("Volume cap set to %d", max))
+
+	return enforce()
+}
+
+func Schedule() []Window {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]Window{}, schedule...)
+}
+
+func SetSchedule(windows []Window) error {
+	for _, w := range windows {
+		if _, err := utils.ParseClock(w.From); err != nil {
+			return fmt.Errorf("invalid start time %s, use HH:MM", w.From)
+		}
+
+		if _, err := utils.ParseClock(w.To); err != nil {
+			return fmt.Errorf("invalid end time %s, use HH:MM", w.To)
+		}
+
+		if w.Max < 0 || w.Max > Max {
+			return fmt.Errorf("volume cap should be between 0 and %d", Max)
+		}
+	}
+
+	mu.Lock()
+	schedule = windows
+	mu.Unlock()
+
+	return enforce()
+}
+
+/*
+Returns volume lowered to the current cap.
+*/
+func Clamp(volume int) int {
+	return min(volume, Cap())
+}
+
+/*
+Lower the Spotify volume right away if it is above the current cap.
+*/
+func enforce() error {
+	if player == nil {
+		return nil
+	}
+
+	current, ok := player.Volume()
+
+	if highest := Cap(); ok && current > highest {
+		Log(fmt.Sprintf("Volume %d is above the cap, lowering it to %d", current, highest))
+
+		if _, err := spotify.SetVolume(highest, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Log(str string) {
+	logger.Verbose("[Volume] " + str)
+}
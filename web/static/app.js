@@ -0,0 +1,187 @@
+// Shared helpers for the aether pages, everything goes through the regular HTTP API.
+
+const MAX_VOLUME = 65536;
+
+const aether = {
+  user: localStorage.getItem("aether.user") || "",
+  token: localStorage.getItem("aether.token") || "",
+
+  setUser(user) {
+    this.user = user;
+    localStorage.setItem("aether.user", user);
+  },
+
+  setToken(token) {
+    this.token = token;
+    localStorage.setItem("aether.token", token);
+  },
+
+  // Calls the API, params are sent as a form like the CLI does. Throws with the message of the server on errors.
+  async api(method, path, params, json) {
+    const headers = {};
+    let body;
+
+    if (this.user) headers["X-Aether-User"] = this.user;
+    if (this.token) headers["Authorization"] = "Bearer " + this.token;
+
+    if (json !== undefined) {
+      headers["Content-Type"] = "application/json";
+      body = JSON.stringify(json);
+    } else if (params && method !== "GET") {
+      body = new URLSearchParams(params);
+    } else if (params) {
+      path += "?" + new URLSearchParams(params);
+    }
+
+    const res = await fetch(path, { method, headers, body });
+    const data = await res.json().catch(() => ({}));
+
+    if (!res.ok) {
+      const err = new Error(data.message || res.statusText);
+      err.status = res.status;
+      throw err;
+    }
+
+    return data;
+  },
+
+  get(path, params) {
+    return this.api("GET", path, params);
+  },
+
+  post(path, params) {
+    return this.api("POST", path, params);
+  },
+};
+
+// Keeps a copy of the player state and request queue up to date from /live, calls render after every change.
+class Live {
+  constructor(render) {
+    this.render = render;
+    this.state = { track: {}, paused: true, trackTime: 0, updatedAt: null, hasVolume: false };
+    this.playing = null;
+    this.queue = [];
+    this.connected = false;
+    this.connect();
+  }
+
+  connect() {
+    const source = new EventSource("/live");
+    const on = (type, fn) => source.addEventListener(type, (e) => {
+      const event = JSON.parse(e.data);
+      fn(event.data || {}, event);
+      this.render(this);
+    });
+
+    on("state", (state) => {
+      this.state = state;
+      this.connected = true;
+      this.refreshQueue();
+    });
+
+    on("contextChanged", (d) => this.seek(d));
+    on("trackChanged", (d, e) => {
+      this.state.uri = d.uri;
+      this.state.trackTime = 0;
+      this.state.updatedAt = e.time;
+
+      if (this.playing && this.playing.uri !== d.uri) this.playing = null;
+    });
+    on("metadataAvailable", (d) => (this.state.track = d.track || {}));
+    on("playbackPaused", (d, e) => this.pause(true, d, e));
+    on("playbackEnded", (d, e) => this.pause(true, d, e));
+    on("playbackFailed", (d, e) => this.pause(true, d, e));
+    on("playbackResumed", (d, e) => this.pause(false, d, e));
+    on("playbackHaltStateChanged", (d, e) => this.pause(d.halted, d, e));
+    on("trackSeeked", (d, e) => this.seek(d, e));
+    on("volumeChanged", (d) => {
+      this.state.volume = Math.round(d.value * MAX_VOLUME);
+      this.state.hasVolume = true;
+    });
+    on("requestQueued", (r) => this.queue.push(r));
+    on("requestRemoved", (r) => this.unqueue(r));
+    on("requestPlaying", (r) => {
+      this.unqueue(r);
+      this.playing = r;
+    });
+
+    source.onerror = () => {
+      this.connected = false;
+      this.render(this);
+    };
+  }
+
+  pause(paused, d, e) {
+    this.seek(d, e);
+    this.state.paused = paused;
+  }
+
+  // trackTime is measured when the event is sent, interpolation starts there
+  seek(d, e) {
+    if (d.trackTime === undefined || !e) return;
+
+    this.state.trackTime = d.trackTime;
+    this.state.updatedAt = e.time;
+  }
+
+  unqueue(r) {
+    this.queue = this.queue.filter((q) => q.id !== r.id);
+  }
+
+  async refreshQueue() {
+    try {
+      const q = await aether.get("/queue");
+      this.playing = q.playing;
+      this.queue = q.queue || [];
+      this.render(this);
+    } catch (err) {
+      console.error(err);
+    }
+  }
+
+  // Position in ms, trackTime is only sent on changes so the time since then is added while playing
+  position() {
+    const s = this.state;
+    let pos = s.trackTime || 0;
+
+    if (!s.paused && s.updatedAt) pos += Date.now() - new Date(s.updatedAt).getTime();
+    if (s.track.duration) pos = Math.min(pos, s.track.duration);
+
+    return pos;
+  }
+}
+
+function artistNames(track) {
+  return (track.artist || []).map((a) => a.name).join(", ");
+}
+
+// Largest cover of a Spotify track
+function cover(track) {
+  const images = (((track || {}).album || {}).coverGroup || {}).image || [];
+  const best = images.reduce((a, b) => (!a || b.width > a.width ? b : a), null);
+
+  return best ? "https://i.scdn.co/image/" + best.fileId.toLowerCase() : "";
+}
+
+// Search results use spotify:image:<id> uris
+function imageURL(uri) {
+  if (!uri) return "";
+  if (uri.startsWith("spotify:image:")) return "https://i.scdn.co/image/" + uri.slice(14);
+
+  return uri;
+}
+
+function formatMs(ms) {
+  const s = Math.floor((ms || 0) / 1000);
+
+  return Math.floor(s / 60) + ":" + String(s % 60).padStart(2, "0");
+}
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+
+  Object.assign(node, attrs || {});
+  node.append(...children.filter((c) => c !== null && c !== undefined));
+
+  return node;
+}
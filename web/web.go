@@ -0,0 +1,24 @@
+// Package web holds the web app that aether serves on /ui.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed static
+var files embed.FS
+
+/*
+Returns the files of the web app, index.html is at the root.
+*/
+func Static() fs.FS {
+	static, err := fs.Sub(files, "static")
+
+	// Only fails when the directory is missing, which the embed directive already prevents
+	if err != nil {
+		panic(err)
+	}
+
+	return static
+}
@@ -0,0 +1,252 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+/*
+The body of every webhook call.
+*/
+type Payload struct {
+	Event string              `json:"event"`
+	Time  time.Time           `json:"time"`
+	Data  any                 `json:"data,omitempty"`
+	State spotify.PlayerState `json:"state"`
+}
+
+/*
+An event on its way to a single URL. Deliveries stay in webhooks.file until they succeed or run out of retries.
+*/
+type Delivery struct {
+	utils.Retry
+	URL       string          `json:"url"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+/*
+A single try to deliver, as shown in the delivery log.
+*/
+type Attempt struct {
+	Delivery int       `json:"delivery"`
+	URL      string    `json:"url"`
+	Event    string    `json:"event"`
+	Attempt  int       `json:"attempt"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration int64     `json:"duration"` // ms
+	Time     time.Time `json:"time"`
+	// delivered, retrying or failed
+	Outcome string `json:"outcome"`
+}
+
+var player *spotify.SpotifyPlayer
+
+var pending = utils.NewRetryQueue("the pending webhook deliveries", func() string { return utils.Cfg().Webhooks.File },
+	func(d *Delivery) *utils.Retry { return &d.Retry })
+
+var mu sync.Mutex
+var attempts []Attempt
+
+/*
+Pick up the deliveries left by the last run and start sending the events the configured hooks subscribe to.
+*/
+func Init(state *spotify.SpotifyPlayer) {
+	player = state
+
+	if err := pending.Load(); err != nil {
+		logger.Err("Could not read the pending webhook deliveries", err)
+	}
+
+	if n := len(pending.List()); n > 0 {
+		Log(fmt.Sprintf("Resuming %d pending deliveries", n))
+	}
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		for event := range events {
+			enqueue(event)
+		}
+	}()
+
+	go pending.Run(func(due []Delivery) {
+		for _, d := range due {
+			deliver(d)
+		}
+	})
+}
+
+/*
+Returns the deliveries that are waiting for their first or next try.
+*/
+func Pending() []Delivery {
+	return pending.List()
+}
+
+/*
+Returns the last limit delivery attempts, newest first.
+*/
+func Attempts(limit int) []Attempt {
+	mu.Lock()
+	defer mu.Unlock()
+
+	result := []Attempt{}
+
+	for i := len(attempts) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, attempts[i])
+	}
+
+	return result
+}
+
+/*
+Queue a delivery of event for every hook that subscribes to it.
+*/
+func enqueue(event live.Event) {
+	var hooks []utils.Webhook
+
+	for _, hook := range utils.Cfg().Webhooks.Hooks {
+		if slices.Contains(hook.Events, event.Type) || slices.Contains(hook.Events, "*") {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(Payload{Event: event.Type, Time: event.Time, Data: event.Data, State: player.Snapshot()})
+
+	if err != nil {
+		logger.Err("Could not encode the "+event.Type+" webhook", err)
+		return
+	}
+
+	deliveries := make([]Delivery, len(hooks))
+
+	for i, hook := range hooks {
+		deliveries[i] = Delivery{Retry: utils.Retry{NextAt: event.Time}, URL: hook.URL, Event: event.Type, Payload: payload, CreatedAt: event.Time}
+	}
+
+	pending.Add(deliveries...)
+}
+
+/*
+Try to deliver d once and either forget it or schedule the next try.
+*/
+func deliver(d Delivery) {
+	cfg := utils.Cfg().Webhooks
+	d.Attempts++
+
+	attempt := Attempt{Delivery: d.ID, URL: d.URL, Event: d.Event, Attempt: d.Attempts, Time: time.Now()}
+	hook, ok := find(cfg.Hooks, d.URL)
+
+	if ok {
+		attempt.Status, attempt.Error = post(hook, d, cfg.Timeout.Duration)
+	} else {
+		attempt.Error = "the hook is no longer configured"
+	}
+
+	attempt.Duration = time.Since(attempt.Time).Milliseconds()
+
+	switch {
+	case attempt.Error == "":
+		attempt.Outcome = "delivered"
+	case ok && d.Attempts <= cfg.Retries:
+		attempt.Outcome = "retrying"
+	default:
+		attempt.Outcome = "failed"
+		logger.Warn(fmt.Sprintf("[Webhook] Giving up on %s to %s: %s", d.Event, d.URL, attempt.Error))
+	}
+
+	if attempt.Outcome == "retrying" {
+		pending.Retry(d.ID, cfg.Backoff.Duration)
+	} else {
+		pending.Done(d.ID)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	attempts = append(attempts, attempt)
+
+	if over := len(attempts) - cfg.Log; over > 0 {
+		attempts = attempts[over:]
+	}
+}
+
+/*
+POST the payload of d to hook. Returns the status code and a description of what went wrong, if anything did.
+*/
+func post(hook utils.Webhook, d Delivery, timeout time.Duration) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+
+	if err != nil {
+		return 0, fmt.Sprint(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "aether-webhook")
+	req.Header.Set("X-Aether-Event", d.Event)
+	req.Header.Set("X-Aether-Delivery", strconv.Itoa(d.ID))
+
+	if hook.Secret != "" {
+		req.Header.Set("X-Aether-Signature", "sha256="+Sign(hook.Secret, d.Payload))
+	}
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return 0, fmt.Sprint(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, "responded with " + resp.Status
+	}
+
+	return resp.StatusCode, ""
+}
+
+/*
+Returns the hex encoded HMAC-SHA256 of body, receivers compare it to the X-Aether-Signature header.
+*/
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func find(hooks []utils.Webhook, url string) (utils.Webhook, bool) {
+	for _, hook := range hooks {
+		if hook.URL == url {
+			return hook, true
+		}
+	}
+
+	return utils.Webhook{}, false
+}
+
+func Log(str string) {
+	logger.Verbose("[Webhook] " + str)
+}
@@ -0,0 +1,119 @@
+package webhook_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/webhook"
+	"github.com/spf13/viper"
+)
+
+var file string
+
+func TestMain(m *testing.M) {
+	dir, _ := os.MkdirTemp("", "webhook")
+	file = filepath.Join(dir, "webhooks.json")
+
+	os.Setenv("AETHER_WEBHOOKS_FILE", file)
+	utils.LoadConfig()
+
+	var state spotify.SpotifyPlayer
+	webhook.Init(&state)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestDelivery(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	var signatures []string
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		bodies = append(bodies, string(body))
+		signatures = append(signatures, r.Header.Get("X-Aether-Signature"))
+
+		// The bar-tab app is down for the first two tries
+		if len(bodies) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer receiver.Close()
+
+	viper.Set("webhooks.backoff", "10ms")
+	viper.Set("webhooks.hooks", []map[string]any{
+		{"url": receiver.URL, "events": []string{"trackChanged"}, "secret": "s3cret"},
+	})
+	utils.LoadConfig()
+
+	live.Publish("playbackPaused", nil)
+	live.Publish("trackChanged", map[string]any{"uri": "spotify:track:one"})
+
+	var attempts []webhook.Attempt
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		attempts = nil
+
+		for _, attempt := range webhook.Attempts(10) {
+			if attempt.URL == receiver.URL {
+				attempts = append(attempts, attempt)
+			}
+		}
+
+		if len(attempts) > 0 && attempts[0].Outcome == "delivered" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("the webhook was not delivered, attempts: %+v", attempts)
+		}
+	}
+
+	if len(attempts) != 3 || attempts[1].Outcome != "retrying" || attempts[1].Status != http.StatusBadGateway {
+		t.Fatalf("attempts %+v", attempts)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !strings.Contains(bodies[2], `"event":"trackChanged"`) {
+		t.Errorf("the body was %s", bodies[2])
+	}
+
+	if want := "sha256=" + webhook.Sign("s3cret", []byte(bodies[2])); signatures[2] != want {
+		t.Errorf("signature %s, expected %s", signatures[2], want)
+	}
+
+	if len(webhook.Pending()) != 0 {
+		t.Errorf("deliveries still pending: %+v", webhook.Pending())
+	}
+
+	// The sender saves right after delivering
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		saved, _ := os.ReadFile(file)
+
+		if strings.TrimSpace(string(saved)) == "[]" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("webhooks.file still holds %s", saved)
+		}
+	}
+}
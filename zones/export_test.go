@@ -0,0 +1,10 @@
+package zones
+
+import "time"
+
+/*
+Listen to a zone again right away in the tests.
+*/
+func init() {
+	reconnect = 10 * time.Millisecond
+}
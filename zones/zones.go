@@ -0,0 +1,518 @@
+package zones
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KokopelliMusic/go-lib/logger"
+	"github.com/ODDInvictus/aether/live"
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/utils"
+)
+
+// The zone of the player in the spotify section of the config
+const Main = "main"
+
+// Followers further apart than this from their leader are seeked to it
+const drift = time.Second
+
+// How long to wait before listening to a zone again after its event stream broke
+var reconnect = 5 * time.Second
+
+// The events of a leader lag behind, its followers are not seeked for this long after they were moved along with it
+const settle = time.Second
+
+// How often to skip forward looking for the track a zone played before an announcement, after loading its context
+const maxSkips = 100
+
+/*
+A room with its own librespot.
+*/
+type Zone struct {
+	Name   string
+	Client *spotify.Client
+	State  *spotify.SpotifyPlayer
+}
+
+/*
+What is known about a zone, for the API.
+*/
+type Info struct {
+	Name string `json:"name"`
+	// The zone this one plays along with, empty when it plays on its own
+	Following string              `json:"following,omitempty"`
+	State     spotify.PlayerState `json:"state"`
+}
+
+var mu sync.Mutex
+var zones []*Zone
+
+// Follower zone -> the zone it plays along with
+var following = map[string]string{}
+
+// The track every follower was last told to play, so it is not loaded twice
+var loaded = map[string]string{}
+
+// Leader -> when its group was last loaded or seeked together
+var moved = map[string]time.Time{}
+
+// An announcement plays in the zones, their events are not followed
+var announcing bool
+
+/*
+Set up a zone for every room in the config, next to the main player with state. Followers copy what their leader
+does from its events.
+*/
+func Init(state *spotify.SpotifyPlayer) {
+	mu.Lock()
+	zones = []*Zone{{Name: Main, Client: spotify.Main, State: state}}
+	following = map[string]string{}
+	loaded = map[string]string{}
+	moved = map[string]time.Time{}
+
+	for _, zone := range utils.Cfg().Zones {
+		zones = append(zones, &Zone{Name: zone.Name, Client: spotify.NewClient(zone.Name, zone.URL, zone.WS), State: &spotify.SpotifyPlayer{}})
+
+		if zone.Follow != "" {
+			following[zone.Name] = zone.Follow
+		}
+	}
+	mu.Unlock()
+
+	Log(fmt.Sprintf("Managing %d zones", len(zones)))
+
+	events, _ := live.Subscribe()
+
+	go func() {
+		for event := range events {
+			zone, name, data := Main, event.Type, event.Data
+
+			if event.Type == "zoneEvent" {
+				e, _ := event.Data.(map[string]interface{})
+				zone, name, data = fmt.Sprint(e["zone"]), fmt.Sprint(e["event"]), e["data"]
+			}
+
+			res, _ := data.(map[string]interface{})
+			follow(zone, name, res)
+		}
+	}()
+}
+
+/*
+Follow the events of every zone but main until ctx is cancelled, main is listened to by the caller. A zone whose
+event stream breaks is listened to again after a while.
+*/
+func Listen(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, zone := range all() {
+		if zone.Name == Main {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(zone *Zone) {
+			defer wg.Done()
+
+			for {
+				err := zone.Client.ListenToEvents(ctx, zone.State)
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				logger.Err("[Zones] Lost the event stream of "+zone.Name, err)
+
+				select {
+				case <-time.After(reconnect):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(zone)
+	}
+
+	wg.Wait()
+}
+
+/*
+Returns the zone called name.
+*/
+func Get(name string) (*Zone, bool) {
+	for _, zone := range all() {
+		if zone.Name == name {
+			return zone, true
+		}
+	}
+
+	return nil, false
+}
+
+/*
+Returns every zone, main first.
+*/
+func List() []Info {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]Info, len(zones))
+
+	for i, zone := range zones {
+		list[i] = Info{Name: zone.Name, Following: following[zone.Name], State: zone.State.Snapshot()}
+	}
+
+	return list
+}
+
+/*
+Returns the zones that play along with leader.
+*/
+func Followers(leader string) []*Zone {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var list []*Zone
+
+	for _, zone := range zones {
+		if following[zone.Name] == leader {
+			list = append(list, zone)
+		}
+	}
+
+	return list
+}
+
+/*
+Make zone play along with leader, starting with what leader is playing right now. A zone can not follow a zone
+that follows another zone itself, it follows that one instead.
+*/
+func Join(name string, leader string) error {
+	zone, ok := Get(name)
+	_, leaderOk := Get(leader)
+
+	if !ok || !leaderOk {
+		return errors.New("there is no such zone")
+	}
+
+	mu.Lock()
+
+	if top, ok := following[leader]; ok {
+		leader = top
+	}
+
+	if leader == name {
+		mu.Unlock()
+		return fmt.Errorf("%s can not follow itself", name)
+	}
+
+	following[name] = leader
+
+	// The followers of name follow its new leader from now on
+	for follower, l := range following {
+		if l == name {
+			following[follower] = leader
+		}
+	}
+	mu.Unlock()
+
+	Log(fmt.Sprintf("%s follows %s", name, leader))
+	live.Publish("zoneGrouped", map[string]string{"zone": name, "following": leader})
+
+	lead, _ := Get(leader)
+	state := lead.State.Snapshot()
+
+	if state.URI == "" {
+		return nil
+	}
+
+	return catchUp(zone, state)
+}
+
+/*
+Let zone play on its own again, it keeps playing what it was playing.
+*/
+func Leave(name string) error {
+	if _, ok := Get(name); !ok {
+		return errors.New("there is no such zone")
+	}
+
+	mu.Lock()
+	delete(following, name)
+	delete(loaded, name)
+	mu.Unlock()
+
+	Log(name + " plays on its own")
+	live.Publish("zoneGrouped", map[string]string{"zone": name, "following": ""})
+
+	return nil
+}
+
+/*
+Load uri in zone and every zone that follows it at the same time. Loading something in a follower makes it leave its group.
+*/
+func Load(name string, uri string, startPlaying bool, shuffle bool) (bool, error) {
+	zone, ok := Get(name)
+
+	if !ok {
+		return false, errors.New("there is no such zone")
+	}
+
+	mu.Lock()
+	_, follower := following[name]
+	mu.Unlock()
+
+	if follower {
+		Leave(name)
+	}
+
+	load := func(z *Zone) (bool, error) {
+		if z.Name == Main {
+			return spotify.Load(uri, startPlaying, shuffle)
+		}
+
+		return z.Client.Load(uri, startPlaying, shuffle)
+	}
+
+	members := Followers(name)
+
+	if strings.HasPrefix(uri, "spotify:track:") {
+		mu.Lock()
+		for _, member := range members {
+			loaded[member.Name] = uri
+		}
+		mu.Unlock()
+	}
+
+	// A shuffled context starts on another track in every zone, the followers catch up when the leader's track starts
+	return together(zone, members, load)
+}
+
+/*
+Seek to pos in zone and every zone that follows it at the same time.
+*/
+func Seek(name string, pos int) (bool, error) {
+	zone, ok := Get(name)
+
+	if !ok {
+		return false, errors.New("there is no such zone")
+	}
+
+	return together(zone, Followers(name), func(z *Zone) (bool, error) {
+		return z.Client.Seek(pos)
+	})
+}
+
+/*
+Play uri in every zone but main, like an announcement that aether serves over http. Returns a function that puts
+back what the zones were playing before, the main player is left to the caller. The zones do not follow each other
+until then.
+*/
+func Announce(uri string) func() {
+	var restores []func()
+	var wg sync.WaitGroup
+	var restoreMu sync.Mutex
+
+	mu.Lock()
+	announcing = true
+	mu.Unlock()
+
+	for _, zone := range all() {
+		if zone.Name == Main {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(zone *Zone) {
+			defer wg.Done()
+
+			state := zone.State.Snapshot()
+			// Measured now, the zone would otherwise continue where it would have been after the announcement
+			pos := int(state.Position())
+
+			if _, err := zone.Client.Load(uri, true, false); err != nil {
+				logger.Err("[Zones] "+zone.Name+" could not play the announcement", err)
+				return
+			}
+
+			restoreMu.Lock()
+			restores = append(restores, func() { restore(zone, state, pos) })
+			restoreMu.Unlock()
+		}(zone)
+	}
+
+	wg.Wait()
+
+	if len(restores) > 0 {
+		Log(fmt.Sprintf("Announcing in %d zones", len(restores)))
+	}
+
+	return func() {
+		var wg sync.WaitGroup
+
+		for _, restore := range restores {
+			wg.Add(1)
+
+			go func(restore func()) {
+				defer wg.Done()
+				restore()
+			}(restore)
+		}
+
+		wg.Wait()
+
+		mu.Lock()
+		announcing = false
+		mu.Unlock()
+	}
+}
+
+/*
+Make zone play what it played before an announcement, from its state back then and the position at that time.
+Like resuming a session, the context is loaded first so the zone keeps playing after the track.
+*/
+func restore(zone *Zone, state spotify.PlayerState, pos int) {
+	if state.URI == "" {
+		zone.Client.Pause()
+		return
+	}
+
+	context := state.ContextURI
+
+	if context == "" {
+		context = state.URI
+	}
+
+	mu.Lock()
+	loaded[zone.Name] = state.URI
+	mu.Unlock()
+
+	if _, err := zone.Client.Load(context, false, false); err != nil {
+		logger.Err("[Zones] "+zone.Name+" could not go back to "+context, err)
+		return
+	}
+
+	if !zone.Client.SkipTo(state.URI, maxSkips) {
+		zone.Client.Load(state.URI, false, false)
+	}
+
+	zone.Client.Seek(pos)
+
+	if !state.Paused {
+		zone.Client.Resume()
+	}
+}
+
+/*
+Copy an event of zone to the zones that follow it.
+*/
+func follow(name string, event string, res map[string]interface{}) {
+	members := Followers(name)
+
+	mu.Lock()
+	busy := announcing
+	mu.Unlock()
+
+	if len(members) == 0 || busy {
+		return
+	}
+
+	leader, _ := Get(name)
+	trackTime, _ := res["trackTime"].(float64)
+
+	mu.Lock()
+	settled := time.Since(moved[name]) > settle
+	mu.Unlock()
+
+	for _, member := range members {
+		var err error
+
+		switch event {
+		case "trackChanged":
+			// Both start at the beginning of the track, unless the follower already loaded it with the leader
+			mu.Lock()
+			known := loaded[member.Name] == fmt.Sprint(res["uri"])
+			loaded[member.Name] = fmt.Sprint(res["uri"])
+			mu.Unlock()
+
+			if !known {
+				_, err = member.Client.Load(fmt.Sprint(res["uri"]), !leader.State.Snapshot().Paused, false)
+			}
+		case "playbackPaused":
+			_, err = member.Client.Pause()
+		case "playbackResumed", "trackSeeked":
+			if event == "playbackResumed" {
+				_, err = member.Client.Resume()
+			}
+
+			if off := time.Duration(int64(trackTime)-member.State.Snapshot().Position()) * time.Millisecond; err == nil && settled && (off > drift || off < -drift) {
+				_, err = member.Client.Seek(int(trackTime))
+			}
+		}
+
+		if err != nil {
+			logger.Err(fmt.Sprintf("[Zones] %s could not follow %s", member.Name, event), err)
+		}
+	}
+}
+
+/*
+Make zone play what its leader plays, from state of the leader.
+*/
+func catchUp(zone *Zone, state spotify.PlayerState) error {
+	mu.Lock()
+	loaded[zone.Name] = state.URI
+	mu.Unlock()
+
+	if _, err := zone.Client.Load(state.URI, !state.Paused, false); err != nil {
+		return err
+	}
+
+	_, err := zone.Client.Seek(int(state.Position()))
+
+	return err
+}
+
+/*
+Call fn for zone and its members at the same time, returns the result of zone. Members that fail are logged.
+*/
+func together(zone *Zone, members []*Zone, fn func(*Zone) (bool, error)) (bool, error) {
+	var wg sync.WaitGroup
+
+	mu.Lock()
+	moved[zone.Name] = time.Now()
+	mu.Unlock()
+
+	for _, member := range members {
+		wg.Add(1)
+
+		go func(member *Zone) {
+			defer wg.Done()
+
+			if ok, err := fn(member); !ok {
+				logger.Err("[Zones] "+member.Name+" did not follow along", err)
+			}
+		}(member)
+	}
+
+	ok, err := fn(zone)
+	wg.Wait()
+
+	return ok, err
+}
+
+func all() []*Zone {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]*Zone{}, zones...)
+}
+
+func Log(str string) {
+	logger.Verbose("[Zones] " + str)
+}
@@ -0,0 +1,199 @@
+package zones_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ODDInvictus/aether/spotify"
+	"github.com/ODDInvictus/aether/spotify/spotifytest"
+	"github.com/ODDInvictus/aether/utils"
+	"github.com/ODDInvictus/aether/zones"
+	"github.com/spf13/viper"
+)
+
+var state spotify.SpotifyPlayer
+
+// The librespot of the bar, which follows the main player
+var bar *spotifytest.Server
+
+func TestMain(m *testing.M) {
+	bar = spotifytest.NewServer()
+
+	viper.Set("zones", []map[string]any{{"name": "bar", "url": bar.URL, "ws": bar.Listener.Addr().String(), "follow": "main"}})
+	utils.LoadConfig()
+
+	zones.Init(&state)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go zones.Listen(ctx)
+
+	code := m.Run()
+
+	cancel()
+	bar.Close()
+	os.Exit(code)
+}
+
+/*
+Start a fake main player, with the bar following it again.
+*/
+func fake(t *testing.T) *spotifytest.Server {
+	s := spotifytest.Start(t)
+
+	for _, srv := range []*spotifytest.Server{s, bar} {
+		srv.AddTrack("spotify:track:one", spotify.Track{Name: "Highway to Hell", Duration: 208000})
+		srv.AddTrack("spotify:track:two", spotify.Track{Name: "Thunderstruck", Duration: 292000})
+	}
+
+	s.Listen(t, &state)
+
+	if !bar.WaitForListener(time.Second) {
+		t.Fatal("the bar is not listened to")
+	}
+
+	if err := zones.Join("bar", zones.Main); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func loads(s *spotifytest.Server, uri string) int {
+	n := 0
+
+	for _, call := range s.Calls() {
+		if strings.HasPrefix(call, "POST /player/load?uri="+url.QueryEscape(uri)+"&") {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestFollow(t *testing.T) {
+	s := fake(t)
+
+	// The bar picks up what the main player starts by itself
+	spotify.Load("spotify:track:one", true, false)
+
+	spotifytest.Eventually(t, "the bar to play along", func() bool {
+		return bar.State().Track == "spotify:track:one" && !bar.State().Paused
+	})
+
+	spotify.Pause()
+
+	spotifytest.Eventually(t, "the bar to pause", func() bool { return bar.State().Paused })
+
+	spotify.Resume()
+
+	// Loading and seeking the group happens in every zone at once, the bar is not loaded again after
+	before := loads(bar, "spotify:track:two")
+
+	if ok, err := zones.Load(zones.Main, "spotify:track:two", true, false); !ok {
+		t.Fatal(err)
+	}
+
+	if ok, err := zones.Seek(zones.Main, 60000); !ok {
+		t.Fatal(err)
+	}
+
+	for _, srv := range []*spotifytest.Server{s, bar} {
+		if got := srv.State(); got.Track != "spotify:track:two" || got.Position < 60000 || got.Position > 61000 {
+			t.Errorf("playing %s at %d", got.Track, got.Position)
+		}
+	}
+
+	spotifytest.Eventually(t, "the leader's track to start", func() bool { return state.Snapshot().URI == "spotify:track:two" })
+	time.Sleep(100 * time.Millisecond)
+
+	if n := loads(bar, "spotify:track:two") - before; n != 1 {
+		t.Errorf("loaded Thunderstruck %d times in the bar", n)
+	}
+}
+
+func TestLeave(t *testing.T) {
+	fake(t)
+
+	spotify.Load("spotify:track:one", true, false)
+	spotifytest.Eventually(t, "the bar to play along", func() bool { return bar.State().Track == "spotify:track:one" })
+
+	zones.Leave("bar")
+
+	if list := zones.List(); len(list) != 2 || list[1].Following != "" {
+		t.Errorf("zones %+v", list)
+	}
+
+	spotify.Load("spotify:track:two", true, false)
+	spotifytest.Eventually(t, "the main player to change track", func() bool { return state.Snapshot().URI == "spotify:track:two" })
+	time.Sleep(100 * time.Millisecond)
+
+	if bar.State().Track != "spotify:track:one" {
+		t.Errorf("the bar followed along to %s on its own", bar.State().Track)
+	}
+
+	// Joining again catches up right away
+	if err := zones.Join("bar", zones.Main); err != nil {
+		t.Fatal(err)
+	}
+
+	if bar.State().Track != "spotify:track:two" {
+		t.Errorf("the bar plays %s after joining", bar.State().Track)
+	}
+
+	if err := zones.Join(zones.Main, "bar"); err == nil {
+		t.Error("main followed its own follower")
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	fake(t)
+	zones.Leave("bar")
+
+	clip := "http://aether:8080/announce/1/audio"
+	bar.AddTrack(clip, spotify.Track{Name: "Doorbell"})
+	bar.AddContext("spotify:playlist:bar", "spotify:track:one", "spotify:track:two")
+	zones.Load("bar", "spotify:playlist:bar", true, false)
+	zones.Seek("bar", 30000)
+	spotifytest.Eventually(t, "the bar state to be known", func() bool { s := zoneState("bar"); return s.URI == "spotify:track:one" && s.TrackTime == 30000 })
+
+	restore := zones.Announce(clip)
+
+	if got := bar.State(); got.Track != clip || got.Paused {
+		t.Errorf("the bar plays %s during an announcement", got.Track)
+	}
+
+	restore()
+
+	// Back in the playlist where it was, so it goes on to the next track after this one
+	if got := bar.State(); got.Context != "spotify:playlist:bar" || got.Track != "spotify:track:one" || got.Paused || got.Position < 30000 || got.Position > 31000 {
+		t.Errorf("after the announcement the bar plays %+v", got)
+	}
+}
+
+func zoneState(name string) spotify.PlayerState {
+	zone, _ := zones.Get(name)
+
+	return zone.State.Snapshot()
+}
+
+func TestReconnect(t *testing.T) {
+	zone, _ := zones.Get("bar")
+
+	if !bar.WaitForListener(time.Second) {
+		t.Fatal("the bar is not listened to")
+	}
+
+	bar.DropSockets()
+
+	if !bar.WaitForListener(time.Second) {
+		t.Fatal("the bar was not listened to again after its event stream broke")
+	}
+
+	bar.Emit("trackChanged", map[string]any{"uri": "spotify:track:two"})
+
+	spotifytest.Eventually(t, "the event of the bar", func() bool { return zone.State.Snapshot().URI == "spotify:track:two" })
+}